@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/status"
+)
+
+// restic message types from "restic backup --json"
+const (
+	backupMessageStatus        = "status"
+	backupMessageVerboseStatus = "verbose_status"
+	backupMessageSummary       = "summary"
+)
+
+// backupMessage is the part of a JSON message from restic we need to know what to do with it
+type backupMessage struct {
+	MessageType string `json:"message_type"`
+	Action      string `json:"action"`
+	Item        string `json:"item"`
+}
+
+// backupOutput is reading the JSON output of a restic backup to keep the summary of the backup.
+// The messages are displayed like restic would without the --json flag (the progress status is dropped),
+// unless the JSON output was requested in the configuration (raw): it's then displayed as is
+type backupOutput struct {
+	output  io.Writer
+	raw     bool
+	buffer  []byte
+	summary *status.BackupSummary
+}
+
+func newBackupOutput(output io.Writer, raw bool) *backupOutput {
+	return &backupOutput{
+		output: output,
+		raw:    raw,
+	}
+}
+
+// Write reads the output of restic, one line at a time
+func (o *backupOutput) Write(p []byte) (int, error) {
+	o.buffer = append(o.buffer, p...)
+	for {
+		index := bytes.IndexByte(o.buffer, '\n')
+		if index < 0 {
+			break
+		}
+		err := o.writeLine(o.buffer[:index+1])
+		o.buffer = o.buffer[index+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line when it wasn't terminated by a new line
+func (o *backupOutput) Flush() error {
+	if len(o.buffer) == 0 {
+		return nil
+	}
+	err := o.writeLine(o.buffer)
+	o.buffer = nil
+	return err
+}
+
+// Summary returns the summary of the backup, or nil if restic didn't send one
+func (o *backupOutput) Summary() *status.BackupSummary {
+	return o.summary
+}
+
+func (o *backupOutput) writeLine(line []byte) error {
+	content := bytes.TrimSpace(line)
+	if len(content) == 0 || content[0] != '{' {
+		// not a JSON message
+		return o.write(line)
+	}
+	message := backupMessage{}
+	err := json.Unmarshal(content, &message)
+	if err != nil || message.MessageType == "" {
+		return o.write(line)
+	}
+	if message.MessageType == backupMessageSummary {
+		summary := &status.BackupSummary{}
+		if json.Unmarshal(content, summary) == nil {
+			o.summary = summary
+		}
+	}
+	if o.raw {
+		return o.write(line)
+	}
+	switch message.MessageType {
+	case backupMessageStatus:
+		return nil
+	case backupMessageVerboseStatus:
+		_, err = fmt.Fprintf(o.output, "%-9s %s\n", message.Action, message.Item)
+		return err
+	case backupMessageSummary:
+		if o.summary == nil {
+			return o.write(line)
+		}
+		_, err = io.WriteString(o.output, formatBackupSummary(o.summary))
+		return err
+	default:
+		return o.write(line)
+	}
+}
+
+func (o *backupOutput) write(line []byte) error {
+	_, err := o.output.Write(line)
+	return err
+}
+
+// formatBackupSummary displays the summary like restic does at the end of a backup
+func formatBackupSummary(summary *status.BackupSummary) string {
+	snapshotID := summary.SnapshotID
+	if len(snapshotID) > 8 {
+		snapshotID = snapshotID[:8]
+	}
+	return fmt.Sprintf("\nFiles:       %5d new, %5d changed, %5d unmodified\n", summary.FilesNew, summary.FilesChanged, summary.FilesUnmodified) +
+		fmt.Sprintf("Dirs:        %5d new, %5d changed, %5d unmodified\n", summary.DirsNew, summary.DirsChanged, summary.DirsUnmodified) +
+		fmt.Sprintf("Added to the repo: %s\n", formatBytes(summary.DataAdded)) +
+		fmt.Sprintf("\nprocessed %d files, %s in %s\n", summary.TotalFilesProcessed, formatBytes(summary.TotalBytesProcessed), formatSeconds(summary.TotalDuration)) +
+		fmt.Sprintf("snapshot %s saved\n", snapshotID)
+}
+
+// formatBytes displays a size in bytes using the binary units
+func formatBytes(size uint64) string {
+	value := float64(size)
+	switch {
+	case size >= 1<<40:
+		return fmt.Sprintf("%.3f TiB", value/(1<<40))
+	case size >= 1<<30:
+		return fmt.Sprintf("%.3f GiB", value/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.3f MiB", value/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.3f KiB", value/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+// formatSeconds displays a duration like a clock: "m:ss" or "h:mm:ss"
+func formatSeconds(seconds float64) string {
+	total := int64(time.Duration(seconds*float64(time.Second)) / time.Second)
+	hours := total / 3600
+	minutes := (total % 3600) / 60
+	total %= 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, total)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, total)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBackupJSONOutput = `open repository
+{"message_type":"status","percent_done":0,"total_files":1,"total_bytes":12}
+{"message_type":"verbose_status","action":"new","item":"/source/file","duration":0.001,"data_size":12,"metadata_size":0,"total_files":0}
+{"message_type":"status","percent_done":1,"total_files":3,"files_done":3,"total_bytes":2048,"bytes_done":2048}
+{"message_type":"summary","files_new":2,"files_changed":1,"files_unmodified":5,"dirs_new":1,"dirs_changed":0,"dirs_unmodified":2,"data_blobs":3,"tree_blobs":1,"data_added":1572864,"total_files_processed":8,"total_bytes_processed":3221225472,"total_duration":83.6,"snapshot_id":"40dc1520b6f0c9c1a5d7e8f2c1ab4e7d2b3c4d5e6f708192a3b4c5d6e7f80912"}
+`
+
+func TestBackupOutput(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	output := newBackupOutput(buffer, false)
+	// send the output in small chunks, not aligned on the lines
+	for i := 0; i < len(testBackupJSONOutput); i += 7 {
+		end := i + 7
+		if end > len(testBackupJSONOutput) {
+			end = len(testBackupJSONOutput)
+		}
+		_, err := output.Write([]byte(testBackupJSONOutput[i:end]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, output.Flush())
+
+	assert.Equal(t, `open repository
+new       /source/file
+
+Files:           2 new,     1 changed,     5 unmodified
+Dirs:            1 new,     0 changed,     2 unmodified
+Added to the repo: 1.500 MiB
+
+processed 8 files, 3.000 GiB in 1:23
+snapshot 40dc1520 saved
+`, buffer.String())
+
+	summary := output.Summary()
+	require.NotNil(t, summary)
+	assert.Equal(t, "40dc1520b6f0c9c1a5d7e8f2c1ab4e7d2b3c4d5e6f708192a3b4c5d6e7f80912", summary.SnapshotID)
+	assert.Equal(t, 2, summary.FilesNew)
+	assert.Equal(t, 1, summary.FilesChanged)
+	assert.Equal(t, 5, summary.FilesUnmodified)
+	assert.Equal(t, uint64(1572864), summary.DataAdded)
+	assert.Equal(t, uint64(3221225472), summary.TotalBytesProcessed)
+}
+
+func TestBackupOutputRaw(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	output := newBackupOutput(buffer, true)
+	_, err := output.Write([]byte(testBackupJSONOutput))
+	require.NoError(t, err)
+	require.NoError(t, output.Flush())
+
+	assert.Equal(t, testBackupJSONOutput, buffer.String())
+	require.NotNil(t, output.Summary())
+	assert.Equal(t, 8, output.Summary().TotalFilesProcessed)
+}
+
+func TestBackupOutputWithoutSummary(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	output := newBackupOutput(buffer, false)
+	_, err := output.Write([]byte("Fatal: unable to open repository\n{not json}\nno new line"))
+	require.NoError(t, err)
+	require.NoError(t, output.Flush())
+
+	assert.Equal(t, "Fatal: unable to open repository\n{not json}\nno new line", buffer.String())
+	assert.Nil(t, output.Summary())
+}
+
+func TestFormatBytes(t *testing.T) {
+	testData := []struct {
+		size     uint64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.000 KiB"},
+		{1536, "1.500 KiB"},
+		{5 * 1024 * 1024, "5.000 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.000 GiB"},
+		{2 * 1024 * 1024 * 1024 * 1024, "2.000 TiB"},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.expected, formatBytes(testItem.size))
+	}
+}
+
+func TestFormatSeconds(t *testing.T) {
+	testData := []struct {
+		seconds  float64
+		expected string
+	}{
+		{0, "0:00"},
+		{0.9, "0:00"},
+		{61.2, "1:01"},
+		{3600, "1:00:00"},
+		{7384, "2:03:04"},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.expected, formatSeconds(testItem.seconds))
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/creativeprojects/resticprofile/constants"
 	"github.com/creativeprojects/resticprofile/remote"
 	"github.com/creativeprojects/resticprofile/schedule"
+	"github.com/creativeprojects/resticprofile/shell"
 	"github.com/creativeprojects/resticprofile/term"
 	"github.com/creativeprojects/resticprofile/win"
 )
@@ -54,6 +55,7 @@ var (
 			description:       "display profile names from the configuration file",
 			action:            displayProfilesCommand,
 			needConfiguration: true,
+			flags:             map[string]string{"--label": "display only the profiles with this label"},
 		},
 		{
 			name:              "show",
@@ -61,6 +63,18 @@ var (
 			action:            showProfile,
 			needConfiguration: true,
 		},
+		{
+			name:              "validate",
+			description:       "check the configuration file for unknown flags and invalid values",
+			action:            validateConfiguration,
+			needConfiguration: true,
+		},
+		{
+			name:              "schema",
+			description:       "generate a JSON schema of the configuration file (to validate and autocomplete profiles in your editor)",
+			action:            generateSchema,
+			needConfiguration: false,
+		},
 		{
 			name:              "random-key",
 			description:       "generate a cryptographically secure random key to use as a restic keyfile",
@@ -89,6 +103,21 @@ var (
 			needConfiguration: true,
 			hide:              false,
 		},
+		{
+			name:              "ps",
+			description:       "display the profiles currently running, from their lockfiles",
+			action:            displayRunningProfiles,
+			needConfiguration: true,
+			hide:              false,
+		},
+		{
+			name:              "stop",
+			description:       "stop the processes of a running profile (SIGTERM, then SIGKILL after a grace period)",
+			action:            stopProfile,
+			needConfiguration: true,
+			hide:              false,
+			flags:             map[string]string{"--grace-period": "delay before killing the processes still running (default 30s)"},
+		},
 		// hidden commands
 		{
 			name:              "elevation",
@@ -144,7 +173,20 @@ func runOwnCommand(configuration *config.Config, command string, flags commandLi
 	return fmt.Errorf("command not found: %v", command)
 }
 
-func displayProfilesCommand(output io.Writer, configuration *config.Config, _ commandLineFlags, _ []string) error {
+// displayProfilesCommand accepts one argument from the commandline: --label <name>
+func displayProfilesCommand(output io.Writer, configuration *config.Config, _ commandLineFlags, args []string) error {
+	label := ""
+	for i, arg := range args {
+		if arg == "--label" && i+1 < len(args) {
+			label = args[i+1]
+		} else if strings.HasPrefix(arg, "--label=") {
+			label = strings.TrimPrefix(arg, "--label=")
+		}
+	}
+	if label != "" {
+		displayProfilesWithLabel(output, configuration, label)
+		return nil
+	}
 	displayProfiles(output, configuration)
 	displayGroups(output, configuration)
 	return nil
@@ -183,24 +225,80 @@ func displayVersion(output io.Writer, _ *config.Config, flags commandLineFlags,
 
 func displayProfiles(output io.Writer, configuration *config.Config) {
 	profileSections := configuration.GetProfileSections()
-	keys := sortedMapKeys(profileSections)
 	if len(profileSections) == 0 {
 		fmt.Fprintln(output, "\nThere's no available profile in the configuration")
 	} else {
 		fmt.Fprintln(output, "\nProfiles available:")
-		w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
-		for _, name := range keys {
-			sections := profileSections[name]
-			sort.Strings(sections)
-			if len(sections) == 0 {
-				_, _ = fmt.Fprintf(w, "\t%s:\t(n/a)\n", name)
-			} else {
-				_, _ = fmt.Fprintf(w, "\t%s:\t(%s)\n", name, strings.Join(sections, ", "))
+		displayProfileList(output, configuration, sortedMapKeys(profileSections))
+	}
+	fmt.Fprintln(output, "")
+}
+
+// displayProfilesWithLabel displays only the profiles declaring the label
+func displayProfilesWithLabel(output io.Writer, configuration *config.Config, label string) {
+	names, err := configuration.GetProfilesWithLabel(label)
+	if err != nil {
+		clog.Warning(err)
+	}
+	if len(names) == 0 {
+		fmt.Fprintf(output, "\nThere's no profile with label '%s' in the configuration\n", label)
+	} else {
+		fmt.Fprintf(output, "\nProfiles with label '%s':\n", label)
+		displayProfileList(output, configuration, names)
+	}
+	fmt.Fprintln(output, "")
+}
+
+func displayProfileList(output io.Writer, configuration *config.Config, names []string) {
+	profileSections := configuration.GetProfileSections()
+	profileFiles := getProfileFiles(configuration)
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		sections := profileSections[name]
+		labels := ""
+		// also display the sections inherited from the parent profiles
+		if profile, err := configuration.GetProfile(name); err == nil && profile != nil {
+			sections = profile.DefinedCommands()
+			if len(profile.Labels) > 0 {
+				labels = fmt.Sprintf(" [%s]", strings.Join(profile.Labels, ", "))
 			}
 		}
-		_ = w.Flush()
+		sort.Strings(sections)
+		from := ""
+		if files, ok := profileFiles[name]; ok {
+			from = fmt.Sprintf("\tfrom %s", strings.Join(files, ", "))
+		}
+		if len(sections) == 0 {
+			_, _ = fmt.Fprintf(w, "\t%s:\t(n/a)%s%s\n", name, labels, from)
+		} else {
+			_, _ = fmt.Fprintf(w, "\t%s:\t(%s)%s%s\n", name, strings.Join(sections, ", "), labels, from)
+		}
 	}
-	fmt.Fprintln(output, "")
+	_ = w.Flush()
+}
+
+// getProfileFiles returns the configuration file(s) declaring each profile,
+// but only when the configuration is split into multiple files
+func getProfileFiles(configuration *config.Config) map[string][]string {
+	if len(configuration.GetIncludes()) == 0 {
+		return nil
+	}
+	profileFiles, err := configuration.GetProfileFiles()
+	if err != nil {
+		clog.Warning(err)
+		return nil
+	}
+	// display the files relative to the main configuration file when possible
+	rootPath := filepath.Dir(configuration.GetConfigFile())
+	for name, files := range profileFiles {
+		for i, file := range files {
+			if relative, err := filepath.Rel(rootPath, file); err == nil && !strings.HasPrefix(relative, "..") {
+				files[i] = relative
+			}
+		}
+		profileFiles[name] = files
+	}
+	return profileFiles
 }
 
 func displayGroups(output io.Writer, configuration *config.Config) {
@@ -210,8 +308,12 @@ func displayGroups(output io.Writer, configuration *config.Config) {
 	}
 	fmt.Fprintln(output, "Groups available:")
 	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
-	for name, groupList := range groups {
-		_, _ = fmt.Fprintf(w, "\t%s:\t%s\n", name, strings.Join(groupList, ", "))
+	for _, name := range sortedMapKeys(groups) {
+		description := ""
+		if group, err := configuration.GetGroup(name); err == nil && group.Description != "" {
+			description = "\t" + group.Description
+		}
+		_, _ = fmt.Fprintf(w, "\t%s:\t%s%s\n", name, strings.Join(groups[name], ", "), description)
 	}
 	_ = w.Flush()
 	fmt.Fprintln(output, "")
@@ -274,12 +376,34 @@ func showProfile(output io.Writer, c *config.Config, flags commandLineFlags, arg
 		clog.Debugf("files in configuration are relative to '%s'", rootPath)
 	}
 	profile.SetRootPath(rootPath)
+	if global.ResticShell != shell.NoShell {
+		// the paths are interpreted by the shell running restic
+		profile.EscapeShellPaths()
+	}
 
 	config.ShowStruct(os.Stdout, profile, flags.name)
 	fmt.Println("")
 	return nil
 }
 
+// validateConfiguration displays all the issues found in the configuration, and returns an error if any
+func validateConfiguration(output io.Writer, c *config.Config, flags commandLineFlags, args []string) error {
+	issues := c.Validate()
+	for _, issue := range issues {
+		_, _ = fmt.Fprintln(output, issue.String())
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("found %d error(s) in the configuration", len(issues))
+	}
+	_, _ = fmt.Fprintln(output, "configuration is valid")
+	return nil
+}
+
+// generateSchema displays the JSON schema of the configuration file
+func generateSchema(output io.Writer, c *config.Config, flags commandLineFlags, args []string) error {
+	return config.GenerateJSONSchema(output)
+}
+
 // randomKey simply display a base64'd random key to the console
 func randomKey(output io.Writer, c *config.Config, flags commandLineFlags, args []string) error {
 	var err error
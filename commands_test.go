@@ -125,3 +125,43 @@ schedule = "daily"
 
 	assert.Equal(t, 1, declaredCount)
 }
+
+func TestDisplayGroups(t *testing.T) {
+	testConfig := `
+[groups]
+servers = ["web", "database"]
+[groups.all]
+description = "everything"
+profiles = ["servers", "laptop"]
+`
+	parsedConfig, err := config.Load(bytes.NewBufferString(testConfig), "toml")
+	assert.Nil(t, err)
+
+	buffer := &strings.Builder{}
+	displayGroups(buffer, parsedConfig)
+	assert.Equal(t, "Groups available:\n  all:      servers, laptop  everything\n  servers:  web, database\n\n", buffer.String())
+}
+
+func TestDisplayProfilesWithLabel(t *testing.T) {
+	testConfig := `
+[home]
+labels = ["nightly", "laptop"]
+[home.backup]
+source = "/home"
+[server]
+labels = ["nightly"]
+[photos]
+`
+	parsedConfig, err := config.Load(bytes.NewBufferString(testConfig), "toml")
+	assert.Nil(t, err)
+
+	buffer := &strings.Builder{}
+	err = displayProfilesCommand(buffer, parsedConfig, commandLineFlags{}, []string{"--label", "nightly"})
+	assert.NoError(t, err)
+	assert.Equal(t, "\nProfiles with label 'nightly':\n  home:    (backup) [nightly, laptop]\n  server:  (n/a) [nightly]\n\n", buffer.String())
+
+	buffer.Reset()
+	err = displayProfilesCommand(buffer, parsedConfig, commandLineFlags{}, []string{"--label=monthly"})
+	assert.NoError(t, err)
+	assert.Equal(t, "\nThere's no profile with label 'monthly' in the configuration\n\n", buffer.String())
+}
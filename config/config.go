@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -23,7 +24,15 @@ type Config struct {
 	format         string
 	configFile     string
 	viper          *viper.Viper
-	groups         map[string][]string
+	groups         map[string]*Group
+	sourceTemplate *template.Template
+	includes       []*includeTemplate
+}
+
+// includeTemplate is a configuration file merged on top of the main configuration file
+type includeTemplate struct {
+	filename       string
+	format         string
 	sourceTemplate *template.Template
 }
 
@@ -32,19 +41,21 @@ type Config struct {
 // Most configuration file formats allow only one declaration per section
 // This is not the case for HCL where you can declare a bloc multiple times:
 //
-// "global" {
-//   key1 = "value"
-// }
+//	"global" {
+//	  key1 = "value"
+//	}
 //
-// "global" {
-//   key2 = "value"
-// }
+//	"global" {
+//	  key2 = "value"
+//	}
 //
 // For that matter, viper creates a slice of maps instead of a map for the other configuration file formats
 // This configOptionHCL deals with the slice to merge it into a single map
+//
+// Both options also accept the short forms of the shell commands (run-before, run-after, etc.) and of the groups
 var (
-	configOption    = viper.DecodeHook(nil)
-	configOptionHCL = viper.DecodeHook(sliceOfMapsToMapHookFunc())
+	configOption    = viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(shellCommandsHookFunc(), groupHookFunc()))
+	configOptionHCL = viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(sliceOfMapsToMapHookFunc(), shellCommandsHookFunc(), groupHookFunc()))
 )
 
 // newConfig instantiate a new Config object
@@ -103,15 +114,20 @@ func (c *Config) loadTemplate(input io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("cannot execute %w", err)
 	}
-	traceConfig("default", buffer.String())
-	return c.load(buffer)
+	traceConfig("default", c.configFile, buffer.String())
+	err = c.load(buffer)
+	if err != nil {
+		return err
+	}
+	err = c.loadIncludes()
+	if err != nil {
+		return err
+	}
+	return c.mergeIncludes("default")
 }
 
 func (c *Config) load(input io.Reader) error {
-	// For compatibility with the previous versions, a .conf file is TOML format
-	if c.format == "conf" {
-		c.format = "toml"
-	}
+	c.format = getFormat(c.format)
 	c.viper.SetConfigType(c.format)
 	err := c.viper.ReadConfig(input)
 	if err != nil {
@@ -120,6 +136,174 @@ func (c *Config) load(input io.Reader) error {
 	return nil
 }
 
+// loadIncludes compiles the templates of all the files declared in the "includes" key.
+// The file names can be glob patterns relative to the main configuration file
+func (c *Config) loadIncludes() error {
+	c.includes = nil
+	filenames, err := c.getIncludeFiles()
+	if err != nil {
+		return err
+	}
+	return c.compileIncludes(filenames)
+}
+
+// compileIncludes compiles the templates of the files and adds them to the list of included files
+func (c *Config) compileIncludes(filenames []string) error {
+	for _, filename := range filenames {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("cannot open included file '%s': %w", filename, err)
+		}
+		sourceTemplate, err := template.New(filepath.Base(filename)).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("cannot compile included file '%s': %w", filename, err)
+		}
+		clog.Debugf("including configuration file '%s'", filename)
+		c.includes = append(c.includes, &includeTemplate{
+			filename:       filename,
+			format:         getFormat(strings.TrimPrefix(filepath.Ext(filename), ".")),
+			sourceTemplate: sourceTemplate,
+		})
+	}
+	return nil
+}
+
+// AddIncludes merges more configuration files on top of the current configuration,
+// after the files declared in the "includes" key
+func (c *Config) AddIncludes(filenames []string) error {
+	if len(filenames) == 0 {
+		return nil
+	}
+	err := c.compileIncludes(filenames)
+	if err != nil {
+		return err
+	}
+	c.groups = nil
+	return c.reloadTemplate(newTemplateData(c.configFile, "default"))
+}
+
+// GetIncludes returns the list of configuration files merged into the main configuration file
+func (c *Config) GetIncludes() []string {
+	filenames := make([]string, len(c.includes))
+	for i, include := range c.includes {
+		filenames[i] = include.filename
+	}
+	return filenames
+}
+
+// GetProfileFiles returns the configuration files declaring each profile (in loading order)
+func (c *Config) GetProfileFiles() (map[string][]string, error) {
+	files := make(map[string][]string)
+	sources := make([]*includeTemplate, 0, len(c.includes)+1)
+	if c.sourceTemplate != nil {
+		sources = append(sources, &includeTemplate{
+			filename:       c.configFile,
+			format:         c.format,
+			sourceTemplate: c.sourceTemplate,
+		})
+	}
+	sources = append(sources, c.includes...)
+	for _, source := range sources {
+		buffer := &bytes.Buffer{}
+		err := source.sourceTemplate.Execute(buffer, newTemplateData(source.filename, "default"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot execute '%s': %w", source.filename, err)
+		}
+		v := viper.New()
+		v.SetConfigType(source.format)
+		err = v.ReadConfig(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s configuration from '%s': %w", source.format, source.filename, err)
+		}
+		for key := range v.AllSettings() {
+			if isReservedSection(key) {
+				continue
+			}
+			files[key] = append(files[key], source.filename)
+		}
+	}
+	return files, nil
+}
+
+// getIncludeFiles resolves the list of files (or patterns) from the "includes" key
+func (c *Config) getIncludeFiles() ([]string, error) {
+	if !c.viper.IsSet(constants.SectionConfigurationIncludes) {
+		return nil, nil
+	}
+	var patterns []string
+	switch value := c.viper.Get(constants.SectionConfigurationIncludes).(type) {
+	case string:
+		patterns = []string{value}
+	case []string:
+		patterns = value
+	case []interface{}:
+		for _, item := range value {
+			patterns = append(patterns, fmt.Sprintf("%v", item))
+		}
+	default:
+		return nil, fmt.Errorf("invalid value for '%s': expected a string or a list of strings", constants.SectionConfigurationIncludes)
+	}
+
+	rootPath := filepath.Dir(c.configFile)
+	filenames := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		pattern = fixPath(pattern, expandEnv, absolutePrefix(rootPath))
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern '%s': %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			if !hasGlobPattern(pattern) {
+				return nil, fmt.Errorf("cannot find included file '%s'", pattern)
+			}
+			clog.Debugf("no file matching include pattern '%s'", pattern)
+			continue
+		}
+		for _, match := range matches {
+			// never include the main configuration file into itself
+			if c.configFile != "" && sameFile(match, c.configFile) {
+				continue
+			}
+			filenames = append(filenames, match)
+		}
+	}
+	return filenames, nil
+}
+
+// mergeIncludes executes the templates of the included files and merges the result into the configuration
+func (c *Config) mergeIncludes(profileName string) error {
+	for _, include := range c.includes {
+		buffer := &bytes.Buffer{}
+		err := include.sourceTemplate.Execute(buffer, newTemplateData(include.filename, profileName))
+		if err != nil {
+			return fmt.Errorf("cannot execute included file '%s': %w", include.filename, err)
+		}
+		traceConfig(profileName, include.filename, buffer.String())
+		c.viper.SetConfigType(include.format)
+		err = c.viper.MergeConfig(buffer)
+		if err != nil {
+			return fmt.Errorf("cannot parse %s configuration from included file '%s': %w", include.format, include.filename, err)
+		}
+	}
+	return nil
+}
+
+// isHCL returns true if the main configuration or any of the included files are in HCL format
+func (c *Config) isHCL() bool {
+	if c.format == "hcl" {
+		return true
+	}
+	for _, include := range c.includes {
+		if include.format == "hcl" {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) reloadTemplate(data TemplateData) error {
 	if c.sourceTemplate == nil {
 		return errors.New("no available template to execute, please load it first")
@@ -129,8 +313,12 @@ func (c *Config) reloadTemplate(data TemplateData) error {
 	if err != nil {
 		return fmt.Errorf("cannot execute %w", err)
 	}
-	traceConfig(data.Profile.Name, buffer.String())
-	return c.load(buffer)
+	traceConfig(data.Profile.Name, c.configFile, buffer.String())
+	err = c.load(buffer)
+	if err != nil {
+		return err
+	}
+	return c.mergeIncludes(data.Profile.Name)
 }
 
 // IsSet checks if the key contains a value
@@ -153,7 +341,7 @@ func (c *Config) Get(key string) interface{} {
 
 // HasProfile returns true if the profile exists in the configuration
 func (c *Config) HasProfile(profileKey string) bool {
-	return c.IsSet(profileKey)
+	return !isReservedSection(profileKey) && c.IsSet(profileKey)
 }
 
 // AllSettings merges all settings and returns them as a map[string]interface{}.
@@ -166,11 +354,12 @@ func (c *Config) GetProfileSections() map[string][]string {
 	profiles := map[string][]string{}
 	allSettings := c.AllSettings()
 	for sectionKey, sectionRawValue := range allSettings {
-		if sectionKey == constants.SectionConfigurationGlobal || sectionKey == constants.SectionConfigurationGroups {
+		if isReservedSection(sectionKey) {
 			continue
 		}
 		var commandList []string
-		if c.format == "hcl" {
+		// an included file can be in a different format than the main file
+		if _, ok := sectionRawValue.([]map[string]interface{}); ok {
 			commandList = c.getCommandListHCL(sectionRawValue)
 		} else {
 			commandList = c.getCommandList(sectionRawValue)
@@ -233,8 +422,8 @@ func (c *Config) HasProfileGroup(groupKey string) bool {
 	return ok
 }
 
-// GetProfileGroup returns the list of profiles in a group
-func (c *Config) GetProfileGroup(groupKey string) ([]string, error) {
+// GetGroup returns a group with its options
+func (c *Config) GetGroup(groupKey string) (*Group, error) {
 	err := c.loadGroups()
 	if err != nil {
 		return nil, err
@@ -247,7 +436,16 @@ func (c *Config) GetProfileGroup(groupKey string) ([]string, error) {
 	return group, nil
 }
 
-// GetProfileGroups returns all groups from the configuration
+// GetProfileGroup returns the list of profiles in a group, including the profiles of the nested groups
+func (c *Config) GetProfileGroup(groupKey string) ([]string, error) {
+	err := c.loadGroups()
+	if err != nil {
+		return nil, err
+	}
+	return expandGroup(c.groups, groupKey)
+}
+
+// GetProfileGroups returns all groups from the configuration, with their list of profiles and nested groups
 //
 // If the groups section does not exist, it returns an empty map
 func (c *Config) GetProfileGroups() map[string][]string {
@@ -255,16 +453,20 @@ func (c *Config) GetProfileGroups() map[string][]string {
 	if err != nil {
 		return nil
 	}
-	return c.groups
+	groups := make(map[string][]string, len(c.groups))
+	for name, group := range c.groups {
+		groups[name] = group.Profiles
+	}
+	return groups
 }
 
 func (c *Config) loadGroups() error {
 	if !c.IsSet(constants.SectionConfigurationGroups) {
-		c.groups = map[string][]string{}
+		c.groups = map[string]*Group{}
 		return nil
 	}
 	if c.groups == nil {
-		groups := map[string][]string{}
+		groups := map[string]*Group{}
 		err := c.unmarshalKey(constants.SectionConfigurationGroups, &groups)
 		if err != nil {
 			return err
@@ -287,47 +489,135 @@ func (c *Config) GetProfile(profileKey string) (*Profile, error) {
 
 // getProfile from configuration
 func (c *Config) getProfile(profileKey string) (*Profile, error) {
-	var err error
-	var profile *Profile
-
-	if !c.IsSet(profileKey) {
+	if !c.HasProfile(profileKey) {
 		return nil, nil
 	}
 
-	profile = NewProfile(c, profileKey)
-	err = c.unmarshalKey(profileKey, profile)
+	profile := NewProfile(c, profileKey)
+	// the common section is loaded first, so any profile can override its values
+	if c.IsSet(constants.SectionDefinitionCommon) {
+		err := c.unmarshalKey(constants.SectionDefinitionCommon, profile)
+		if err != nil {
+			return nil, fmt.Errorf("error in section '%s': %w", constants.SectionDefinitionCommon, err)
+		}
+		// inheritance is not supported in the common section
+		profile.Inherit = nil
+		profile.moveOtherSections()
+	}
+	err := c.applyProfile(profileKey, profile, nil)
 	if err != nil {
 		return nil, err
 	}
-	if profile.Inherit != "" {
-		inherit := profile.Inherit
-		// Load inherited profile
-		profile, err = c.getProfile(inherit)
-		if err != nil {
-			return nil, err
+	// make sure it has the right name
+	profile.Name = profileKey
+	return profile, nil
+}
+
+// applyProfile loads all the parents of a profile (or mixin) from left to right, then loads the profile on top.
+// chain is the list of profiles currently being loaded, to detect any inheritance cycle
+func (c *Config) applyProfile(key string, profile *Profile, chain []string) error {
+	for _, name := range chain {
+		if name == key {
+			return fmt.Errorf("error in profile '%s': inheritance cycle detected: %s -> %s", chain[0], strings.Join(chain, " -> "), key)
 		}
-		if profile == nil {
-			return nil, fmt.Errorf("error in profile '%s': parent profile '%s' not found", profileKey, inherit)
+	}
+	chain = append(chain[:len(chain):len(chain)], key)
+
+	// first we need the list of parents
+	definition := &Profile{}
+	err := c.loadProfileDefinition(key, definition)
+	if err != nil {
+		return err
+	}
+	for _, parent := range definition.Inherit {
+		if parent == "" {
+			continue
+		}
+		if !c.HasProfile(parent) && !c.hasMixin(parent) {
+			return fmt.Errorf("error in profile '%s': parent profile '%s' not found", key, parent)
 		}
-		// and reload this profile onto the inherited one
-		err = c.unmarshalKey(profileKey, profile)
+		err = c.applyProfile(parent, profile, chain)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		// make sure it has the right name
-		profile.Name = profileKey
 	}
-	return profile, nil
+	// and reload this profile onto the inherited ones
+	profile.Inherit = nil
+	return c.loadProfileDefinition(key, profile)
+}
+
+// loadProfileDefinition loads the profile (or the mixin if no profile has this name) on top of the current profile values
+func (c *Config) loadProfileDefinition(key string, profile *Profile) error {
+	err := c.decodeProfileDefinition(key, profile)
+	if err != nil {
+		return err
+	}
+	profile.moveOtherSections()
+	return nil
+}
+
+func (c *Config) decodeProfileDefinition(key string, profile *Profile) error {
+	if c.HasProfile(key) {
+		return c.unmarshalKey(key, profile)
+	}
+	mixins, err := c.getMixins()
+	if err != nil {
+		return err
+	}
+	if mixin, ok := mixins[key]; ok {
+		return c.decode(mixin, profile)
+	}
+	return fmt.Errorf("profile or mixin '%s' not found", key)
+}
+
+// hasMixin returns true if the mixin exists in the mixins section
+func (c *Config) hasMixin(name string) bool {
+	mixins, err := c.getMixins()
+	if err != nil {
+		return false
+	}
+	_, ok := mixins[name]
+	return ok
+}
+
+// getMixins returns the raw definitions from the mixins section
+func (c *Config) getMixins() (map[string]interface{}, error) {
+	mixins := map[string]interface{}{}
+	if !c.IsSet(constants.SectionConfigurationMixins) {
+		return mixins, nil
+	}
+	err := c.unmarshalKey(constants.SectionConfigurationMixins, &mixins)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load mixins: %w", err)
+	}
+	return mixins, nil
 }
 
 // unmarshalKey is a wrapper around viper.UnmarshalKey with the right decoder config options
 func (c *Config) unmarshalKey(key string, rawVal interface{}) error {
-	if c.format == "hcl" {
+	if c.isHCL() {
 		return c.viper.UnmarshalKey(key, rawVal, configOptionHCL)
 	}
 	return c.viper.UnmarshalKey(key, rawVal, configOption)
 }
 
+// decode is decoding raw configuration values with the same decoder config options as unmarshalKey
+func (c *Config) decode(input, output interface{}) error {
+	decoderConfig := &mapstructure.DecoderConfig{
+		Result:           output,
+		WeaklyTypedInput: true,
+		DecodeHook:       shellCommandsHookFunc(),
+	}
+	if c.isHCL() {
+		decoderConfig.DecodeHook = mapstructure.ComposeDecodeHookFunc(sliceOfMapsToMapHookFunc(), shellCommandsHookFunc())
+	}
+	decoder, err := mapstructure.NewDecoder(decoderConfig)
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(input)
+}
+
 // sliceOfMapsToMapHookFunc merges a slice of maps to a map
 func sliceOfMapsToMapHookFunc() mapstructure.DecodeHookFunc {
 	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
@@ -357,11 +647,52 @@ func sliceOfMapsToMapHookFunc() mapstructure.DecodeHookFunc {
 	}
 }
 
-func traceConfig(profileName, config string) {
+// isReservedSection returns true if the key at the root of the configuration is not a profile
+func isReservedSection(key string) bool {
+	return key == constants.SectionConfigurationGlobal ||
+		key == constants.SectionConfigurationGroups ||
+		key == constants.SectionConfigurationIncludes ||
+		key == constants.SectionConfigurationMixins ||
+		key == constants.SectionConfigurationSchema ||
+		key == constants.SectionDefinitionCommon
+}
+
+// getFormat returns the configuration format from the file extension
+func getFormat(extension string) string {
+	// For compatibility with the previous versions, a .conf file is TOML format
+	if extension == "conf" {
+		return "toml"
+	}
+	return extension
+}
+
+// hasGlobPattern returns true if the path contains any of the glob special characters
+func hasGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// sameFile returns true if both paths are pointing to the same file
+func sameFile(first, second string) bool {
+	firstInfo, err := os.Stat(first)
+	if err != nil {
+		return false
+	}
+	secondInfo, err := os.Stat(second)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(firstInfo, secondInfo)
+}
+
+func traceConfig(profileName, filename, config string) {
 	lines := strings.Split(config, "\n")
 	output := ""
 	for i := 0; i < len(lines); i++ {
 		output += fmt.Sprintf("%3d: %s\n", i+1, lines[i])
 	}
+	if filename != "" {
+		clog.Tracef("Resulting configuration for profile '%s' from '%s':\n====================\n%s====================\n", profileName, filename, output)
+		return
+	}
 	clog.Tracef("Resulting configuration for profile '%s':\n====================\n%s====================\n", profileName, output)
 }
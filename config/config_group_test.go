@@ -2,7 +2,9 @@ package config
 
 import (
 	"bytes"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -133,3 +135,180 @@ groups = {
 		})
 	}
 }
+
+func TestGetGroupWithOptions(t *testing.T) {
+	testData := []testGroupData{
+		{
+			"toml",
+			`
+[groups]
+list = ["first", "second"]
+[groups.test]
+profiles = ["first", "second", "third"]
+parallel = 2
+continue-on-error = true
+`,
+		},
+		{
+			"json",
+			`{ "groups": { "list": ["first", "second"], "test": { "profiles": ["first", "second", "third"], "parallel": 2, "continue-on-error": true } } }`,
+		},
+		{
+			"yaml",
+			`
+groups:
+  list:
+  - first
+  - second
+  test:
+    profiles:
+    - first
+    - second
+    - third
+    parallel: 2
+    continue-on-error: true
+`,
+		},
+		{
+			"hcl",
+			`
+groups = {
+	"list" = ["first", "second"]
+	"test" = {
+		profiles = ["first", "second", "third"]
+		parallel = 2
+		continue-on-error = true
+	}
+}
+`,
+		},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(testItem.format, func(t *testing.T) {
+			c, err := Load(bytes.NewBufferString(testConfig), format)
+			require.NoError(t, err)
+
+			group, err := c.GetGroup("test")
+			require.NoError(t, err)
+			assert.Equal(t, &Group{
+				Profiles:        []string{"first", "second", "third"},
+				Parallel:        2,
+				ContinueOnError: true,
+			}, group)
+
+			group, err = c.GetGroup("list")
+			require.NoError(t, err)
+			assert.Equal(t, &Group{Profiles: []string{"first", "second"}}, group)
+
+			assert.Equal(t, map[string][]string{
+				"list": {"first", "second"},
+				"test": {"first", "second", "third"},
+			}, c.GetProfileGroups())
+		})
+	}
+}
+
+func TestGetNestedGroups(t *testing.T) {
+	testData := []testGroupData{
+		{
+			"toml",
+			`
+[groups]
+home = ["documents", "photos"]
+servers = ["web", "database"]
+[groups.all]
+description = "everything"
+profiles = ["home", "servers", "photos", "system"]
+lock = "/tmp/all.lock"
+lock-wait = "5m"
+`,
+		},
+		{
+			"json",
+			`{ "groups": {
+  "home": ["documents", "photos"],
+  "servers": ["web", "database"],
+  "all": { "description": "everything", "profiles": ["home", "servers", "photos", "system"], "lock": "/tmp/all.lock", "lock-wait": "5m" }
+} }`,
+		},
+		{
+			"yaml",
+			`
+groups:
+  home: [documents, photos]
+  servers: [web, database]
+  all:
+    description: everything
+    profiles: [home, servers, photos, system]
+    lock: /tmp/all.lock
+    lock-wait: 5m
+`,
+		},
+		{
+			"hcl",
+			`
+groups = {
+	"home" = ["documents", "photos"]
+	"servers" = ["web", "database"]
+	"all" = {
+		description = "everything"
+		profiles = ["home", "servers", "photos", "system"]
+		lock = "/tmp/all.lock"
+		lock-wait = "5m"
+	}
+}
+`,
+		},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(testItem.format, func(t *testing.T) {
+			c, err := Load(bytes.NewBufferString(testConfig), format)
+			require.NoError(t, err)
+
+			group, err := c.GetGroup("all")
+			require.NoError(t, err)
+			assert.Equal(t, "everything", group.Description)
+			assert.Equal(t, []string{"home", "servers", "photos", "system"}, group.Profiles)
+			assert.Equal(t, "/tmp/all.lock", group.Lock)
+			assert.Equal(t, 5*time.Minute, group.LockWait)
+
+			// photos is only included once
+			profiles, err := c.GetProfileGroup("all")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"documents", "photos", "web", "database", "system"}, profiles)
+		})
+	}
+}
+
+func TestExpandGroupWithCycle(t *testing.T) {
+	groups := map[string]*Group{
+		"first":  {Profiles: []string{"one", "second"}},
+		"second": {Profiles: []string{"two", "third"}},
+		"third":  {Profiles: []string{"three", "first"}},
+		"other":  {Profiles: []string{"second"}},
+		"self":   {Profiles: []string{"self"}},
+	}
+	_, err := expandGroup(groups, "first")
+	assert.EqualError(t, err, "group 'first' is including itself: first > second > third > first")
+
+	_, err = expandGroup(groups, "other")
+	assert.EqualError(t, err, "group 'second' is including itself: other > second > third > first > second")
+
+	_, err = expandGroup(groups, "self")
+	assert.EqualError(t, err, "group 'self' is including itself: self > self")
+
+	_, err = expandGroup(groups, "unknown")
+	assert.EqualError(t, err, "group 'unknown' not found")
+}
+
+func TestGroupSetRootPath(t *testing.T) {
+	group := &Group{Lock: "all.lock"}
+	group.SetRootPath(filepath.Join("path", "to"))
+	assert.Equal(t, filepath.Join("path", "to", "all.lock"), group.Lock)
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestFiles creates a temporary directory containing the files (name => content)
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "resticprofile-includes")
+	require.NoError(t, err)
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestIncludeFilesInDifferentFormats(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.toml": `
+includes = ["included/*"]
+
+[global]
+default-command = "version"
+
+[first]
+repository = "/first"
+`,
+		"included/second.yaml": `
+second:
+  repository: /second
+  backup:
+    source: /source
+`,
+		"included/third.json": `{ "third": { "inherit": "first", "password-file": "{{ .Profile.Name }}.key" } }`,
+		"included/fourth.hcl": `
+"fourth" = {
+    repository = "/fourth"
+    snapshots = {
+        host = true
+    }
+}
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := LoadFile(filepath.Join(dir, "profiles.toml"), "")
+	require.NoError(t, err)
+
+	global, err := c.GetGlobalSection()
+	require.NoError(t, err)
+	assert.Equal(t, "version", global.DefaultCommand)
+
+	profiles := c.GetProfileSections()
+	assert.Len(t, profiles, 4)
+	assert.Contains(t, profiles, "first")
+	assert.Contains(t, profiles, "second")
+	assert.Contains(t, profiles, "third")
+	assert.Contains(t, profiles, "fourth")
+	assert.NotContains(t, profiles, "includes")
+	assert.ElementsMatch(t, []string{"backup"}, profiles["second"])
+	assert.ElementsMatch(t, []string{"snapshots"}, profiles["fourth"])
+
+	profile, err := c.GetProfile("second")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "/second", profile.Repository)
+	assert.Equal(t, []string{"/source"}, profile.Backup.Source)
+
+	// template is executed and inheritance works across files
+	profile, err = c.GetProfile("third")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "/first", profile.Repository)
+	assert.Equal(t, "third.key", profile.PasswordFile)
+
+	profile, err = c.GetProfile("fourth")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "/fourth", profile.Repository)
+	assert.Equal(t, true, profile.Snapshots["host"])
+}
+
+func TestIncludeFileOverridesMainFile(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.yaml": `
+includes: override.yaml
+profile:
+  repository: /main
+  password-file: key
+`,
+		"override.yaml": `
+profile:
+  repository: /override
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := LoadFile(filepath.Join(dir, "profiles.yaml"), "")
+	require.NoError(t, err)
+
+	profile, err := c.GetProfile("profile")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "/override", profile.Repository)
+	assert.Equal(t, "key", profile.PasswordFile)
+}
+
+func TestIncludeMainFileIsIgnored(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.toml": `
+includes = "*.toml"
+[profile]
+repository = "/main"
+`,
+		"other.toml": `
+[other]
+repository = "/other"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := LoadFile(filepath.Join(dir, "profiles.toml"), "")
+	require.NoError(t, err)
+	assert.Len(t, c.includes, 1)
+	assert.True(t, c.HasProfile("other"))
+}
+
+func TestIncludeMissingFile(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.toml": `
+includes = "missing.toml"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadFile(filepath.Join(dir, "profiles.toml"), "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing.toml")
+}
+
+func TestIncludeNoMatchingPattern(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.toml": `
+includes = "conf.d/*.toml"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadFile(filepath.Join(dir, "profiles.toml"), "")
+	assert.NoError(t, err)
+}
+
+func TestIncludeFileWithParsingError(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.toml": `
+includes = "wrong.yaml"
+`,
+		"wrong.yaml": `
+profile:
+	repository: "tab indentation is not valid"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadFile(filepath.Join(dir, "profiles.toml"), "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "wrong.yaml")
+}
+
+func TestIncludeFromReader(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"included.toml": `
+[included]
+repository = "/included"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	testConfig := `
+includes = "` + filepath.ToSlash(filepath.Join(dir, "included.toml")) + `"
+`
+	c, err := Load(bytes.NewBufferString(testConfig), "toml")
+	require.NoError(t, err)
+	assert.True(t, c.HasProfile("included"))
+}
+
+func TestAddIncludesOverridesInOrder(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"profiles.toml": `
+includes = "included.toml"
+
+[main]
+repository = "/main"
+
+[groups]
+all = ["main"]
+`,
+		"included.toml": `
+[included]
+repository = "/included"
+`,
+		"profiles.d/10-first.yaml": `
+main:
+  password-file: first.key
+first:
+  repository: /first
+`,
+		"profiles.d/20-second.json": `{
+  "main": { "password-file": "second.key" },
+  "included": { "repository": "/second" },
+  "groups": { "fragments": ["first", "included"] }
+}`,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := LoadFile(filepath.Join(dir, "profiles.toml"), "")
+	require.NoError(t, err)
+	err = c.AddIncludes([]string{
+		filepath.Join(dir, "profiles.d", "10-first.yaml"),
+		filepath.Join(dir, "profiles.d", "20-second.json"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		filepath.Join(dir, "included.toml"),
+		filepath.Join(dir, "profiles.d", "10-first.yaml"),
+		filepath.Join(dir, "profiles.d", "20-second.json"),
+	}, c.GetIncludes())
+
+	profile, err := c.GetProfile("main")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "/main", profile.Repository)
+	assert.Equal(t, "second.key", profile.PasswordFile)
+
+	profile, err = c.GetProfile("included")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "/second", profile.Repository)
+
+	assert.True(t, c.HasProfileGroup("all"))
+	assert.True(t, c.HasProfileGroup("fragments"))
+
+	files, err := c.GetProfileFiles()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"main": {
+			filepath.Join(dir, "profiles.toml"),
+			filepath.Join(dir, "profiles.d", "10-first.yaml"),
+			filepath.Join(dir, "profiles.d", "20-second.json"),
+		},
+		"included": {
+			filepath.Join(dir, "included.toml"),
+			filepath.Join(dir, "profiles.d", "20-second.json"),
+		},
+		"first": {
+			filepath.Join(dir, "profiles.d", "10-first.yaml"),
+		},
+	}, files)
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"time"
+
+	"github.com/creativeprojects/resticprofile/constants"
+)
+
+// EmailSection contains the configuration to send an email at the end of the profile, using an SMTP server.
+// The subject and body are templates receiving the same details as the body of the web hooks
+type EmailSection struct {
+	Host                string        `mapstructure:"host"`
+	Port                int           `mapstructure:"port"`
+	Security            string        `mapstructure:"security"`
+	SkipTLSVerification bool          `mapstructure:"skip-tls-verification"`
+	Username            string        `mapstructure:"username"`
+	PasswordEnv         string        `mapstructure:"password-env"`
+	PasswordFile        string        `mapstructure:"password-file"`
+	From                string        `mapstructure:"from"`
+	To                  []string      `mapstructure:"to"`
+	Subject             string        `mapstructure:"subject"`
+	Body                string        `mapstructure:"body"`
+	BodyTemplate        string        `mapstructure:"body-template"`
+	SendOn              []string      `mapstructure:"send-on"`
+	Timeout             time.Duration `mapstructure:"timeout"`
+}
+
+// SendOnResult returns true when an email should be sent for this result of the profile.
+// previousSuccess is the result of the previous run, used to detect a change of state
+func (e *EmailSection) SendOnResult(success, previousSuccess bool) bool {
+	sendOn := e.SendOn
+	if len(sendOn) == 0 {
+		sendOn = []string{constants.EmailSendOnFailure}
+	}
+	for _, on := range sendOn {
+		switch on {
+		case constants.EmailSendOnFailure:
+			if !success {
+				return true
+			}
+		case constants.EmailSendOnSuccess:
+			if success {
+				return true
+			}
+		case constants.EmailSendOnChange:
+			if success != previousSuccess {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuthNeedsEncryption returns true when the password cannot be sent to the server: it's only sent
+// over an encrypted connection (starttls security), or to a server on localhost
+func (e *EmailSection) AuthNeedsEncryption() bool {
+	if e.Username == "" || e.Security != constants.EmailSecurityPlain {
+		return false
+	}
+	switch e.Host {
+	case "localhost", "127.0.0.1", "::1":
+		return false
+	}
+	return true
+}
+
+// SendOnChange returns true when an email is sent on a change of state (which needs the previous result from the status file)
+func (e *EmailSection) SendOnChange() bool {
+	for _, on := range e.SendOn {
+		if on == constants.EmailSendOnChange {
+			return true
+		}
+	}
+	return false
+}
+
+// setRootPath changes the path of the files relative to the configuration file
+func (e *EmailSection) setRootPath(rootPath string) {
+	e.PasswordFile = fixPath(e.PasswordFile, expandEnv, absolutePrefix(rootPath))
+	e.BodyTemplate = fixPath(e.BodyTemplate, expandEnv, absolutePrefix(rootPath))
+}
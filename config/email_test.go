@@ -0,0 +1,141 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailSection(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile.email]
+host = "smtp.example.com"
+port = 587
+username = "backup"
+password-file = "smtp-password"
+from = "backup@example.com"
+to = "admin@example.com"
+subject = "{{ ` + "`{{ .ProfileName }}`" + ` }}"
+body-template = "/etc/resticprofile/email.tmpl"
+send-on = ["failure", "change"]
+timeout = "10s"
+`},
+		{"json", `
+{
+  "profile": {
+    "email": {
+      "host": "smtp.example.com",
+      "port": 587,
+      "username": "backup",
+      "password-file": "smtp-password",
+      "from": "backup@example.com",
+      "to": "admin@example.com",
+      "subject": "{{ ` + "`{{ .ProfileName }}`" + ` }}",
+      "body-template": "/etc/resticprofile/email.tmpl",
+      "send-on": ["failure", "change"],
+      "timeout": "10s"
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  email:
+    host: smtp.example.com
+    port: 587
+    username: backup
+    password-file: smtp-password
+    from: backup@example.com
+    to: admin@example.com
+    subject: "{{ ` + "`{{ .ProfileName }}`" + ` }}"
+    body-template: /etc/resticprofile/email.tmpl
+    send-on:
+    - failure
+    - change
+    timeout: 10s
+`},
+		{"hcl", `
+"profile" = {
+	email = {
+		host = "smtp.example.com"
+		port = 587
+		username = "backup"
+		password-file = "smtp-password"
+		from = "backup@example.com"
+		to = "admin@example.com"
+		subject = "{{ ` + "`{{ .ProfileName }}`" + ` }}"
+		body-template = "/etc/resticprofile/email.tmpl"
+		send-on = ["failure", "change"]
+		timeout = "10s"
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			require.NotNil(t, profile.Email)
+			profile.SetRootPath("/etc/resticprofile")
+
+			assert.Equal(t, EmailSection{
+				Host:         "smtp.example.com",
+				Port:         587,
+				Username:     "backup",
+				PasswordFile: filepath.FromSlash("/etc/resticprofile/smtp-password"),
+				From:         "backup@example.com",
+				To:           []string{"admin@example.com"},
+				Subject:      "{{ .ProfileName }}",
+				BodyTemplate: "/etc/resticprofile/email.tmpl",
+				SendOn:       []string{"failure", "change"},
+				Timeout:      10 * time.Second,
+			}, *profile.Email)
+		})
+	}
+}
+
+func TestEmailSendOnResult(t *testing.T) {
+	testData := []struct {
+		sendOn          []string
+		success         bool
+		previousSuccess bool
+		expected        bool
+	}{
+		{nil, false, true, true},
+		{nil, true, false, false},
+		{[]string{"success"}, true, true, true},
+		{[]string{"success"}, false, true, false},
+		{[]string{"change"}, false, false, false},
+		{[]string{"change"}, true, false, true},
+		{[]string{"change"}, false, true, true},
+		{[]string{"failure", "change"}, false, false, true},
+		{[]string{"failure", "success"}, true, true, true},
+	}
+	for _, testItem := range testData {
+		email := &EmailSection{SendOn: testItem.sendOn}
+		assert.Equal(t, testItem.expected, email.SendOnResult(testItem.success, testItem.previousSuccess), "send-on %v, success %v, previous success %v", testItem.sendOn, testItem.success, testItem.previousSuccess)
+	}
+}
+
+func TestEmailAuthNeedsEncryption(t *testing.T) {
+	testData := []struct {
+		email    EmailSection
+		expected bool
+	}{
+		{EmailSection{Host: "smtp.example.com", Username: "backup"}, false},
+		{EmailSection{Host: "smtp.example.com", Security: "plain"}, false},
+		{EmailSection{Host: "smtp.example.com", Security: "plain", Username: "backup"}, true},
+		{EmailSection{Host: "localhost", Security: "plain", Username: "backup"}, false},
+		{EmailSection{Host: "127.0.0.1", Security: "plain", Username: "backup"}, false},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.expected, testItem.email.AuthNeedsEncryption(), "%+v", testItem.email)
+	}
+}
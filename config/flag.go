@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"time"
 )
 
 var (
@@ -64,6 +65,11 @@ func stringifyValue(value reflect.Value) ([]string, bool) {
 		return emptyStringArray, boolVal
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Type() == durationType {
+			// a duration is easier to read as "1m30s" than a number of nanoseconds
+			duration := time.Duration(value.Int())
+			return []string{duration.String()}, duration != 0
+		}
 		intVal := value.Int()
 		stringVal := strconv.FormatInt(intVal, 10)
 		return []string{stringVal}, intVal != 0
@@ -96,6 +102,14 @@ func stringifyValue(value reflect.Value) ([]string, bool) {
 	case reflect.Interface:
 		return stringifyValue(value.Elem())
 
+	case reflect.Struct:
+		// structs can be displayed when they have a string representation (like a shell command)
+		if stringer, ok := value.Interface().(fmt.Stringer); ok {
+			stringVal := stringer.String()
+			return []string{stringVal}, stringVal != ""
+		}
+		panic(fmt.Errorf("unexpected type %s", value.Type()))
+
 	default:
 		panic(fmt.Errorf("unexpected type %s", value.Kind()))
 	}
@@ -14,6 +14,7 @@ type Global struct {
 	DefaultCommand string `mapstructure:"default-command"`
 	Initialize     bool   `mapstructure:"initialize"`
 	ResticBinary   string `mapstructure:"restic-binary"`
+	ResticShell    string `mapstructure:"restic-shell"`
 	MinMemory      uint64 `mapstructure:"min-memory"`
 	Scheduler      string `mapstructure:"scheduler"`
 }
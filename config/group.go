@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Group is a list of profiles run together. In the configuration, it can be a list of profile names or an object with more options.
+// The list can also contain the name of other groups: their profiles are included in the group
+type Group struct {
+	Description     string        `mapstructure:"description"`
+	Profiles        []string      `mapstructure:"profiles"`
+	Parallel        int           `mapstructure:"parallel"`
+	ContinueOnError bool          `mapstructure:"continue-on-error"`
+	Lock            string        `mapstructure:"lock"`
+	LockWait        time.Duration `mapstructure:"lock-wait"`
+}
+
+var groupType = reflect.TypeOf(Group{})
+
+// SetRootPath changes the path of the lockfile relative to the configuration file
+func (g *Group) SetRootPath(rootPath string) {
+	g.Lock = fixPath(g.Lock, expandEnv, absolutePrefix(rootPath))
+}
+
+// groupHookFunc converts the short form of a group: a list of profile names instead of an object
+func groupHookFunc() mapstructure.DecodeHookFunc {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if to == groupType && (from.Kind() == reflect.Slice || from.Kind() == reflect.Array) {
+			// a slice of maps is the object form in HCL
+			if from.Elem().Kind() != reflect.Map {
+				return map[string]interface{}{"profiles": data}, nil
+			}
+		}
+		return data, nil
+	}
+}
+
+// expandGroup returns the profiles of the group, replacing the nested groups by their own profiles.
+// A profile included more than once is only returned the first time.
+func expandGroup(groups map[string]*Group, groupKey string) ([]string, error) {
+	profiles := make([]string, 0)
+	found := make(map[string]bool)
+	err := expandGroupInto(groups, []string{groupKey}, &profiles, found)
+	if err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// expandGroupInto adds the profiles of the last group of the path: the path is used to detect a group including itself
+func expandGroupInto(groups map[string]*Group, path []string, profiles *[]string, found map[string]bool) error {
+	groupKey := path[len(path)-1]
+	group, ok := groups[groupKey]
+	if !ok {
+		return fmt.Errorf("group '%s' not found", groupKey)
+	}
+	for _, name := range group.Profiles {
+		if _, isGroup := groups[name]; isGroup {
+			for _, parent := range path {
+				if parent == name {
+					return fmt.Errorf("group '%s' is including itself: %s", name, strings.Join(append(path, name), " > "))
+				}
+			}
+			err := expandGroupInto(groups, append(path[:len(path):len(path)], name), profiles, found)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !found[name] {
+			found[name] = true
+			*profiles = append(*profiles, name)
+		}
+	}
+	return nil
+}
@@ -25,6 +25,10 @@ func fixPath(value string, callbacks ...pathFix) string {
 
 // fixPaths runs fixPath over a slice of paths
 func fixPaths(sources []string, callbacks ...pathFix) []string {
+	if len(sources) == 0 {
+		// nothing to do
+		return sources
+	}
 	fixed := make([]string, len(sources))
 	for index, source := range sources {
 		fixed[index] = fixPath(source, callbacks...)
@@ -2,14 +2,18 @@ package config
 
 import (
 	"reflect"
+	"sort"
+	"time"
 
 	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/mitchellh/mapstructure"
 )
 
 // Profile contains the whole profile configuration
 type Profile struct {
 	config        *Config
+	rootPath      string // directory of the configuration file, for the relative paths of the generic sections
 	Name          string
 	Quiet         bool                      `mapstructure:"quiet" argument:"quiet"`
 	Verbose       bool                      `mapstructure:"verbose" argument:"verbose"`
@@ -19,13 +23,27 @@ type Profile struct {
 	CACert        string                    `mapstructure:"cacert" argument:"cacert"`
 	TLSClientCert string                    `mapstructure:"tls-client-cert" argument:"tls-client-cert"`
 	Initialize    bool                      `mapstructure:"initialize"`
-	Inherit       string                    `mapstructure:"inherit"`
+	Inherit       []string                  `mapstructure:"inherit"`
+	Labels        []string                  `mapstructure:"labels"`
 	Lock          string                    `mapstructure:"lock"`
 	ForceLock     bool                      `mapstructure:"force-inactive-lock"`
-	RunBefore     []string                  `mapstructure:"run-before"`
-	RunAfter      []string                  `mapstructure:"run-after"`
-	RunAfterFail  []string                  `mapstructure:"run-after-fail"`
+	LockWait      time.Duration             `mapstructure:"lock-wait"`
+	LockMode      string                    `mapstructure:"lock-mode"`
+	RunBefore     ShellCommands             `mapstructure:"run-before"`
+	RunAfter      ShellCommands             `mapstructure:"run-after"`
+	RunAfterFail  ShellCommands             `mapstructure:"run-after-fail"`
+	RunFinally    ShellCommands             `mapstructure:"run-finally"`
+	SendBefore    WebHooks                  `mapstructure:"send-before"`
+	SendAfter     WebHooks                  `mapstructure:"send-after"`
+	SendAfterFail WebHooks                  `mapstructure:"send-after-fail"`
 	StatusFile    string                    `mapstructure:"status-file"`
+	PromFile      string                    `mapstructure:"prometheus-save-to-file"`
+	PromPush      string                    `mapstructure:"prometheus-push"`
+	PromTimeout   time.Duration             `mapstructure:"prometheus-push-timeout"`
+	Repositories  RepositoriesSection       `mapstructure:"repositories"`
+	RepoFailure   string                    `mapstructure:"repository-failure"`
+	Retry         *RetrySection             `mapstructure:"retry"`
+	Email         *EmailSection             `mapstructure:"email"`
 	OtherFlags    map[string]interface{}    `mapstructure:",remain"`
 	Environment   map[string]string         `mapstructure:"env"`
 	Backup        *BackupSection            `mapstructure:"backup"`
@@ -35,22 +53,44 @@ type Profile struct {
 	Snapshots     map[string]interface{}    `mapstructure:"snapshots"`
 	Forget        *OtherSectionWithSchedule `mapstructure:"forget"`
 	Mount         map[string]interface{}    `mapstructure:"mount"`
+	Copy          *CopySection              `mapstructure:"copy"`
+	OtherSections map[string]map[string]interface{}
+}
+
+// RepositoriesSection contains the repositories of the profile, indexed by name
+type RepositoriesSection map[string]*RepositorySection
+
+// RepositorySection contains the configuration of one of the repositories of the profile:
+// the restic commands are run on each repository in turn
+type RepositorySection struct {
+	Repository   string                 `mapstructure:"repository" argument:"repo"`
+	PasswordFile string                 `mapstructure:"password-file" argument:"password-file"`
+	Environment  map[string]string      `mapstructure:"env"`
+	OtherFlags   map[string]interface{} `mapstructure:",remain"`
+}
+
+// RetrySection contains the configuration to retry a restic command failing on a locked repository
+// or on a transient error from the backend
+type RetrySection struct {
+	MaxAttempts   int           `mapstructure:"max-attempts"`
+	InitialDelay  time.Duration `mapstructure:"initial-delay"`
+	BackoffFactor float64       `mapstructure:"backoff-factor"`
+	MaxDelay      time.Duration `mapstructure:"max-delay"`
 }
 
 // BackupSection contains the specific configuration to the 'backup' command
 type BackupSection struct {
-	ScheduleSection `mapstructure:",squash"`
-	CheckBefore     bool                   `mapstructure:"check-before"`
-	CheckAfter      bool                   `mapstructure:"check-after"`
-	RunBefore       []string               `mapstructure:"run-before"`
-	RunAfter        []string               `mapstructure:"run-after"`
-	UseStdin        bool                   `mapstructure:"stdin" argument:"stdin"`
-	Source          []string               `mapstructure:"source"`
-	Exclude         []string               `mapstructure:"exclude" argument:"exclude"`
-	Iexclude        []string               `mapstructure:"iexclude" argument:"iexclude"`
-	ExcludeFile     []string               `mapstructure:"exclude-file" argument:"exclude-file"`
-	FilesFrom       []string               `mapstructure:"files-from" argument:"files-from"`
-	OtherFlags      map[string]interface{} `mapstructure:",remain"`
+	ScheduleSection         `mapstructure:",squash"`
+	RunShellCommandsSection `mapstructure:",squash"`
+	CheckBefore             bool                   `mapstructure:"check-before"`
+	CheckAfter              bool                   `mapstructure:"check-after"`
+	UseStdin                bool                   `mapstructure:"stdin" argument:"stdin"`
+	Source                  []string               `mapstructure:"source"`
+	Exclude                 []string               `mapstructure:"exclude" argument:"exclude"`
+	Iexclude                []string               `mapstructure:"iexclude" argument:"iexclude"`
+	ExcludeFile             []string               `mapstructure:"exclude-file" argument:"exclude-file"`
+	FilesFrom               []string               `mapstructure:"files-from" argument:"files-from"`
+	OtherFlags              map[string]interface{} `mapstructure:",remain"`
 }
 
 // RetentionSection contains the specific configuration to
@@ -62,11 +102,22 @@ type RetentionSection struct {
 	OtherFlags      map[string]interface{} `mapstructure:",remain"`
 }
 
+// CopySection contains the destination repository for the 'copy' command
+type CopySection struct {
+	ScheduleSection         `mapstructure:",squash"`
+	RunShellCommandsSection `mapstructure:",squash"`
+	AfterBackup             bool                   `mapstructure:"after-backup"`
+	Repository              string                 `mapstructure:"repository" argument:"repo2"`
+	PasswordFile            string                 `mapstructure:"password-file" argument:"password-file2"`
+	OtherFlags              map[string]interface{} `mapstructure:",remain"`
+}
+
 // OtherSectionWithSchedule is a section containing schedule only specific parameters
 // (the other parameters being for restic)
 type OtherSectionWithSchedule struct {
-	ScheduleSection `mapstructure:",squash"`
-	OtherFlags      map[string]interface{} `mapstructure:",remain"`
+	ScheduleSection         `mapstructure:",squash"`
+	RunShellCommandsSection `mapstructure:",squash"`
+	OtherFlags              map[string]interface{} `mapstructure:",remain"`
 }
 
 // ScheduleSection contains the parameters for scheduling a command (backup, check, forget, etc.)
@@ -77,6 +128,23 @@ type ScheduleSection struct {
 	SchedulePriority   string   `mapstructure:"schedule-priority"`
 }
 
+// RunShellCommandsSection contains the shell commands to run, and the web hooks to send, before and after a restic command
+type RunShellCommandsSection struct {
+	RunBefore     ShellCommands `mapstructure:"run-before"`
+	RunAfter      ShellCommands `mapstructure:"run-after"`
+	RunAfterFail  ShellCommands `mapstructure:"run-after-fail"`
+	SendBefore    WebHooks      `mapstructure:"send-before"`
+	SendAfter     WebHooks      `mapstructure:"send-after"`
+	SendAfterFail WebHooks      `mapstructure:"send-after-fail"`
+}
+
+// setRootPath changes the path of the body templates of the web hooks relative to the configuration file
+func (s *RunShellCommandsSection) setRootPath(rootPath string) {
+	s.SendBefore.setRootPath(rootPath)
+	s.SendAfter.setRootPath(rootPath)
+	s.SendAfterFail.setRootPath(rootPath)
+}
+
 // NewProfile instantiates a new blank profile
 func NewProfile(c *Config, name string) *Profile {
 	return &Profile{
@@ -88,34 +156,92 @@ func NewProfile(c *Config, name string) *Profile {
 // SetRootPath changes the path of all the relative paths and files in the configuration
 func (p *Profile) SetRootPath(rootPath string) {
 
-	p.Lock = fixPath(p.Lock, expandEnv, absolutePrefix(rootPath), escapeSpaces)
-	p.PasswordFile = fixPath(p.PasswordFile, expandEnv, absolutePrefix(rootPath), escapeSpaces)
-	p.CacheDir = fixPath(p.CacheDir, expandEnv, absolutePrefix(rootPath), escapeSpaces)
-	p.CACert = fixPath(p.CACert, expandEnv, absolutePrefix(rootPath), escapeSpaces)
-	p.TLSClientCert = fixPath(p.TLSClientCert, expandEnv, absolutePrefix(rootPath), escapeSpaces)
+	p.Lock = fixPath(p.Lock, expandEnv, absolutePrefix(rootPath))
+	p.PasswordFile = fixPath(p.PasswordFile, expandEnv, absolutePrefix(rootPath))
+	p.CacheDir = fixPath(p.CacheDir, expandEnv, absolutePrefix(rootPath))
+	p.CACert = fixPath(p.CACert, expandEnv, absolutePrefix(rootPath))
+	p.TLSClientCert = fixPath(p.TLSClientCert, expandEnv, absolutePrefix(rootPath))
+
+	for _, repository := range p.Repositories {
+		if repository != nil {
+			repository.PasswordFile = fixPath(repository.PasswordFile, expandEnv, absolutePrefix(rootPath))
+		}
+	}
+
+	if p.Copy != nil {
+		p.Copy.PasswordFile = fixPath(p.Copy.PasswordFile, expandEnv, absolutePrefix(rootPath))
+	}
 
 	if p.Backup != nil {
 		if p.Backup.ExcludeFile != nil && len(p.Backup.ExcludeFile) > 0 {
-			p.Backup.ExcludeFile = fixPaths(p.Backup.ExcludeFile, expandEnv, absolutePrefix(rootPath), escapeSpaces)
+			p.Backup.ExcludeFile = fixPaths(p.Backup.ExcludeFile, expandEnv, absolutePrefix(rootPath))
 		}
 
 		if p.Backup.FilesFrom != nil && len(p.Backup.FilesFrom) > 0 {
-			p.Backup.FilesFrom = fixPaths(p.Backup.FilesFrom, expandEnv, absolutePrefix(rootPath), escapeSpaces)
+			p.Backup.FilesFrom = fixPaths(p.Backup.FilesFrom, expandEnv, absolutePrefix(rootPath))
 		}
 
 		// Backup source is NOT relative to the configuration, but where the script was launched instead
 		if p.Backup.Source != nil && len(p.Backup.Source) > 0 {
-			p.Backup.Source = fixPaths(p.Backup.Source, expandEnv, escapeSpaces)
+			p.Backup.Source = fixPaths(p.Backup.Source, expandEnv)
 		}
 
 		if p.Backup.Exclude != nil && len(p.Backup.Exclude) > 0 {
-			p.Backup.Exclude = fixPaths(p.Backup.Exclude, expandEnv, escapeShellString)
+			p.Backup.Exclude = fixPaths(p.Backup.Exclude, expandEnv)
 		}
 
 		if p.Backup.Iexclude != nil && len(p.Backup.Iexclude) > 0 {
-			p.Backup.Iexclude = fixPaths(p.Backup.Iexclude, expandEnv, escapeShellString)
+			p.Backup.Iexclude = fixPaths(p.Backup.Iexclude, expandEnv)
+		}
+	}
+
+	// body templates of the web hooks
+	p.rootPath = rootPath
+	p.SendBefore.setRootPath(rootPath)
+	p.SendAfter.setRootPath(rootPath)
+	p.SendAfterFail.setRootPath(rootPath)
+	if p.Backup != nil {
+		p.Backup.RunShellCommandsSection.setRootPath(rootPath)
+	}
+	for _, section := range []*OtherSectionWithSchedule{p.Check, p.Forget, p.Prune} {
+		if section != nil {
+			section.RunShellCommandsSection.setRootPath(rootPath)
 		}
 	}
+	if p.Copy != nil {
+		p.Copy.RunShellCommandsSection.setRootPath(rootPath)
+	}
+	if p.Email != nil {
+		p.Email.setRootPath(rootPath)
+	}
+}
+
+// EscapeShellPaths escapes the characters of the paths that would otherwise be interpreted by the shell running restic.
+// It shouldn't be called when restic is started without a shell.
+// The lockfile is never given to the shell: its path stays the same for the commands looking for it (ps and stop)
+func (p *Profile) EscapeShellPaths() {
+	p.PasswordFile = fixPath(p.PasswordFile, escapeSpaces)
+	p.CacheDir = fixPath(p.CacheDir, escapeSpaces)
+	p.CACert = fixPath(p.CACert, escapeSpaces)
+	p.TLSClientCert = fixPath(p.TLSClientCert, escapeSpaces)
+
+	for _, repository := range p.Repositories {
+		if repository != nil {
+			repository.PasswordFile = fixPath(repository.PasswordFile, escapeSpaces)
+		}
+	}
+
+	if p.Copy != nil {
+		p.Copy.PasswordFile = fixPath(p.Copy.PasswordFile, escapeSpaces)
+	}
+
+	if p.Backup != nil {
+		p.Backup.ExcludeFile = fixPaths(p.Backup.ExcludeFile, escapeSpaces)
+		p.Backup.FilesFrom = fixPaths(p.Backup.FilesFrom, escapeSpaces)
+		p.Backup.Source = fixPaths(p.Backup.Source, escapeSpaces)
+		p.Backup.Exclude = fixPaths(p.Backup.Exclude, escapeShellString)
+		p.Backup.Iexclude = fixPaths(p.Backup.Iexclude, escapeShellString)
+	}
 }
 
 // SetHost will replace any host value from a boolean to the hostname
@@ -135,6 +261,60 @@ func (p *Profile) SetHost(hostname string) {
 	if p.Mount != nil {
 		replaceTrueValue(p.Mount, constants.ParameterHost, hostname)
 	}
+	if p.Copy != nil && p.Copy.OtherFlags != nil {
+		replaceTrueValue(p.Copy.OtherFlags, constants.ParameterHost, hostname)
+	}
+	for _, section := range p.OtherSections {
+		replaceTrueValue(section, constants.ParameterHost, hostname)
+	}
+}
+
+// GetRepositories returns the sorted names of the repositories of the profile
+// (an empty list means the profile is only using the repository from its flags)
+func (p *Profile) GetRepositories() []string {
+	names := make([]string, 0, len(p.Repositories))
+	for name, repository := range p.Repositories {
+		if repository != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithRepository returns a copy of the profile using the repository from the repositories section:
+// the repository flags and environment override the ones from the profile
+func (p *Profile) WithRepository(name string) *Profile {
+	repository, found := p.Repositories[name]
+	if !found || repository == nil {
+		return p
+	}
+	profile := *p
+	if repository.Repository != "" {
+		profile.Repository = repository.Repository
+	}
+	if repository.PasswordFile != "" {
+		profile.PasswordFile = repository.PasswordFile
+	}
+	if len(repository.Environment) > 0 {
+		profile.Environment = make(map[string]string, len(p.Environment)+len(repository.Environment))
+		for key, value := range p.Environment {
+			profile.Environment[key] = value
+		}
+		for key, value := range repository.Environment {
+			profile.Environment[key] = value
+		}
+	}
+	if len(repository.OtherFlags) > 0 {
+		profile.OtherFlags = make(map[string]interface{}, len(p.OtherFlags)+len(repository.OtherFlags))
+		for key, value := range p.OtherFlags {
+			profile.OtherFlags[key] = value
+		}
+		for key, value := range repository.OtherFlags {
+			profile.OtherFlags[key] = value
+		}
+	}
+	return &profile
 }
 
 // GetCommonFlags returns the flags common to all commands
@@ -165,7 +345,7 @@ func (p *Profile) GetCommandFlags(command string) map[string][]string {
 
 	case constants.CommandSnapshots:
 		if p.Snapshots != nil {
-			flags = addOtherFlags(flags, p.Snapshots)
+			flags = addOtherFlags(flags, withoutRunShellCommands(p.Snapshots))
 		}
 
 	case constants.CommandCheck:
@@ -185,13 +365,95 @@ func (p *Profile) GetCommandFlags(command string) map[string][]string {
 
 	case constants.CommandMount:
 		if p.Mount != nil {
-			flags = addOtherFlags(flags, p.Mount)
+			flags = addOtherFlags(flags, withoutRunShellCommands(p.Mount))
+		}
+
+	case constants.CommandCopy:
+		if p.Copy == nil {
+			break
+		}
+		commandFlags := convertStructToFlags(*p.Copy)
+		if len(commandFlags) > 0 {
+			flags = mergeFlags(flags, commandFlags)
+		}
+		flags = addOtherFlags(flags, p.Copy.OtherFlags)
+
+	default:
+		if section, found := p.OtherSections[command]; found {
+			flags = addOtherFlags(flags, withoutRunShellCommands(section))
 		}
 	}
 
 	return flags
 }
 
+// GetRunShellCommandsSection returns the shell commands to run before and after the restic command
+func (p *Profile) GetRunShellCommandsSection(command string) RunShellCommandsSection {
+	switch command {
+	case constants.CommandBackup:
+		if p.Backup != nil {
+			return p.Backup.RunShellCommandsSection
+		}
+	case constants.CommandCheck:
+		if p.Check != nil {
+			return p.Check.RunShellCommandsSection
+		}
+	case constants.CommandForget:
+		if p.Forget != nil {
+			return p.Forget.RunShellCommandsSection
+		}
+	case constants.CommandPrune:
+		if p.Prune != nil {
+			return p.Prune.RunShellCommandsSection
+		}
+	case constants.CommandCopy:
+		if p.Copy != nil {
+			return p.Copy.RunShellCommandsSection
+		}
+	case constants.CommandSnapshots:
+		return p.runShellCommandsFromMap(command, p.Snapshots)
+	case constants.CommandMount:
+		return p.runShellCommandsFromMap(command, p.Mount)
+	default:
+		return p.runShellCommandsFromMap(command, p.OtherSections[command])
+	}
+	return RunShellCommandsSection{}
+}
+
+// runShellCommandsFromMap decodes the shell commands and web hooks of a generic section, with the paths relative to the configuration file
+func (p *Profile) runShellCommandsFromMap(command string, section map[string]interface{}) RunShellCommandsSection {
+	commands := runShellCommandsFromMap(command, section)
+	if p.rootPath != "" {
+		commands.setRootPath(p.rootPath)
+	}
+	return commands
+}
+
+// DefinedCommands returns the sorted names of all the commands having a section in the profile
+func (p *Profile) DefinedCommands() []string {
+	commands := make([]string, 0, len(p.OtherSections)+8)
+	sections := map[string]bool{
+		constants.CommandBackup:                 p.Backup != nil,
+		constants.SectionConfigurationRetention: p.Retention != nil,
+		constants.CommandCheck:                  p.Check != nil,
+		constants.CommandPrune:                  p.Prune != nil,
+		constants.CommandSnapshots:              p.Snapshots != nil,
+		constants.CommandForget:                 p.Forget != nil,
+		constants.CommandMount:                  p.Mount != nil,
+		constants.CommandCopy:                   p.Copy != nil,
+	}
+	for name, defined := range sections {
+		if defined {
+			commands = append(commands, name)
+		}
+	}
+	for name := range p.OtherSections {
+		commands = append(commands, name)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
 // GetRetentionFlags returns the flags specific to the "forget" command being run as part of a backup
 func (p *Profile) GetRetentionFlags() map[string][]string {
 	// if there was no "other" flags, the map could be un-initialized
@@ -271,6 +533,7 @@ func (p *Profile) allSchedulableSections() map[string]interface{} {
 		constants.CommandCheck:                  p.Check,
 		constants.CommandForget:                 p.Forget,
 		constants.CommandPrune:                  p.Prune,
+		constants.CommandCopy:                   p.Copy,
 	}
 }
 
@@ -283,11 +546,70 @@ func getScheduleSection(section interface{}) *ScheduleSection {
 			return &v.ScheduleSection
 		case *OtherSectionWithSchedule:
 			return &v.ScheduleSection
+		case *CopySection:
+			return &v.ScheduleSection
 		}
 	}
 	return nil
 }
 
+// moveOtherSections moves the sections of the restic commands without a specific field (restore, ls, tag, etc.)
+// out of the flags of the profile. The flags are merged into any existing section (from a parent profile)
+func (p *Profile) moveOtherSections() {
+	for name, value := range p.OtherFlags {
+		if value == nil {
+			continue
+		}
+		section, ok := toMap(value)
+		if !ok {
+			continue
+		}
+		if p.OtherSections == nil {
+			p.OtherSections = make(map[string]map[string]interface{})
+		}
+		if p.OtherSections[name] == nil {
+			p.OtherSections[name] = make(map[string]interface{}, len(section))
+		}
+		for key, flag := range section {
+			p.OtherSections[name][key] = flag
+		}
+		delete(p.OtherFlags, name)
+	}
+}
+
+// runShellCommandsFromMap decodes the shell commands from a section without a specific type
+func runShellCommandsFromMap(command string, section map[string]interface{}) RunShellCommandsSection {
+	commands := RunShellCommandsSection{}
+	if len(section) == 0 {
+		return commands
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           &commands,
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.ComposeDecodeHookFunc(sliceOfMapsToMapHookFunc(), shellCommandsHookFunc()),
+	})
+	if err == nil {
+		err = decoder.Decode(section)
+	}
+	if err != nil {
+		clog.Errorf("invalid shell commands in section '%s': %v", command, err)
+	}
+	return commands
+}
+
+// withoutRunShellCommands returns a copy of the section without the shell commands and web hooks (which are not restic flags)
+func withoutRunShellCommands(section map[string]interface{}) map[string]interface{} {
+	flags := make(map[string]interface{}, len(section))
+	for key, value := range section {
+		if key == "run-before" || key == "run-after" || key == "run-after-fail" ||
+			key == "send-before" || key == "send-after" || key == "send-after-fail" {
+			continue
+		}
+		flags[key] = value
+	}
+	return flags
+}
+
 func addOtherFlags(flags map[string][]string, otherFlags map[string]interface{}) map[string][]string {
 	if len(otherFlags) == 0 {
 		return flags
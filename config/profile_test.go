@@ -1,10 +1,13 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/creativeprojects/resticprofile/constants"
 	"github.com/stretchr/testify/assert"
@@ -142,6 +145,312 @@ quiet = false
 	assert.Equal(t, true, profile.Verbose)
 }
 
+func TestInheritFromListOfParents(t *testing.T) {
+	testConfig := `
+[first]
+repository = "first"
+password-file = "first.key"
+first-value = 1
+override-value = 1
+
+[second]
+repository = "second"
+second-value = 2
+override-value = 2
+
+[profile]
+inherit = ["first", "second"]
+third-value = 3
+`
+	profile, err := getProfile("toml", testConfig, "profile")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "profile", profile.Name)
+	assert.Equal(t, []string{"first", "second"}, profile.Inherit)
+	assert.Equal(t, "second", profile.Repository)
+	assert.Equal(t, "first.key", profile.PasswordFile)
+	assert.Equal(t, int64(1), profile.OtherFlags["first-value"])
+	assert.Equal(t, int64(2), profile.OtherFlags["second-value"])
+	assert.Equal(t, int64(3), profile.OtherFlags["third-value"])
+	assert.Equal(t, int64(2), profile.OtherFlags["override-value"])
+}
+
+func TestInheritFromMixins(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[mixins.excludes.backup]
+exclude = ["*.tmp", "*.bak"]
+
+[mixins.retention.retention]
+after-backup = true
+keep-daily = 7
+
+[base]
+repository = "/backup"
+
+[profile]
+inherit = ["base", "excludes", "retention"]
+
+[profile.backup]
+source = "/home"
+`},
+		{"json", `{
+  "mixins": {
+    "excludes": { "backup": { "exclude": ["*.tmp", "*.bak"] } },
+    "retention": { "retention": { "after-backup": true, "keep-daily": 7 } }
+  },
+  "base": { "repository": "/backup" },
+  "profile": {
+    "inherit": ["base", "excludes", "retention"],
+    "backup": { "source": "/home" }
+  }
+}`},
+		{"yaml", `---
+mixins:
+  excludes:
+    backup:
+      exclude: ["*.tmp", "*.bak"]
+  retention:
+    retention:
+      after-backup: true
+      keep-daily: 7
+base:
+  repository: /backup
+profile:
+  inherit: [base, excludes, retention]
+  backup:
+    source: /home
+`},
+		{"hcl", `
+mixins {
+  excludes {
+    backup {
+      exclude = ["*.tmp", "*.bak"]
+    }
+  }
+  retention {
+    retention {
+      after-backup = true
+      keep-daily = 7
+    }
+  }
+}
+base {
+  repository = "/backup"
+}
+profile {
+  inherit = ["base", "excludes", "retention"]
+  backup {
+    source = "/home"
+  }
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			c, err := Load(bytes.NewBufferString(testConfig), format)
+			require.NoError(t, err)
+
+			// mixins are not profiles
+			assert.False(t, c.HasProfile("mixins"))
+			assert.False(t, c.HasProfile("excludes"))
+			assert.NotContains(t, c.GetProfileSections(), "mixins")
+
+			profile, err := c.GetProfile("profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			assert.Equal(t, "/backup", profile.Repository)
+			require.NotNil(t, profile.Backup)
+			assert.Equal(t, []string{"*.tmp", "*.bak"}, profile.Backup.Exclude)
+			assert.Equal(t, []string{"/home"}, profile.Backup.Source)
+			require.NotNil(t, profile.Retention)
+			assert.True(t, profile.Retention.AfterBackup)
+			assert.EqualValues(t, 7, profile.Retention.OtherFlags["keep-daily"])
+
+			mixin, err := c.GetProfile("excludes")
+			require.NoError(t, err)
+			assert.Nil(t, mixin)
+		})
+	}
+}
+
+func TestInheritanceCycle(t *testing.T) {
+	testConfig := `
+[mixins.loop]
+inherit = "first"
+
+[first]
+inherit = "second"
+
+[second]
+inherit = ["other", "loop"]
+
+[other]
+
+[profile]
+inherit = "first"
+`
+	_, err := getProfile("toml", testConfig, "profile")
+	require.Error(t, err)
+	assert.Equal(t, "error in profile 'profile': inheritance cycle detected: profile -> first -> second -> loop -> first", err.Error())
+}
+
+func TestInheritSameParentTwiceIsNotACycle(t *testing.T) {
+	testConfig := `
+[base]
+repository = "base"
+
+[first]
+inherit = "base"
+
+[second]
+inherit = "base"
+
+[profile]
+inherit = ["first", "second"]
+`
+	profile, err := getProfile("toml", testConfig, "profile")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, "base", profile.Repository)
+}
+
+func TestUnknownParentInList(t *testing.T) {
+	testConfig := `
+[first]
+[profile]
+inherit = ["first", "second"]
+`
+	_, err := getProfile("toml", testConfig, "profile")
+	assert.EqualError(t, err, "error in profile 'profile': parent profile 'second' not found")
+}
+
+func TestCommonSectionInAllProfiles(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[common]
+password-file = "common.key"
+cache-dir = "/cache"
+
+[common.backup]
+host = true
+
+[common.snapshots]
+host = true
+
+[first]
+repository = "/first"
+
+[first.backup]
+source = "/"
+
+[second]
+inherit = "first"
+cache-dir = "/other"
+
+[second.backup]
+host = "other"
+`},
+		{"json", `{
+  "common": {
+    "password-file": "common.key",
+    "cache-dir": "/cache",
+    "backup": { "host": true },
+    "snapshots": { "host": true }
+  },
+  "first": {
+    "repository": "/first",
+    "backup": { "source": "/" }
+  },
+  "second": {
+    "inherit": "first",
+    "cache-dir": "/other",
+    "backup": { "host": "other" }
+  }
+}`},
+		{"yaml", `---
+common:
+  password-file: common.key
+  cache-dir: /cache
+  backup:
+    host: true
+  snapshots:
+    host: true
+first:
+  repository: /first
+  backup:
+    source: /
+second:
+  inherit: first
+  cache-dir: /other
+  backup:
+    host: other
+`},
+		{"hcl", `
+common {
+  password-file = "common.key"
+  cache-dir = "/cache"
+  backup {
+    host = true
+  }
+  snapshots {
+    host = true
+  }
+}
+first {
+  repository = "/first"
+  backup {
+    source = "/"
+  }
+}
+second {
+  inherit = "first"
+  cache-dir = "/other"
+  backup {
+    host = "other"
+  }
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			c, err := Load(bytes.NewBufferString(testConfig), format)
+			require.NoError(t, err)
+
+			assert.False(t, c.HasProfile("common"))
+			assert.NotContains(t, c.GetProfileSections(), "common")
+			assert.Len(t, c.GetProfileSections(), 2)
+
+			profile, err := c.GetProfile("first")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			assert.Equal(t, "common.key", profile.PasswordFile)
+			assert.Equal(t, "/cache", profile.CacheDir)
+			assert.Equal(t, "/first", profile.Repository)
+			require.NotNil(t, profile.Backup)
+			assert.Equal(t, true, profile.Backup.OtherFlags["host"])
+			assert.Equal(t, []string{"/"}, profile.Backup.Source)
+			assert.Equal(t, true, profile.Snapshots["host"])
+
+			profile, err = c.GetProfile("second")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			assert.Equal(t, "common.key", profile.PasswordFile)
+			assert.Equal(t, "/other", profile.CacheDir)
+			assert.Equal(t, "/first", profile.Repository)
+			require.NotNil(t, profile.Backup)
+			assert.Equal(t, "other", profile.Backup.OtherFlags["host"])
+			assert.Equal(t, true, profile.Snapshots["host"])
+		})
+	}
+}
+
 func TestProfileCommonFlags(t *testing.T) {
 	assert := assert.New(t)
 	testConfig := `
@@ -245,6 +554,7 @@ func TestHostInAllSupportedSections(t *testing.T) {
 		constants.CommandSnapshots,
 		constants.CommandMount,
 		constants.SectionConfigurationRetention,
+		"restore",
 	}
 
 	assertHostIs := func(expectedHost []string, profile *Profile, section string) {
@@ -399,6 +709,192 @@ profile:
 	}
 }
 
+func TestOtherCommandSections(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[parent]
+repository = "/parent"
+
+[parent.restore]
+target = "/restore"
+host = "parent"
+
+[parent.tag]
+add = "tag"
+
+[profile]
+inherit = "parent"
+
+[profile.restore]
+host = "profile"
+verify = true
+
+[profile.ls]
+long = true
+`},
+		{"json", `
+{
+  "parent": {
+    "repository": "/parent",
+    "restore": {"target": "/restore", "host": "parent"},
+    "tag": {"add": "tag"}
+  },
+  "profile": {
+    "inherit": "parent",
+    "restore": {"host": "profile", "verify": true},
+    "ls": {"long": true}
+  }
+}`},
+		{"yaml", `---
+parent:
+  repository: /parent
+  restore:
+    target: /restore
+    host: parent
+  tag:
+    add: tag
+profile:
+  inherit: parent
+  restore:
+    host: profile
+    verify: true
+  ls:
+    long: true
+`},
+		{"hcl", `
+"parent" = {
+	repository = "/parent"
+	restore = {
+		target = "/restore"
+		host = "parent"
+	}
+	tag = {
+		add = "tag"
+	}
+}
+"profile" = {
+	inherit = "parent"
+	restore = {
+		host = "profile"
+		verify = true
+	}
+	ls = {
+		long = true
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+
+			assert.Empty(t, profile.OtherFlags)
+			assert.Equal(t, []string{"ls", "restore", "tag"}, profile.DefinedCommands())
+
+			assert.Equal(t, map[string][]string{
+				"repo":   {"/parent"},
+				"target": {"/restore"},
+				"host":   {"profile"},
+				"verify": {},
+			}, profile.GetCommandFlags("restore"))
+			assert.Equal(t, map[string][]string{
+				"repo": {"/parent"},
+				"add":  {"tag"},
+			}, profile.GetCommandFlags("tag"))
+			assert.Equal(t, map[string][]string{
+				"repo": {"/parent"},
+				"long": {},
+			}, profile.GetCommandFlags("ls"))
+			assert.Equal(t, map[string][]string{
+				"repo": {"/parent"},
+			}, profile.GetCommandFlags("diff"))
+		})
+	}
+}
+
+func TestCopySection(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile]
+repository = "/source"
+
+[profile.copy]
+repository = "/destination"
+password-file = "destination.key"
+after-backup = true
+host = true
+schedule = "daily"
+`},
+		{"json", `
+{
+  "profile": {
+    "repository": "/source",
+    "copy": {
+      "repository": "/destination",
+      "password-file": "destination.key",
+      "after-backup": true,
+      "host": true,
+      "schedule": "daily"
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  repository: /source
+  copy:
+    repository: /destination
+    password-file: destination.key
+    after-backup: true
+    host: true
+    schedule: daily
+`},
+		{"hcl", `
+"profile" = {
+	repository = "/source"
+	copy = {
+		repository = "/destination"
+		password-file = "destination.key"
+		after-backup = true
+		host = true
+		schedule = "daily"
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			require.NotNil(t, profile.Copy)
+
+			assert.True(t, profile.Copy.AfterBackup)
+			assert.Contains(t, profile.DefinedCommands(), constants.CommandCopy)
+
+			profile.SetHost("TestHost")
+			assert.Equal(t, map[string][]string{
+				"repo":           {"/source"},
+				"repo2":          {"/destination"},
+				"password-file2": {"destination.key"},
+				"host":           {"TestHost"},
+			}, profile.GetCommandFlags(constants.CommandCopy))
+
+			schedules := profile.Schedules()
+			require.Len(t, schedules, 1)
+			assert.Equal(t, constants.CommandCopy, schedules[0].SubTitle())
+			assert.Equal(t, []string{"daily"}, schedules[0].Schedules())
+		})
+	}
+}
+
 func TestSchedules(t *testing.T) {
 	assert := assert.New(t)
 
@@ -419,7 +915,7 @@ initialize = true
 	}
 
 	sections := NewProfile(nil, "").SchedulableCommands()
-	assert.Len(sections, 5)
+	assert.Len(sections, 6)
 
 	for _, command := range sections {
 		// Check that schedule is supported
@@ -554,3 +1050,349 @@ profile:
 		})
 	}
 }
+
+func TestRepositoriesSection(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile]
+repository = "/main"
+password-file = "main.key"
+repository-failure = "continue"
+[profile.env]
+test = "main"
+
+[profile.repositories.local]
+repository = "/local"
+
+[profile.repositories.remote]
+repository = "sftp:server:/remote"
+password-file = "remote.key"
+limit-upload = 100
+[profile.repositories.remote.env]
+test = "remote"
+`},
+		{"json", `
+{
+  "profile": {
+    "repository": "/main",
+    "password-file": "main.key",
+    "repository-failure": "continue",
+    "env": { "test": "main" },
+    "repositories": {
+      "local": { "repository": "/local" },
+      "remote": {
+        "repository": "sftp:server:/remote",
+        "password-file": "remote.key",
+        "limit-upload": 100,
+        "env": { "test": "remote" }
+      }
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  repository: /main
+  password-file: main.key
+  repository-failure: continue
+  env:
+    test: main
+  repositories:
+    local:
+      repository: /local
+    remote:
+      repository: "sftp:server:/remote"
+      password-file: remote.key
+      limit-upload: 100
+      env:
+        test: remote
+`},
+		{"hcl", `
+"profile" = {
+	repository = "/main"
+	password-file = "main.key"
+	repository-failure = "continue"
+	env = {
+		test = "main"
+	}
+	repositories = {
+		local = {
+			repository = "/local"
+		}
+		remote = {
+			repository = "sftp:server:/remote"
+			password-file = "remote.key"
+			limit-upload = 100
+			env = {
+				test = "remote"
+			}
+		}
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+
+			assert.Equal(t, constants.RepositoryFailureContinue, profile.RepoFailure)
+			assert.Equal(t, []string{"local", "remote"}, profile.GetRepositories())
+
+			local := profile.WithRepository("local")
+			assert.Equal(t, "/local", local.Repository)
+			assert.Equal(t, "main.key", local.PasswordFile)
+			assert.Equal(t, "main", local.Environment["test"])
+
+			remote := profile.WithRepository("remote")
+			assert.Equal(t, "sftp:server:/remote", remote.Repository)
+			assert.Equal(t, "remote.key", remote.PasswordFile)
+			assert.Equal(t, "remote", remote.Environment["test"])
+			assert.Equal(t, []string{"100"}, remote.GetCommonFlags()["limit-upload"])
+
+			// the original profile is left untouched
+			assert.Equal(t, "/main", profile.Repository)
+			assert.Equal(t, "main", profile.Environment["test"])
+			assert.NotContains(t, profile.GetCommonFlags(), "limit-upload")
+
+			// unknown repository
+			assert.Equal(t, profile, profile.WithRepository("unknown"))
+		})
+	}
+}
+
+func TestLockWait(t *testing.T) {
+	testData := []struct {
+		value    string
+		lockWait time.Duration
+	}{
+		{`"10m"`, 10 * time.Minute},
+		{`"1h30m"`, 90 * time.Minute},
+		{`120`, 2 * time.Minute},
+	}
+	for _, testItem := range testData {
+		t.Run(testItem.value, func(t *testing.T) {
+			testConfig := `
+[profile]
+lock = "/tmp/profile.lock"
+lock-wait = ` + testItem.value + `
+`
+			profile, err := getProfile("toml", testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			assert.Equal(t, testItem.lockWait, profile.LockWait)
+			assert.NotContains(t, profile.GetCommonFlags(), "lock-wait")
+		})
+	}
+}
+
+func TestPrometheusPushTimeout(t *testing.T) {
+	testConfig := `
+[profile]
+prometheus-push = "http://localhost:9091"
+prometheus-push-timeout = "10s"
+`
+	profile, err := getProfile("toml", testConfig, "profile")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, 10*time.Second, profile.PromTimeout)
+	assert.NotContains(t, profile.GetCommonFlags(), "prometheus-push-timeout")
+}
+
+func TestRetrySection(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile.retry]
+max-attempts = 5
+initial-delay = "10s"
+backoff-factor = 1.5
+max-delay = 120
+`},
+		{"json", `
+{
+  "profile": {
+    "retry": {
+      "max-attempts": 5,
+      "initial-delay": "10s",
+      "backoff-factor": 1.5,
+      "max-delay": 120
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  retry:
+    max-attempts: 5
+    initial-delay: 10s
+    backoff-factor: 1.5
+    max-delay: 120
+`},
+		{"hcl", `
+"profile" = {
+	retry = {
+		max-attempts = 5
+		initial-delay = "10s"
+		backoff-factor = 1.5
+		max-delay = 120
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+			require.NotNil(t, profile.Retry)
+
+			assert.Equal(t, 5, profile.Retry.MaxAttempts)
+			assert.Equal(t, 10*time.Second, profile.Retry.InitialDelay)
+			assert.Equal(t, 1.5, profile.Retry.BackoffFactor)
+			assert.Equal(t, 2*time.Minute, profile.Retry.MaxDelay)
+
+			// the retry section is not sent to restic
+			assert.NotContains(t, profile.GetCommonFlags(), "retry")
+			assert.Empty(t, profile.DefinedCommands())
+		})
+	}
+}
+
+func TestNoRepositories(t *testing.T) {
+	profile := NewProfile(nil, "profile")
+	assert.Empty(t, profile.GetRepositories())
+	assert.NotNil(t, profile.GetRepositories())
+}
+
+func TestRunShellCommandsSections(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile]
+run-finally = "echo finally"
+[profile.check]
+run-before = "echo before check"
+run-after = ["echo after check", "echo done"]
+[profile.snapshots]
+run-after-fail = "echo snapshots failed"
+compact = true
+[profile.restore]
+run-before = "echo before restore"
+target = "/tmp"
+`},
+		{"json", `
+{
+  "profile": {
+    "run-finally": "echo finally",
+    "check": {
+      "run-before": "echo before check",
+      "run-after": ["echo after check", "echo done"]
+    },
+    "snapshots": {
+      "run-after-fail": "echo snapshots failed",
+      "compact": true
+    },
+    "restore": {
+      "run-before": "echo before restore",
+      "target": "/tmp"
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  run-finally: echo finally
+  check:
+    run-before: echo before check
+    run-after:
+    - echo after check
+    - echo done
+  snapshots:
+    run-after-fail: echo snapshots failed
+    compact: true
+  restore:
+    run-before: echo before restore
+    target: /tmp
+`},
+		{"hcl", `
+"profile" = {
+	run-finally = "echo finally"
+	check = {
+		run-before = "echo before check"
+		run-after = ["echo after check", "echo done"]
+	}
+	snapshots = {
+		run-after-fail = "echo snapshots failed"
+		compact = true
+	}
+	restore = {
+		run-before = "echo before restore"
+		target = "/tmp"
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+
+			assert.Equal(t, NewShellCommands("echo finally"), profile.RunFinally)
+			assert.Equal(t, RunShellCommandsSection{
+				RunBefore: NewShellCommands("echo before check"),
+				RunAfter:  NewShellCommands("echo after check", "echo done"),
+			}, profile.GetRunShellCommandsSection(constants.CommandCheck))
+			assert.Equal(t, RunShellCommandsSection{
+				RunAfterFail: NewShellCommands("echo snapshots failed"),
+			}, profile.GetRunShellCommandsSection(constants.CommandSnapshots))
+			assert.Equal(t, RunShellCommandsSection{
+				RunBefore: NewShellCommands("echo before restore"),
+			}, profile.GetRunShellCommandsSection("restore"))
+			assert.Equal(t, RunShellCommandsSection{}, profile.GetRunShellCommandsSection(constants.CommandBackup))
+
+			// the shell commands are not sent to restic
+			assert.Equal(t, map[string][]string{}, profile.GetCommandFlags(constants.CommandCheck))
+			assert.Equal(t, map[string][]string{"compact": {}}, profile.GetCommandFlags(constants.CommandSnapshots))
+			assert.Equal(t, map[string][]string{"target": {"/tmp"}}, profile.GetCommandFlags("restore"))
+		})
+	}
+}
+
+func TestEscapeShellPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	testConfig := `
+[profile]
+password-file = "key file"
+lock = "/var/lock/my profile.lock"
+
+[profile.backup]
+source = "/some path"
+exclude = "*.tmp"
+`
+	profile, err := getProfile("toml", testConfig, "profile")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+
+	profile.SetRootPath("/root dir")
+	assert.Equal(t, "/root dir/key file", profile.PasswordFile)
+	assert.Equal(t, []string{"/some path"}, profile.Backup.Source)
+	assert.Equal(t, []string{"*.tmp"}, profile.Backup.Exclude)
+	assert.Nil(t, profile.Backup.ExcludeFile)
+
+	profile.EscapeShellPaths()
+	assert.Equal(t, `/root\ dir/key\ file`, profile.PasswordFile)
+	// the lockfile is not given to the shell
+	assert.Equal(t, "/var/lock/my profile.lock", profile.Lock)
+	assert.Equal(t, []string{`/some\ path`}, profile.Backup.Source)
+	assert.Equal(t, []string{`\*.tmp`}, profile.Backup.Exclude)
+	assert.Nil(t, profile.Backup.ExcludeFile)
+}
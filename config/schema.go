@@ -0,0 +1,297 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/restic"
+)
+
+const (
+	jsonSchemaVersion          = "http://json-schema.org/draft-07/schema#"
+	profileDefinitionRef       = "#/definitions/profile"
+	shellCommandDefinitionRef  = "#/definitions/shell-command"
+	shellCommandsDefinitionRef = "#/definitions/shell-commands"
+	webHookDefinitionRef       = "#/definitions/web-hook"
+	webHooksDefinitionRef      = "#/definitions/web-hooks"
+)
+
+// jsonSchema is the subset of the JSON Schema specification needed to describe the configuration
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+// GenerateJSONSchema writes a JSON schema of the configuration file:
+// it can be used by editors to validate and autocomplete the configuration
+func GenerateJSONSchema(output io.Writer) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newConfigurationSchema())
+}
+
+func newConfigurationSchema() *jsonSchema {
+	profileRef := &jsonSchema{Ref: profileDefinitionRef}
+	return &jsonSchema{
+		Schema: jsonSchemaVersion,
+		Title:  "resticprofile configuration",
+		Type:   "object",
+		Properties: map[string]*jsonSchema{
+			constants.SectionConfigurationGlobal: newStructSchema("global configuration", reflect.TypeOf(Global{}), nil),
+			constants.SectionConfigurationGroups: {
+				Description:          "groups of profiles",
+				Type:                 "object",
+				AdditionalProperties: newGroupSchema(),
+			},
+			constants.SectionConfigurationIncludes: newListSchema("string"),
+			constants.SectionConfigurationSchema:   {Type: "string"},
+			constants.SectionDefinitionCommon:      profileRef,
+			constants.SectionConfigurationMixins: {
+				Description:          "partial profiles to inherit from",
+				Type:                 "object",
+				AdditionalProperties: profileRef,
+			},
+		},
+		AdditionalProperties: profileRef,
+		Definitions: map[string]*jsonSchema{
+			"profile":        newProfileSchema(),
+			"shell-command":  newShellCommandSchema(),
+			"shell-commands": newShellCommandsSchema(),
+			"web-hook":       newWebHookSchema(),
+			"web-hooks":      newWebHooksSchema(),
+		},
+	}
+}
+
+func newProfileSchema() *jsonSchema {
+	schema := newStructSchema("profile", reflect.TypeOf(Profile{}), restic.GlobalOptions())
+	for key, field := range structFields(reflect.TypeOf(Profile{})) {
+		if sectionType, isNamedSections := namedSectionsType(field.Type); isNamedSections {
+			// like the repositories: each section is a block of global flags with its own fields
+			schema.Properties[key] = &jsonSchema{
+				Description:          key + " of the profile, indexed by name",
+				Type:                 "object",
+				AdditionalProperties: newStructSchema("one of the "+key+" of the profile", sectionType, restic.GlobalOptions()),
+			}
+			continue
+		}
+		sectionType, isSection := sectionType(field.Type)
+		if !isSection {
+			continue
+		}
+		if !isCommandSection(key) {
+			// this section is not sent to restic: no flag allowed
+			schema.Properties[key] = newStructSchema(key, sectionType, nil)
+			continue
+		}
+		command := key
+		// the retention section contains flags from the forget command
+		if command == constants.SectionConfigurationRetention {
+			command = constants.CommandForget
+		}
+		schema.Properties[key] = newStructSchema(key+" command", sectionType, commandAndGlobalOptions(command))
+	}
+	// all the other restic commands can have a section with their flags
+	for _, command := range restic.CommandNames() {
+		if _, found := schema.Properties[command]; found {
+			continue
+		}
+		schema.Properties[command] = newStructSchema(command+" command", reflect.TypeOf(RunShellCommandsSection{}), commandAndGlobalOptions(command))
+	}
+	return schema
+}
+
+// commandAndGlobalOptions returns the flags of the command followed by the global flags
+func commandAndGlobalOptions(command string) []restic.Option {
+	commandOptions, _ := restic.CommandOptions(command)
+	options := make([]restic.Option, 0, len(commandOptions)+len(restic.GlobalOptions()))
+	options = append(options, commandOptions...)
+	return append(options, restic.GlobalOptions()...)
+}
+
+// newStructSchema returns the schema of an object with the fields from the struct type and the restic flags
+func newStructSchema(description string, typeOf reflect.Type, options []restic.Option) *jsonSchema {
+	schema := newOptionsSchema(description, options)
+	for key, field := range structFields(typeOf) {
+		property := newTypeSchema(field.Type)
+		if property == nil {
+			continue
+		}
+		// the field is sent as a restic flag: use the restic description
+		if argument, ok := field.Tag.Lookup("argument"); ok {
+			if option, found := findOption(options, argument); found {
+				property.Description = option.Description
+			}
+		}
+		schema.Properties[key] = property
+	}
+	return schema
+}
+
+// newOptionsSchema returns the schema of an object containing the restic flags
+func newOptionsSchema(description string, options []restic.Option) *jsonSchema {
+	schema := &jsonSchema{
+		Description:          description,
+		Type:                 "object",
+		Properties:           make(map[string]*jsonSchema, len(options)),
+		AdditionalProperties: false,
+	}
+	for _, option := range options {
+		if _, found := schema.Properties[option.Name]; found {
+			// command flags take precedence over global flags
+			continue
+		}
+		property := newOptionTypeSchema(option.Type)
+		property.Description = option.Description
+		schema.Properties[option.Name] = property
+	}
+	return schema
+}
+
+// newTypeSchema returns the schema of a value from a configuration struct,
+// or nil if the type is not a simple value (like a section)
+func newTypeSchema(typeOf reflect.Type) *jsonSchema {
+	switch typeOf {
+	case shellCommandsType:
+		return &jsonSchema{Ref: shellCommandsDefinitionRef}
+	case webHooksType:
+		return &jsonSchema{Ref: webHooksDefinitionRef}
+	case durationType:
+		// duration string or number of seconds
+		return &jsonSchema{Type: []string{"string", "integer"}}
+	}
+	switch typeOf.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice:
+		if typeOf.Elem().Kind() == reflect.String {
+			// a single value is also accepted
+			return newListSchema("string")
+		}
+	case reflect.Map:
+		if typeOf.Elem().Kind() == reflect.String {
+			return &jsonSchema{
+				Type:                 "object",
+				AdditionalProperties: &jsonSchema{Type: "string"},
+			}
+		}
+	}
+	return nil
+}
+
+// newShellCommandSchema returns the schema of a shell command: a command line, or an object with more options
+func newShellCommandSchema() *jsonSchema {
+	schema := &jsonSchema{
+		Description:          "shell command",
+		Type:                 []string{"string", "object"},
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: false,
+	}
+	for key, field := range structFields(shellCommandType) {
+		schema.Properties[key] = newTypeSchema(field.Type)
+	}
+	return schema
+}
+
+// newGroupSchema returns the schema of a group: a list of profile names, or an object with more options
+func newGroupSchema() *jsonSchema {
+	schema := newStructSchema("group of profiles", groupType, nil)
+	schema.Type = []string{"array", "object"}
+	schema.Items = &jsonSchema{Type: "string"}
+	return schema
+}
+
+// newShellCommandsSchema returns the schema of a list of shell commands, also accepting a single command
+func newShellCommandsSchema() *jsonSchema {
+	schema := newShellCommandSchema()
+	schema.Description = "shell commands"
+	schema.Type = []string{"string", "object", "array"}
+	schema.Items = &jsonSchema{Ref: shellCommandDefinitionRef}
+	return schema
+}
+
+// newWebHookSchema returns the schema of a web hook: a URL, or an object with more options
+func newWebHookSchema() *jsonSchema {
+	schema := &jsonSchema{
+		Description:          "HTTP request",
+		Type:                 []string{"string", "object"},
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: false,
+	}
+	for key, field := range structFields(webHookType) {
+		schema.Properties[key] = newTypeSchema(field.Type)
+	}
+	return schema
+}
+
+// newWebHooksSchema returns the schema of a list of web hooks, also accepting a single request
+func newWebHooksSchema() *jsonSchema {
+	schema := newWebHookSchema()
+	schema.Description = "HTTP requests"
+	schema.Type = []string{"string", "object", "array"}
+	schema.Items = &jsonSchema{Ref: webHookDefinitionRef}
+	return schema
+}
+
+// newOptionTypeSchema returns the schema of the value of a restic flag
+func newOptionTypeSchema(optionType restic.OptionType) *jsonSchema {
+	types := make([]string, 0, 4)
+	if optionType&restic.TypeBool != 0 {
+		types = append(types, "boolean")
+	}
+	// a number can be used as a string, and a single value as a list
+	if optionType&(restic.TypeInteger|restic.TypeString|restic.TypeList) != 0 {
+		types = append(types, "integer")
+	}
+	if optionType&(restic.TypeString|restic.TypeList) != 0 {
+		types = append(types, "string")
+	}
+	if optionType&restic.TypeList != 0 {
+		schema := newListSchema("string", "integer")
+		schema.Type = append(types, "array")
+		return schema
+	}
+	if len(types) == 1 {
+		return &jsonSchema{Type: types[0]}
+	}
+	return &jsonSchema{Type: types}
+}
+
+// newListSchema returns the schema of a list of values, also accepting a single value
+func newListSchema(itemTypes ...string) *jsonSchema {
+	var items interface{} = itemTypes
+	if len(itemTypes) == 1 {
+		items = itemTypes[0]
+	}
+	types := make([]string, 0, len(itemTypes)+1)
+	types = append(types, itemTypes...)
+	return &jsonSchema{
+		Type:  append(types, "array"),
+		Items: &jsonSchema{Type: items},
+	}
+}
+
+func findOption(options []restic.Option, name string) (restic.Option, bool) {
+	for _, option := range options {
+		if option.Name == name {
+			return option, true
+		}
+	}
+	return restic.Option{}, false
+}
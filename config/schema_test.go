@@ -0,0 +1,144 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateJSONSchema(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	err := GenerateJSONSchema(buffer)
+	require.NoError(t, err)
+
+	schema := &jsonSchema{}
+	err = json.Unmarshal(buffer.Bytes(), schema)
+	require.NoError(t, err)
+
+	assert.Equal(t, jsonSchemaVersion, schema.Schema)
+	assert.Equal(t, map[string]interface{}{"$ref": profileDefinitionRef}, schema.AdditionalProperties)
+
+	require.Contains(t, schema.Properties, "global")
+	global := schema.Properties["global"]
+	assert.Equal(t, false, global.AdditionalProperties)
+	require.Contains(t, global.Properties, "default-command")
+	assert.Equal(t, "string", global.Properties["default-command"].Type)
+	require.Contains(t, global.Properties, "min-memory")
+	assert.Equal(t, "integer", global.Properties["min-memory"].Type)
+
+	require.Contains(t, schema.Definitions, "profile")
+	profile := schema.Definitions["profile"]
+	assert.Equal(t, false, profile.AdditionalProperties)
+
+	testData := []struct {
+		section  string
+		key      string
+		typeName interface{}
+	}{
+		{"", "repository", "string"},
+		{"", "inherit", []interface{}{"string", "array"}},
+		{"", "initialize", "boolean"},
+		{"", "no-cache", "boolean"},
+		{"", "limit-upload", "integer"},
+		{"backup", "source", []interface{}{"string", "array"}},
+		{"backup", "check-before", "boolean"},
+		{"backup", "one-file-system", "boolean"},
+		{"backup", "host", []interface{}{"boolean", "integer", "string"}},
+		{"backup", "tag", []interface{}{"integer", "string", "array"}},
+		{"backup", "schedule", []interface{}{"string", "array"}},
+		{"backup", "no-cache", "boolean"},
+		{"retention", "after-backup", "boolean"},
+		{"retention", "keep-daily", "integer"},
+		{"check", "read-data-subset", []interface{}{"integer", "string"}},
+		{"prune", "schedule-permission", "string"},
+		{"snapshots", "compact", "boolean"},
+		{"mount", "allow-other", "boolean"},
+		{"copy", "repository", "string"},
+		{"copy", "after-backup", "boolean"},
+		{"copy", "password-file", "string"},
+		{"copy", "schedule", []interface{}{"string", "array"}},
+		{"copy", "key-hint2", []interface{}{"integer", "string"}},
+		{"restore", "target", []interface{}{"integer", "string"}},
+		{"restore", "verify", "boolean"},
+		{"restore", "no-cache", "boolean"},
+		{"rebuild-index", "no-cache", "boolean"},
+	}
+	for _, testItem := range testData {
+		properties := profile.Properties
+		if testItem.section != "" {
+			require.Contains(t, properties, testItem.section)
+			assert.Equal(t, false, properties[testItem.section].AdditionalProperties)
+			properties = properties[testItem.section].Properties
+		}
+		require.Containsf(t, properties, testItem.key, "section '%s'", testItem.section)
+		assert.Equalf(t, testItem.typeName, properties[testItem.key].Type, "key '%s' in section '%s'", testItem.key, testItem.section)
+	}
+
+	// shell commands
+	assert.Equal(t, shellCommandsDefinitionRef, profile.Properties["run-finally"].Ref)
+	assert.Equal(t, shellCommandsDefinitionRef, profile.Properties["backup"].Properties["run-after-fail"].Ref)
+	assert.Equal(t, shellCommandsDefinitionRef, profile.Properties["check"].Properties["run-before"].Ref)
+	assert.Equal(t, shellCommandsDefinitionRef, profile.Properties["snapshots"].Properties["run-after"].Ref)
+	assert.Equal(t, shellCommandsDefinitionRef, profile.Properties["restore"].Properties["run-before"].Ref)
+	require.Contains(t, schema.Definitions, "shell-commands")
+	shellCommands := schema.Definitions["shell-commands"]
+	assert.Equal(t, []interface{}{"string", "object", "array"}, shellCommands.Type)
+	assert.Equal(t, shellCommandDefinitionRef, shellCommands.Items.Ref)
+	require.Contains(t, schema.Definitions, "shell-command")
+	shellCommand := schema.Definitions["shell-command"]
+	assert.Equal(t, []interface{}{"string", "object"}, shellCommand.Type)
+	assert.Equal(t, false, shellCommand.AdditionalProperties)
+	assert.Equal(t, "string", shellCommand.Properties["command"].Type)
+	assert.Equal(t, "string", shellCommand.Properties["working-dir"].Type)
+	assert.Equal(t, []interface{}{"string", "integer"}, shellCommand.Properties["timeout"].Type)
+	assert.Equal(t, "boolean", shellCommand.Properties["ignore-error"].Type)
+	assert.Equal(t, "object", shellCommand.Properties["env"].Type)
+
+	require.Contains(t, profile.Properties, "repositories")
+	assert.Equal(t, "repositories of the profile, indexed by name", profile.Properties["repositories"].Description)
+	repository := profile.Properties["repositories"].AdditionalProperties.(map[string]interface{})
+	assert.Equal(t, "one of the repositories of the profile", repository["description"])
+	assert.Equal(t, "string", repository["properties"].(map[string]interface{})["password-file"].(map[string]interface{})["type"])
+	assert.Equal(t, "object", repository["properties"].(map[string]interface{})["env"].(map[string]interface{})["type"])
+	assert.Equal(t, false, repository["additionalProperties"])
+	assert.Contains(t, repository["properties"], "password-file")
+	assert.Contains(t, repository["properties"], "limit-upload")
+	assert.NotContains(t, repository["properties"], "exclude")
+	assert.Equal(t, "string", profile.Properties["repository-failure"].Type)
+
+	require.Contains(t, profile.Properties, "retry")
+	retry := profile.Properties["retry"]
+	assert.Equal(t, "integer", retry.Properties["max-attempts"].Type)
+	assert.Equal(t, []interface{}{"string", "integer"}, retry.Properties["initial-delay"].Type)
+	assert.Equal(t, "number", retry.Properties["backoff-factor"].Type)
+	assert.NotContains(t, retry.Properties, "no-cache")
+
+	require.Contains(t, schema.Properties, "groups")
+	group := schema.Properties["groups"].AdditionalProperties.(map[string]interface{})
+	assert.Equal(t, []interface{}{"array", "object"}, group["type"])
+	assert.Equal(t, map[string]interface{}{"type": "string"}, group["items"])
+	assert.Contains(t, group["properties"], "parallel")
+	assert.Contains(t, group["properties"], "continue-on-error")
+	assert.Contains(t, group["properties"], "description")
+	assert.Contains(t, group["properties"], "lock")
+
+	// restic flags are not allowed where they're not supported
+	assert.NotContains(t, profile.Properties, "exclude")
+	assert.NotContains(t, profile.Properties["snapshots"].Properties, "exclude")
+	assert.NotContains(t, profile.Properties["backup"].Properties, "keep-daily")
+}
+
+func TestSchemaKeyIsNotAProfile(t *testing.T) {
+	testConfig := `{
+  "$schema": "./schema.json",
+  "profile": { "repository": "/repo" }
+}`
+	c, err := Load(bytes.NewBufferString(testConfig), "json")
+	require.NoError(t, err)
+	assert.False(t, c.HasProfile("$schema"))
+	assert.Equal(t, map[string][]string{"profile": {}}, c.GetProfileSections())
+	assert.Empty(t, c.Validate())
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/creativeprojects/clog"
+)
+
+// labelSelectorPrefix starts a selector of the profiles by label, like "label:nightly"
+const labelSelectorPrefix = "label:"
+
+// IsProfileSelector returns true when the name selects a list of profiles:
+// a wildcard pattern ("home-*") or a label ("label:nightly")
+func IsProfileSelector(name string) bool {
+	return strings.HasPrefix(name, labelSelectorPrefix) || strings.ContainsAny(name, "*?[")
+}
+
+// SelectProfiles returns the names of the profiles matching the selector, sorted by name.
+// The selector is either a wildcard pattern on the profile names, or a label prefixed by "label:"
+func (c *Config) SelectProfiles(selector string) ([]string, error) {
+	if strings.HasPrefix(selector, labelSelectorPrefix) {
+		return c.GetProfilesWithLabel(strings.TrimPrefix(selector, labelSelectorPrefix))
+	}
+	selected := make([]string, 0)
+	for name := range c.GetProfileSections() {
+		match, err := path.Match(selector, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile name pattern '%s': %w", selector, err)
+		}
+		if match {
+			selected = append(selected, name)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// GetProfilesWithLabel returns the names of the profiles declaring the label (directly or from a parent profile), sorted by name
+func (c *Config) GetProfilesWithLabel(label string) ([]string, error) {
+	if label == "" {
+		return nil, fmt.Errorf("missing label name after '%s'", labelSelectorPrefix)
+	}
+	selected := make([]string, 0)
+	for name := range c.GetProfileSections() {
+		profile, err := c.GetProfile(name)
+		if err != nil {
+			clog.Warningf("cannot load profile '%s': %v", name, err)
+			continue
+		}
+		if profile != nil && profile.HasLabel(label) {
+			selected = append(selected, name)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// HasLabel returns true if the profile declares the label
+func (p *Profile) HasLabel(label string) bool {
+	for _, profileLabel := range p.Labels {
+		if profileLabel == label {
+			return true
+		}
+	}
+	return false
+}
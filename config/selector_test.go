@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsProfileSelector(t *testing.T) {
+	testData := []struct {
+		name     string
+		selector bool
+	}{
+		{"default", false},
+		{"home-documents", false},
+		{"home-*", true},
+		{"server?", true},
+		{"[ab]*", true},
+		{"label:nightly", true},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.selector, IsProfileSelector(testItem.name), testItem.name)
+	}
+}
+
+func TestSelectProfiles(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[base]
+labels = ["nightly"]
+[home-documents]
+inherit = "base"
+[home-photos]
+labels = ["weekly", "photos"]
+[server]
+labels = "nightly"
+`},
+		{"json", `
+{
+  "base": { "labels": ["nightly"] },
+  "home-documents": { "inherit": "base" },
+  "home-photos": { "labels": ["weekly", "photos"] },
+  "server": { "labels": "nightly" }
+}`},
+		{"yaml", `
+base:
+  labels: [nightly]
+home-documents:
+  inherit: base
+home-photos:
+  labels: [weekly, photos]
+server:
+  labels: nightly
+`},
+		{"hcl", `
+"base" = {
+  labels = ["nightly"]
+}
+"home-documents" = {
+  inherit = "base"
+}
+"home-photos" = {
+  labels = ["weekly", "photos"]
+}
+"server" = {
+  labels = "nightly"
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			c, err := Load(bytes.NewBufferString(testConfig), format)
+			require.NoError(t, err)
+
+			profiles, err := c.SelectProfiles("home-*")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"home-documents", "home-photos"}, profiles)
+
+			profiles, err = c.SelectProfiles("*")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"base", "home-documents", "home-photos", "server"}, profiles)
+
+			profiles, err = c.SelectProfiles("label:nightly")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"base", "home-documents", "server"}, profiles)
+
+			profiles, err = c.SelectProfiles("label:photos")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"home-photos"}, profiles)
+
+			profiles, err = c.SelectProfiles("label:monthly")
+			require.NoError(t, err)
+			assert.Empty(t, profiles)
+
+			_, err = c.SelectProfiles("label:")
+			assert.Error(t, err)
+
+			_, err = c.SelectProfiles("home-[")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ShellCommand is a command run by resticprofile before or after restic (run-before, run-after, etc.).
+// It can be defined as a simple string containing the command line, or as an object with more options
+type ShellCommand struct {
+	Command     string            `mapstructure:"command"`
+	Shell       string            `mapstructure:"shell"`
+	WorkingDir  string            `mapstructure:"working-dir"`
+	Timeout     time.Duration     `mapstructure:"timeout"`
+	Environment map[string]string `mapstructure:"env"`
+	IgnoreError bool              `mapstructure:"ignore-error"`
+}
+
+// String returns the command line
+func (c ShellCommand) String() string {
+	return c.Command
+}
+
+// ShellCommands is a list of shell commands. In the configuration, it can be a single command or a list of commands
+type ShellCommands []ShellCommand
+
+// NewShellCommands creates a list of shell commands from their command lines
+func NewShellCommands(commandLines ...string) ShellCommands {
+	commands := make(ShellCommands, len(commandLines))
+	for i, commandLine := range commandLines {
+		commands[i] = ShellCommand{Command: commandLine}
+	}
+	return commands
+}
+
+// mapConverter is a configuration value which can be converted into a map
+type mapConverter interface {
+	ToMap() map[string]interface{}
+}
+
+var (
+	shellCommandType  = reflect.TypeOf(ShellCommand{})
+	shellCommandsType = reflect.TypeOf(ShellCommands{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+)
+
+// shellCommandsHookFunc converts the short forms of the shell commands:
+// a single command instead of a list, and a command line instead of an object.
+// The web hooks have the same short forms: a single request instead of a list, and a URL instead of an object.
+// It also converts the timeout from a duration string ("30s", "5m") or a number of seconds
+func shellCommandsHookFunc() mapstructure.DecodeHookFunc {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		switch to {
+		case shellCommandsType, webHooksType:
+			if from.Kind() == reflect.String || from.Kind() == reflect.Map {
+				return []interface{}{data}, nil
+			}
+		case shellCommandType, webHookType:
+			if from.Kind() == reflect.String {
+				if to == webHookType {
+					return map[string]interface{}{"url": data}, nil
+				}
+				return map[string]interface{}{"command": data}, nil
+			}
+			if tree, ok := data.(mapConverter); ok {
+				// TOML inline table in a list containing other types of values
+				return tree.ToMap(), nil
+			}
+		case durationType:
+			switch from.Kind() {
+			case reflect.String:
+				return time.ParseDuration(data.(string))
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return time.Duration(reflect.ValueOf(data).Int()) * time.Second, nil
+			case reflect.Float32, reflect.Float64:
+				return time.Duration(reflect.ValueOf(data).Float() * float64(time.Second)), nil
+			}
+		}
+		return data, nil
+	}
+}
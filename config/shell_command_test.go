@@ -0,0 +1,161 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellCommands(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile]
+run-before = "echo before"
+run-after = ["echo after", "echo done"]
+run-finally = { command = "rm dump.sql", ignore-error = true }
+
+[[profile.run-after-fail]]
+command = "mail -s failed root"
+shell = "bash"
+working-dir = "/tmp"
+timeout = "30s"
+env = { subject = "backup" }
+
+[[profile.run-after-fail]]
+command = "echo failed"
+timeout = 10
+
+[profile.backup]
+run-before = [{ command = "pg_dump -f dump.sql", shell = "none", timeout = "5m" }, "echo dumped"]
+`},
+		{"json", `
+{
+  "profile": {
+    "run-before": "echo before",
+    "run-after": ["echo after", "echo done"],
+    "run-finally": { "command": "rm dump.sql", "ignore-error": true },
+    "run-after-fail": [
+      {
+        "command": "mail -s failed root",
+        "shell": "bash",
+        "working-dir": "/tmp",
+        "timeout": "30s",
+        "env": { "subject": "backup" }
+      },
+      { "command": "echo failed", "timeout": 10 }
+    ],
+    "backup": {
+      "run-before": [{ "command": "pg_dump -f dump.sql", "shell": "none", "timeout": "5m" }, "echo dumped"]
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  run-before: echo before
+  run-after:
+  - echo after
+  - echo done
+  run-finally:
+    command: rm dump.sql
+    ignore-error: true
+  run-after-fail:
+  - command: mail -s failed root
+    shell: bash
+    working-dir: /tmp
+    timeout: 30s
+    env:
+      subject: backup
+  - command: echo failed
+    timeout: 10
+  backup:
+    run-before:
+    - command: pg_dump -f dump.sql
+      shell: none
+      timeout: 5m
+    - echo dumped
+`},
+		{"hcl", `
+"profile" = {
+	run-before = "echo before"
+	run-after = ["echo after", "echo done"]
+	run-finally = {
+		command = "rm dump.sql"
+		ignore-error = true
+	}
+	run-after-fail = [{
+		command = "mail -s failed root"
+		shell = "bash"
+		working-dir = "/tmp"
+		timeout = "30s"
+		env = {
+			subject = "backup"
+		}
+	}, {
+		command = "echo failed"
+		timeout = 10
+	}]
+	backup = {
+		run-before = [{
+			command = "pg_dump -f dump.sql"
+			shell = "none"
+			timeout = "5m"
+		}, "echo dumped"]
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+
+			assert.Equal(t, NewShellCommands("echo before"), profile.RunBefore)
+			assert.Equal(t, NewShellCommands("echo after", "echo done"), profile.RunAfter)
+			assert.Equal(t, ShellCommands{{Command: "rm dump.sql", IgnoreError: true}}, profile.RunFinally)
+			assert.Equal(t, ShellCommands{
+				{
+					Command:     "mail -s failed root",
+					Shell:       "bash",
+					WorkingDir:  "/tmp",
+					Timeout:     30 * time.Second,
+					Environment: map[string]string{"subject": "backup"},
+				},
+				{Command: "echo failed", Timeout: 10 * time.Second},
+			}, profile.RunAfterFail)
+			assert.Equal(t, ShellCommands{
+				{Command: "pg_dump -f dump.sql", Shell: "none", Timeout: 5 * time.Minute},
+				{Command: "echo dumped"},
+			}, profile.GetRunShellCommandsSection(constants.CommandBackup).RunBefore)
+		})
+	}
+}
+
+func TestShellCommandsInGenericSection(t *testing.T) {
+	profile := NewProfile(nil, "profile")
+	profile.OtherSections = map[string]map[string]interface{}{
+		"restore": {
+			"run-before": map[string]interface{}{"command": "echo before", "timeout": "1m"},
+			"run-after":  []interface{}{"echo after", map[string]interface{}{"command": "echo done", "ignore-error": true}},
+		},
+	}
+	assert.Equal(t, RunShellCommandsSection{
+		RunBefore: ShellCommands{{Command: "echo before", Timeout: time.Minute}},
+		RunAfter:  ShellCommands{{Command: "echo after"}, {Command: "echo done", IgnoreError: true}},
+	}, profile.GetRunShellCommandsSection("restore"))
+}
+
+func TestInvalidShellCommandTimeout(t *testing.T) {
+	testConfig := `
+[profile]
+run-before = { command = "echo before", timeout = "forever" }
+`
+	_, err := getProfile("toml", testConfig, "profile")
+	assert.Error(t, err)
+}
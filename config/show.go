@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 )
 
 // ShowStruct write out to w a human readable text representation of the orig parameter
@@ -35,6 +36,12 @@ func showSubStruct(orig interface{}, stack []string, display *Display) error {
 	for i := 0; i < typeOf.NumField(); i++ {
 		field := typeOf.Field(i)
 
+		if field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Map {
+			// map of sections (like the sections of the restic commands without a specific field)
+			showSections(stack, display, valueOf.Field(i))
+			continue
+		}
+
 		if key, ok := field.Tag.Lookup("mapstructure"); ok {
 			if key == "" {
 				continue
@@ -68,6 +75,14 @@ func showSubStruct(orig interface{}, stack []string, display *Display) error {
 				if valueOf.Field(i).Len() == 0 {
 					continue
 				}
+				if field.Type.Elem().Kind() == reflect.Ptr {
+					// map of named sections (like the repositories)
+					err := showNamedSections(append(stack, key), display, valueOf.Field(i))
+					if err != nil {
+						return err
+					}
+					continue
+				}
 				if key == ",remain" {
 					// special case of the map of remaining parameters: display on the same level
 					showMap(stack, display, valueOf.Field(i))
@@ -90,6 +105,35 @@ func showMap(stack []string, display *Display, valueOf reflect.Value) {
 	}
 }
 
+func showSections(stack []string, display *Display, valueOf reflect.Value) {
+	for _, key := range sortedMapKeys(valueOf) {
+		showMap(append(stack, key), display, valueOf.MapIndex(reflect.ValueOf(key)))
+	}
+}
+
+func showNamedSections(stack []string, display *Display, valueOf reflect.Value) error {
+	for _, key := range sortedMapKeys(valueOf) {
+		section := valueOf.MapIndex(reflect.ValueOf(key))
+		if section.IsNil() {
+			continue
+		}
+		err := showSubStruct(section.Interface(), append(stack, key), display)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedMapKeys(valueOf reflect.Value) []string {
+	keys := make([]string, 0, valueOf.Len())
+	for _, key := range valueOf.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func showKeyValue(stack []string, display *Display, key string, valueOf reflect.Value) {
 	// hard-coded case for "inherit": we don't need to display it
 	if key == "inherit" {
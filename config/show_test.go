@@ -3,6 +3,7 @@ package config
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,6 +36,15 @@ type testEmbedded struct {
 	InlineValue    int `mapstructure:"inline"`
 }
 
+type testSections struct {
+	Id       int `mapstructure:"id"`
+	Sections map[string]map[string]interface{}
+}
+
+type testShellCommands struct {
+	RunBefore ShellCommands `mapstructure:"run-before"`
+}
+
 type EmbeddedStruct struct {
 	Value bool `mapstructure:"value"`
 }
@@ -71,6 +81,20 @@ func TestShowStruct(t *testing.T) {
 			input:  testEmbedded{EmbeddedStruct{Value: true}, 1},
 			output: " value:   true\n inline:  1\n",
 		},
+		{
+			input: testSections{Id: 11, Sections: map[string]map[string]interface{}{
+				"second": {"valid": true},
+				"first":  {"name": "test"},
+			}},
+			output: " id:  11\n\n first:\n  name:  test\n\n second:\n  valid:  true\n",
+		},
+		{
+			input: testShellCommands{RunBefore: ShellCommands{
+				{Command: "echo first"},
+				{Command: "echo second", Timeout: time.Minute},
+			}},
+			output: " run-before:  echo first\n     echo second\n",
+		},
 	}
 
 	for _, testItem := range testData {
@@ -53,7 +53,7 @@ run-before = ["echo {{ .Profile.Name }}"]
 	require.NoError(t, err)
 	require.NotEmpty(t, profile)
 
-	assert.Equal(t, "echo profile1", profile.RunBefore[0])
+	assert.Equal(t, "echo profile1", profile.RunBefore[0].Command)
 }
 
 func TestResolveStructThenSliceValue(t *testing.T) {
@@ -69,7 +69,7 @@ run-before = ["echo {{ .Profile.Name }}"]
 	require.NoError(t, err)
 	require.NotEmpty(t, profile)
 
-	assert.Equal(t, "echo profile1", profile.Backup.RunBefore[0])
+	assert.Equal(t, "echo profile1", profile.Backup.RunBefore[0].Command)
 }
 
 func TestResolveStructThenSliceTwoValues(t *testing.T) {
@@ -85,7 +85,7 @@ run-before = ["echo {{ .Profile.Name }}", "ls -al"]
 	require.NoError(t, err)
 	require.NotEmpty(t, profile)
 
-	assert.Contains(t, profile.Backup.RunBefore, "echo profile1")
+	assert.Contains(t, profile.Backup.RunBefore, ShellCommand{Command: "echo profile1"})
 }
 
 func TestYamlResolveStructThenSliceValue(t *testing.T) {
@@ -102,7 +102,7 @@ profile1:
 	require.NoError(t, err)
 	require.NotEmpty(t, profile)
 
-	assert.Equal(t, "echo profile1", profile.Backup.RunBefore[0])
+	assert.Equal(t, "echo profile1", profile.Backup.RunBefore[0].Command)
 }
 
 func TestYamlResolveStructThenSliceTwoValue(t *testing.T) {
@@ -120,7 +120,7 @@ profile1:
 	require.NoError(t, err)
 	require.NotEmpty(t, profile)
 
-	assert.Contains(t, profile.Backup.RunBefore, "echo profile1")
+	assert.Contains(t, profile.Backup.RunBefore, ShellCommand{Command: "echo profile1"})
 }
 
 func TestResolveRemainMap(t *testing.T) {
@@ -0,0 +1,512 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/creativeprojects/resticprofile/calendar"
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/restic"
+)
+
+// ValidationIssue is an error found while validating the configuration
+type ValidationIssue struct {
+	Section string
+	Message string
+}
+
+func (v ValidationIssue) String() string {
+	return fmt.Sprintf("[%s] %s", v.Section, v.Message)
+}
+
+type validator struct {
+	config *Config
+	issues []ValidationIssue
+}
+
+func (v *validator) addIssue(section, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{
+		Section: section,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate loads all the profiles and groups from the configuration, and returns all the issues found:
+// unknown keys, type mismatches, missing parent profiles, groups with unknown profiles and invalid schedules
+func (c *Config) Validate() []ValidationIssue {
+	v := &validator{
+		config: c,
+		issues: make([]ValidationIssue, 0),
+	}
+	v.validateGlobal()
+	v.validateGroups()
+
+	if c.IsSet(constants.SectionDefinitionCommon) {
+		v.validateProfileDefinition(constants.SectionDefinitionCommon, c.Get(constants.SectionDefinitionCommon))
+	}
+
+	mixins, err := c.getMixins()
+	if err != nil {
+		v.addIssue(constants.SectionConfigurationMixins, "%v", err)
+	}
+	for _, name := range sortedKeys(mixins) {
+		v.validateProfileDefinition(constants.SectionConfigurationMixins+"."+name, mixins[name])
+	}
+
+	profileNames := make([]string, 0)
+	for name := range c.GetProfileSections() {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	for _, name := range profileNames {
+		v.validateProfile(name)
+	}
+	return v.issues
+}
+
+func (v *validator) validateGlobal() {
+	if !v.config.IsSet(constants.SectionConfigurationGlobal) {
+		return
+	}
+	_, err := v.config.GetGlobalSection()
+	if err != nil {
+		v.addIssue(constants.SectionConfigurationGlobal, "%v", err)
+	}
+	raw, ok := toMap(v.config.Get(constants.SectionConfigurationGlobal))
+	if !ok {
+		v.addIssue(constants.SectionConfigurationGlobal, "expected a section")
+		return
+	}
+	fields := structKeys(reflect.TypeOf(Global{}))
+	for _, key := range sortedKeys(raw) {
+		if _, found := fields[key]; !found {
+			v.addIssue(constants.SectionConfigurationGlobal, "unknown key '%s'", key)
+		}
+	}
+}
+
+func (v *validator) validateGroups() {
+	if !v.config.IsSet(constants.SectionConfigurationGroups) {
+		return
+	}
+	err := v.config.loadGroups()
+	if err != nil {
+		v.addIssue(constants.SectionConfigurationGroups, "%v", err)
+		return
+	}
+	raw, _ := toMap(v.config.Get(constants.SectionConfigurationGroups))
+	for _, groupName := range sortedKeys(v.config.groups) {
+		group := v.config.groups[groupName]
+		if v.config.HasProfile(groupName) {
+			v.addIssue(constants.SectionConfigurationGroups, "group '%s' has the same name as a profile", groupName)
+		}
+		for _, profileName := range group.Profiles {
+			if _, isGroup := v.config.groups[profileName]; !isGroup && !v.config.HasProfile(profileName) {
+				v.addIssue(constants.SectionConfigurationGroups, "group '%s' references an unknown profile '%s'", groupName, profileName)
+			}
+		}
+		if _, err := expandGroup(v.config.groups, groupName); err != nil {
+			v.addIssue(constants.SectionConfigurationGroups, "%v", err)
+		}
+		section := constants.SectionConfigurationGroups + "." + groupName
+		if definition, ok := toMap(raw[groupName]); ok {
+			// object form of the group
+			v.validateSection(section, groupType, definition)
+		}
+		if group.Parallel < 0 {
+			v.addIssue(section, "invalid value for 'parallel': %d (expected 0 or more)", group.Parallel)
+		}
+		if group.LockWait < 0 {
+			v.addIssue(section, "invalid value for 'lock-wait': %s", group.LockWait)
+		}
+	}
+}
+
+func (v *validator) validateProfile(name string) {
+	// loading the profile checks the types of all the known fields, and the inheritance
+	profile, err := v.config.GetProfile(name)
+	if err != nil {
+		v.addIssue(name, "%v", err)
+	}
+	v.validateProfileDefinition(name, v.config.Get(name))
+	if profile == nil {
+		return
+	}
+	switch profile.RepoFailure {
+	case "", constants.RepositoryFailureStop, constants.RepositoryFailureContinue, constants.RepositoryFailureIgnore:
+	default:
+		v.addIssue(name, "invalid value for 'repository-failure': %q (expected %q, %q or %q)",
+			profile.RepoFailure, constants.RepositoryFailureStop, constants.RepositoryFailureContinue, constants.RepositoryFailureIgnore)
+	}
+	switch profile.LockMode {
+	case "", constants.LockModeFile, constants.LockModeFlock:
+	default:
+		v.addIssue(name, "invalid value for 'lock-mode': %q (expected %q or %q)", profile.LockMode, constants.LockModeFile, constants.LockModeFlock)
+	}
+	if profile.LockWait < 0 {
+		v.addIssue(name, "invalid value for 'lock-wait': %s", profile.LockWait)
+	}
+	if profile.PromTimeout < 0 {
+		v.addIssue(name, "invalid value for 'prometheus-push-timeout': %s", profile.PromTimeout)
+	}
+	if profile.Retry != nil {
+		if profile.Retry.MaxAttempts < 0 {
+			v.addIssue(name+".retry", "invalid value for 'max-attempts': %d", profile.Retry.MaxAttempts)
+		}
+		if profile.Retry.InitialDelay < 0 {
+			v.addIssue(name+".retry", "invalid value for 'initial-delay': %s", profile.Retry.InitialDelay)
+		}
+		if profile.Retry.BackoffFactor != 0 && profile.Retry.BackoffFactor < 1 {
+			v.addIssue(name+".retry", "invalid value for 'backoff-factor': %g (expected 1 or more)", profile.Retry.BackoffFactor)
+		}
+		if profile.Retry.MaxDelay < 0 {
+			v.addIssue(name+".retry", "invalid value for 'max-delay': %s", profile.Retry.MaxDelay)
+		}
+	}
+	if profile.Email != nil {
+		v.validateEmail(name+".email", profile)
+	}
+	schedules := profile.Schedules()
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].SubTitle() < schedules[j].SubTitle()
+	})
+	for _, schedule := range schedules {
+		for _, value := range schedule.Schedules() {
+			event := calendar.NewEvent()
+			err = event.Parse(value)
+			if err != nil {
+				v.addIssue(name+"."+schedule.SubTitle(), "invalid schedule '%s': %v", value, err)
+			}
+		}
+	}
+}
+
+// validateEmail checks the email section of a profile
+func (v *validator) validateEmail(section string, profile *Profile) {
+	email := profile.Email
+	if email.Host == "" {
+		v.addIssue(section, "missing 'host'")
+	}
+	if email.Port < 0 || email.Port > 65535 {
+		v.addIssue(section, "invalid value for 'port': %d", email.Port)
+	}
+	switch email.Security {
+	case "", constants.EmailSecurityStartTLS, constants.EmailSecurityPlain:
+	default:
+		v.addIssue(section, "invalid value for 'security': %q (expected %q or %q)", email.Security, constants.EmailSecurityStartTLS, constants.EmailSecurityPlain)
+	}
+	if email.AuthNeedsEncryption() {
+		v.addIssue(section, "'username' cannot be used with the %q security: the password is only sent over an encrypted connection (%q security) or to localhost", constants.EmailSecurityPlain, constants.EmailSecurityStartTLS)
+	}
+	if email.PasswordEnv != "" && email.PasswordFile != "" {
+		v.addIssue(section, "'password-env' and 'password-file' cannot be used together")
+	}
+	if email.From == "" {
+		v.addIssue(section, "missing 'from'")
+	}
+	if len(email.To) == 0 {
+		v.addIssue(section, "missing 'to'")
+	}
+	for _, on := range email.SendOn {
+		switch on {
+		case constants.EmailSendOnFailure, constants.EmailSendOnSuccess:
+		case constants.EmailSendOnChange:
+			if profile.StatusFile == "" {
+				v.addIssue(section, "'send-on' %q needs a 'status-file' to remember the previous result", on)
+			}
+		default:
+			v.addIssue(section, "invalid value for 'send-on': %q (expected %q, %q or %q)", on, constants.EmailSendOnFailure, constants.EmailSendOnSuccess, constants.EmailSendOnChange)
+		}
+	}
+}
+
+// validateProfileDefinition checks all the keys of a profile (or mixin) definition
+func (v *validator) validateProfileDefinition(section string, definition interface{}) {
+	if definition == nil {
+		return
+	}
+	raw, ok := toMap(definition)
+	if !ok {
+		v.addIssue(section, "expected a section")
+		return
+	}
+	fields := structFields(reflect.TypeOf(Profile{}))
+	for _, key := range sortedKeys(raw) {
+		value := raw[key]
+		if field, found := fields[key]; found {
+			if field.Type == shellCommandsType {
+				v.validateShellCommands(section, key, value)
+			}
+			if field.Type == webHooksType {
+				v.validateWebHooks(section, key, value)
+			}
+			if sectionType, isSection := sectionType(field.Type); isSection {
+				if isCommandSection(key) {
+					v.validateCommandSection(section+"."+key, key, sectionType, value)
+				} else {
+					v.validateSection(section+"."+key, sectionType, value)
+				}
+			}
+			if sectionType, isNamedSections := namedSectionsType(field.Type); isNamedSections {
+				v.validateNamedSections(section+"."+key, sectionType, value)
+			}
+			continue
+		}
+		if _, isMap := toMap(value); isMap {
+			if restic.IsCommand(key) {
+				v.validateCommandSection(section+"."+key, key, reflect.TypeOf(RunShellCommandsSection{}), value)
+				continue
+			}
+			v.addIssue(section, "unknown section '%s'", key)
+			continue
+		}
+		// no command given: only the global flags are valid here
+		v.validateFlag(section, "", key, value)
+	}
+}
+
+// validateCommandSection checks all the keys of a command section (backup, retention, check, etc.)
+func (v *validator) validateCommandSection(section, command string, sectionType reflect.Type, definition interface{}) {
+	if definition == nil {
+		return
+	}
+	raw, ok := toMap(definition)
+	if !ok {
+		v.addIssue(section, "expected a section")
+		return
+	}
+	// the retention section contains flags from the forget command
+	if command == constants.SectionConfigurationRetention {
+		command = constants.CommandForget
+	}
+	fields := structFields(sectionType)
+	for _, key := range sortedKeys(raw) {
+		if field, found := fields[key]; found {
+			if field.Type == shellCommandsType {
+				v.validateShellCommands(section, key, raw[key])
+			}
+			if field.Type == webHooksType {
+				v.validateWebHooks(section, key, raw[key])
+			}
+			continue
+		}
+		if _, isMap := toMap(raw[key]); isMap {
+			v.addIssue(section, "unknown section '%s'", key)
+			continue
+		}
+		v.validateFlag(section, command, key, raw[key])
+	}
+}
+
+// validateSection checks the keys of a section which is not sent to restic (like retry):
+// it can only contain its own fields
+func (v *validator) validateSection(section string, sectionType reflect.Type, definition interface{}) {
+	if definition == nil {
+		return
+	}
+	raw, ok := toMap(definition)
+	if !ok {
+		v.addIssue(section, "expected a section")
+		return
+	}
+	keys := structKeys(sectionType)
+	for _, key := range sortedKeys(raw) {
+		if !keys[key] {
+			v.addIssue(section, "unknown key '%s'", key)
+		}
+	}
+}
+
+// validateNamedSections checks all the sections indexed by name (like the repositories of a profile):
+// they can only contain their own fields and the global flags
+func (v *validator) validateNamedSections(section string, sectionType reflect.Type, definition interface{}) {
+	if definition == nil {
+		return
+	}
+	raw, ok := toMap(definition)
+	if !ok {
+		v.addIssue(section, "expected a section")
+		return
+	}
+	for _, name := range sortedKeys(raw) {
+		v.validateCommandSection(section+"."+name, "", sectionType, raw[name])
+	}
+}
+
+// validateShellCommands checks the keys of the shell commands defined as objects
+func (v *validator) validateShellCommands(section, key string, definition interface{}) {
+	keys := structKeys(shellCommandType)
+	for _, definition := range listOfDefinitions(definition) {
+		command, isObject := toMap(definition)
+		if !isObject {
+			// command line only
+			continue
+		}
+		for _, name := range sortedKeys(command) {
+			if !keys[name] {
+				v.addIssue(section, "unknown key '%s' in '%s'", name, key)
+			}
+		}
+		if commandLine, _ := command["command"].(string); commandLine == "" {
+			v.addIssue(section, "missing command in '%s'", key)
+		}
+	}
+}
+
+// validateWebHooks checks the keys of the web hooks defined as objects
+func (v *validator) validateWebHooks(section, key string, definition interface{}) {
+	keys := structKeys(webHookType)
+	for _, definition := range listOfDefinitions(definition) {
+		hook, isObject := toMap(definition)
+		if !isObject {
+			// URL only
+			continue
+		}
+		for _, name := range sortedKeys(hook) {
+			if !keys[name] {
+				v.addIssue(section, "unknown key '%s' in '%s'", name, key)
+			}
+		}
+		if url, _ := hook["url"].(string); url == "" {
+			v.addIssue(section, "missing url in '%s'", key)
+		}
+	}
+}
+
+// listOfDefinitions returns the items of a value which can be a single item or a list
+func listOfDefinitions(definition interface{}) []interface{} {
+	switch value := definition.(type) {
+	case []interface{}:
+		return value
+	case []map[string]interface{}:
+		// HCL: each block is an item
+		items := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			items = append(items, item)
+		}
+		return items
+	default:
+		return []interface{}{definition}
+	}
+}
+
+// validateFlag checks the flag exists for this restic command and has the right type of value
+func (v *validator) validateFlag(section, command, key string, value interface{}) {
+	option, found := restic.LookupOption(command, key)
+	if !found {
+		if command == "" {
+			v.addIssue(section, "unknown flag '%s'", key)
+			return
+		}
+		v.addIssue(section, "unknown flag '%s' for restic command '%s'", key, command)
+		return
+	}
+	valueType, valid := restic.ValueType(value)
+	if !valid || !option.Accept(valueType) {
+		v.addIssue(section, "invalid value for flag '%s': %s", key, describeValue(value))
+	}
+}
+
+// structFields returns all the fields of the struct indexed by their configuration key
+func structFields(typeOf reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < typeOf.NumField(); i++ {
+		field := typeOf.Field(i)
+		tag, ok := field.Tag.Lookup("mapstructure")
+		if !ok {
+			continue
+		}
+		if tag == ",squash" {
+			for key, subField := range structFields(field.Type) {
+				fields[key] = subField
+			}
+			continue
+		}
+		key := strings.Split(tag, ",")[0]
+		if key == "" {
+			continue
+		}
+		fields[key] = field
+	}
+	return fields
+}
+
+// structKeys returns the configuration keys of all the fields of the struct
+func structKeys(typeOf reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for key := range structFields(typeOf) {
+		keys[key] = true
+	}
+	return keys
+}
+
+// sectionType returns true if the type of the field is a command section
+// (a pointer to a struct, or a generic map of flags which can also contain the shell commands)
+func sectionType(fieldType reflect.Type) (reflect.Type, bool) {
+	if fieldType.Kind() == reflect.Ptr && fieldType.Elem().Kind() == reflect.Struct {
+		return fieldType.Elem(), true
+	}
+	if fieldType.Kind() == reflect.Map && fieldType.Elem().Kind() == reflect.Interface {
+		return reflect.TypeOf(RunShellCommandsSection{}), true
+	}
+	return nil, false
+}
+
+// isCommandSection returns true if the section of the profile contains the flags of a restic command
+func isCommandSection(key string) bool {
+	return key == constants.SectionConfigurationRetention || restic.IsCommand(key)
+}
+
+// namedSectionsType returns the type of the sections if the field is a map of sections indexed by name
+func namedSectionsType(fieldType reflect.Type) (reflect.Type, bool) {
+	if fieldType.Kind() == reflect.Map && fieldType.Elem().Kind() == reflect.Ptr && fieldType.Elem().Elem().Kind() == reflect.Struct {
+		return fieldType.Elem().Elem(), true
+	}
+	return nil, false
+}
+
+// toMap converts a raw configuration section into a map (flattening the list of maps from HCL)
+func toMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case mapConverter:
+		return v.ToMap(), true
+	case []map[string]interface{}:
+		flatten := make(map[string]interface{})
+		for _, item := range v {
+			for key, value := range item {
+				flatten[key] = value
+			}
+		}
+		return flatten, true
+	}
+	return nil, false
+}
+
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return fmt.Sprintf("boolean %v", value)
+	case string:
+		return fmt.Sprintf("string %q", value)
+	case []interface{}, []string:
+		return fmt.Sprintf("list %v", value)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func sortedKeys(data interface{}) []string {
+	valueOf := reflect.ValueOf(data)
+	if valueOf.Kind() != reflect.Map {
+		return nil
+	}
+	keys := make([]string, 0, valueOf.Len())
+	for _, key := range valueOf.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,557 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validate(t *testing.T, format, configString string) []string {
+	c, err := Load(bytes.NewBufferString(configString), format)
+	require.NoError(t, err)
+	issues := c.Validate()
+	messages := make([]string, len(issues))
+	for i, issue := range issues {
+		messages[i] = issue.String()
+	}
+	return messages
+}
+
+func TestValidConfiguration(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[global]
+default-command = "snapshots"
+
+[groups]
+all = ["first", "second"]
+
+[first]
+repository = "/first"
+password-file = "key"
+no-cache = true
+limit-upload = 100
+
+[first.backup]
+source = "/"
+exclude = ["/tmp"]
+one-file-system = true
+host = true
+tag = "tag"
+schedule = "daily"
+
+[first.retention]
+keep-daily = 7
+prune = true
+
+[first.snapshots]
+host = true
+
+[second]
+inherit = "first"
+`},
+		{"json", `{
+  "global": { "default-command": "snapshots" },
+  "groups": { "all": ["first", "second"] },
+  "first": {
+    "repository": "/first",
+    "password-file": "key",
+    "no-cache": true,
+    "limit-upload": 100,
+    "backup": {
+      "source": "/",
+      "exclude": ["/tmp"],
+      "one-file-system": true,
+      "host": true,
+      "tag": "tag",
+      "schedule": "daily"
+    },
+    "retention": { "keep-daily": 7, "prune": true },
+    "snapshots": { "host": true }
+  },
+  "second": { "inherit": "first" }
+}`},
+		{"yaml", `---
+global:
+  default-command: snapshots
+groups:
+  all:
+  - first
+  - second
+first:
+  repository: /first
+  password-file: key
+  no-cache: true
+  limit-upload: 100
+  backup:
+    source: /
+    exclude:
+    - /tmp
+    one-file-system: true
+    host: true
+    tag: tag
+    schedule: daily
+  retention:
+    keep-daily: 7
+    prune: true
+  snapshots:
+    host: true
+second:
+  inherit: first
+`},
+		{"hcl", `
+global {
+  default-command = "snapshots"
+}
+groups {
+  all = ["first", "second"]
+}
+first {
+  repository = "/first"
+  password-file = "key"
+  no-cache = true
+  limit-upload = 100
+  backup {
+    source = "/"
+    exclude = ["/tmp"]
+    one-file-system = true
+    host = true
+    tag = "tag"
+    schedule = "daily"
+  }
+  retention {
+    keep-daily = 7
+    prune = true
+  }
+  snapshots {
+    host = true
+  }
+}
+second {
+  inherit = "first"
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			assert.Empty(t, validate(t, format, testConfig))
+		})
+	}
+}
+
+func TestValidateUnknownFlags(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[global]
+default-comand = "snapshots"
+
+[profile]
+repository = "/repo"
+no-cach = true
+
+[profile.backup]
+source = "/"
+exlude = "/tmp"
+
+[profile.retention]
+keep-dayly = 7
+
+[profile.restor]
+target = "/"
+`},
+		{"json", `{
+  "global": { "default-comand": "snapshots" },
+  "profile": {
+    "repository": "/repo",
+    "no-cach": true,
+    "backup": { "source": "/", "exlude": "/tmp" },
+    "retention": { "keep-dayly": 7 },
+    "restor": { "target": "/" }
+  }
+}`},
+		{"yaml", `---
+global:
+  default-comand: snapshots
+profile:
+  repository: /repo
+  no-cach: true
+  backup:
+    source: /
+    exlude: /tmp
+  retention:
+    keep-dayly: 7
+  restor:
+    target: /
+`},
+		{"hcl", `
+global {
+  default-comand = "snapshots"
+}
+profile {
+  repository = "/repo"
+  no-cach = true
+  backup {
+    source = "/"
+    exlude = "/tmp"
+  }
+  retention {
+    keep-dayly = 7
+  }
+  restor {
+    target = "/"
+  }
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			assert.ElementsMatch(t, []string{
+				"[global] unknown key 'default-comand'",
+				"[profile] unknown flag 'no-cach'",
+				"[profile] unknown section 'restor'",
+				"[profile.backup] unknown flag 'exlude' for restic command 'backup'",
+				"[profile.retention] unknown flag 'keep-dayly' for restic command 'forget'",
+			}, validate(t, format, testConfig))
+		})
+	}
+}
+
+func TestValidateOtherCommandSections(t *testing.T) {
+	testConfig := `
+[profile]
+repository = "/repo"
+
+[profile.restore]
+target = "/"
+verify = true
+targt = "/tmp"
+run-before = "echo restoring"
+
+[profile.ls]
+long = "yes"
+
+[profile.check]
+run-after-fail = "echo check failed"
+`
+	assert.Equal(t, []string{
+		"[profile.ls] invalid value for flag 'long': string \"yes\"",
+		"[profile.restore] unknown flag 'targt' for restic command 'restore'",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateInvalidValues(t *testing.T) {
+	testConfig := `
+[profile]
+repository = "/repo"
+verbose = "yes"
+no-cache = "yes"
+
+[profile.backup]
+source = "/"
+one-file-system = 1
+limit-upload = "fast"
+
+[profile.retention]
+keep-last = 2.5
+`
+	issues := validate(t, "toml", testConfig)
+	assert.Contains(t, issues, "[profile] invalid value for flag 'no-cache': string \"yes\"")
+	assert.Contains(t, issues, "[profile.backup] invalid value for flag 'one-file-system': 1")
+	assert.Contains(t, issues, "[profile.backup] invalid value for flag 'limit-upload': string \"fast\"")
+	assert.Contains(t, issues, "[profile.retention] invalid value for flag 'keep-last': 2.5")
+	// the type of the profile fields is checked when loading the profile
+	require.Len(t, issues, 5)
+	assert.Contains(t, issues[0], "[profile] ")
+	assert.Contains(t, issues[0], "verbose")
+}
+
+func TestValidateRepositories(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile]
+repository-failure = "skip"
+
+[profile.repositories.local]
+repository = "/local"
+limit-upload = 100
+
+[profile.repositories.remote]
+repository = "sftp:server:/remote"
+pasword-file = "key"
+exclude = "/tmp"
+
+[profile.repositories.remote.backup]
+source = "/"
+`},
+		{"json", `{
+  "profile": {
+    "repository-failure": "skip",
+    "repositories": {
+      "local": { "repository": "/local", "limit-upload": 100 },
+      "remote": {
+        "repository": "sftp:server:/remote",
+        "pasword-file": "key",
+        "exclude": "/tmp",
+        "backup": { "source": "/" }
+      }
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  repository-failure: skip
+  repositories:
+    local:
+      repository: /local
+      limit-upload: 100
+    remote:
+      repository: sftp:server:/remote
+      pasword-file: key
+      exclude: /tmp
+      backup:
+        source: /
+`},
+		{"hcl", `
+profile {
+  repository-failure = "skip"
+  repositories "local" {
+    repository = "/local"
+    limit-upload = 100
+  }
+  repositories "remote" {
+    repository = "sftp:server:/remote"
+    pasword-file = "key"
+    exclude = "/tmp"
+    backup {
+      source = "/"
+    }
+  }
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			assert.Equal(t, []string{
+				"[profile.repositories.remote] unknown section 'backup'",
+				"[profile.repositories.remote] unknown flag 'exclude'",
+				"[profile.repositories.remote] unknown flag 'pasword-file'",
+				"[profile] invalid value for 'repository-failure': \"skip\" (expected \"stop\", \"continue\" or \"ignore\")",
+			}, validate(t, format, testConfig))
+		})
+	}
+}
+
+func TestValidateLockAndRetry(t *testing.T) {
+	testConfig := `
+[profile]
+lock-mode = "fcntl"
+lock-wait = "-1m"
+
+[profile.retry]
+max-attempts = -1
+backoff-factor = 0.5
+initial-delay = "10s"
+max-wait = "1m"
+`
+	assert.Equal(t, []string{
+		"[profile.retry] unknown key 'max-wait'",
+		"[profile] invalid value for 'lock-mode': \"fcntl\" (expected \"file\" or \"flock\")",
+		"[profile] invalid value for 'lock-wait': -1m0s",
+		"[profile.retry] invalid value for 'max-attempts': -1",
+		"[profile.retry] invalid value for 'backoff-factor': 0.5 (expected 1 or more)",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidatePrometheusPushTimeout(t *testing.T) {
+	testConfig := `
+[profile]
+prometheus-push = "http://localhost:9091"
+prometheus-push-timeout = "-5s"
+`
+	assert.Equal(t, []string{
+		"[profile] invalid value for 'prometheus-push-timeout': -5s",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateShellCommands(t *testing.T) {
+	testConfig := `
+[profile]
+run-before = ["echo before", { command = "echo object", timout = "1m" }]
+run-after = { shell = "bash" }
+
+[profile.backup]
+run-before = { command = "echo backup", ignore-errors = true }
+
+[profile.restore]
+run-after = { comand = "echo restore" }
+`
+	assert.Equal(t, []string{
+		"[profile.backup] unknown key 'ignore-errors' in 'run-before'",
+		"[profile.restore] unknown key 'comand' in 'run-after'",
+		"[profile.restore] missing command in 'run-after'",
+		"[profile] missing command in 'run-after'",
+		"[profile] unknown key 'timout' in 'run-before'",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateInheritanceAndGroups(t *testing.T) {
+	testConfig := `
+[groups]
+all = ["first", "second", "unknown"]
+
+[first]
+inherit = "missing"
+
+[second]
+inherit = "first"
+`
+	assert.Equal(t, []string{
+		"[groups] group 'all' references an unknown profile 'unknown'",
+		"[first] error in profile 'first': parent profile 'missing' not found",
+		"[second] error in profile 'first': parent profile 'missing' not found",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateGroupOptions(t *testing.T) {
+	testConfig := `
+[groups.all]
+profiles = ["first"]
+parallel = -1
+continue-on-eror = true
+
+[groups.list]
+profiles = ["first"]
+parallel = 2
+
+[first]
+`
+	assert.Equal(t, []string{
+		"[groups.all] unknown key 'continue-on-eror'",
+		"[groups.all] invalid value for 'parallel': -1 (expected 0 or more)",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateNestedGroups(t *testing.T) {
+	testConfig := `
+[groups]
+first = ["profile", "second"]
+second = ["first"]
+third = ["first-profile", "fourth"]
+fourth = ["profile"]
+
+[profile]
+`
+	assert.Equal(t, []string{
+		"[groups] group 'first' is including itself: first > second > first",
+		"[groups] group 'second' is including itself: second > first > second",
+		"[groups] group 'third' references an unknown profile 'first-profile'",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateSchedules(t *testing.T) {
+	testConfig := `
+[profile]
+repository = "/repo"
+
+[profile.backup]
+source = "/"
+schedule = ["daily", "every minute"]
+
+[profile.check]
+schedule = "*:00,30"
+`
+	assert.Equal(t, []string{
+		"[profile.backup] invalid schedule 'every minute': calendar event doesn't match any well known pattern",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateMixinsAndCommon(t *testing.T) {
+	testConfig := `
+[common]
+no-cach = true
+
+[mixins.tagged.backup]
+tagg = "mixin"
+
+[profile]
+inherit = "tagged"
+repository = "/repo"
+`
+	assert.Equal(t, []string{
+		"[common] unknown flag 'no-cach'",
+		"[mixins.tagged.backup] unknown flag 'tagg' for restic command 'backup'",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateWebHooks(t *testing.T) {
+	testConfig := `
+[profile]
+send-before = "https://example.com/start"
+send-after = { url = "https://example.com/done", retries = 3 }
+
+[profile.backup]
+send-after-fail = { method = "POST" }
+`
+	assert.Equal(t, []string{
+		"[profile.backup] missing url in 'send-after-fail'",
+		"[profile] unknown key 'retries' in 'send-after'",
+	}, validate(t, "toml", testConfig))
+}
+
+func TestValidateEmail(t *testing.T) {
+	testConfig := `
+[valid]
+status-file = "status.json"
+[valid.email]
+host = "smtp.example.com"
+from = "backup@example.com"
+to = "admin@example.com"
+send-on = ["failure", "change"]
+
+[relay]
+[relay.email]
+host = "localhost"
+security = "plain"
+username = "backup"
+from = "backup@example.com"
+to = "admin@example.com"
+
+[invalid]
+[invalid.email]
+port = 100000
+security = "ssl"
+password-env = "SMTP_PASSWORD"
+password-file = "smtp-password"
+send-on = ["change", "always"]
+
+[plain]
+[plain.email]
+host = "smtp.example.com"
+security = "plain"
+username = "backup"
+from = "backup@example.com"
+to = "admin@example.com"
+`
+	assert.Equal(t, []string{
+		"[invalid.email] missing 'host'",
+		"[invalid.email] invalid value for 'port': 100000",
+		`[invalid.email] invalid value for 'security': "ssl" (expected "starttls" or "plain")`,
+		"[invalid.email] 'password-env' and 'password-file' cannot be used together",
+		"[invalid.email] missing 'from'",
+		"[invalid.email] missing 'to'",
+		`[invalid.email] 'send-on' "change" needs a 'status-file' to remember the previous result`,
+		`[invalid.email] invalid value for 'send-on': "always" (expected "failure", "success" or "change")`,
+		`[plain.email] 'username' cannot be used with the "plain" security: the password is only sent over an encrypted connection ("starttls" security) or to localhost`,
+	}, validate(t, "toml", testConfig))
+}
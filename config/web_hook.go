@@ -0,0 +1,49 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// WebHook is an HTTP request sent by resticprofile before or after restic (send-before, send-after, send-after-fail).
+// It can be defined as a simple URL, or as an object with more options.
+// The body is a template receiving the details of the profile and of the last run of the command:
+// it can also be loaded from a file (body-template)
+type WebHook struct {
+	URL                 string            `mapstructure:"url"`
+	Method              string            `mapstructure:"method"`
+	Headers             map[string]string `mapstructure:"headers"`
+	Body                string            `mapstructure:"body"`
+	BodyTemplate        string            `mapstructure:"body-template"`
+	Timeout             time.Duration     `mapstructure:"timeout"`
+	SkipTLSVerification bool              `mapstructure:"skip-tls-verification"`
+}
+
+// String returns the URL
+func (h WebHook) String() string {
+	return h.URL
+}
+
+// WebHooks is a list of HTTP requests. In the configuration, it can be a single request or a list of requests
+type WebHooks []WebHook
+
+// NewWebHooks creates a list of HTTP requests from their URLs
+func NewWebHooks(urls ...string) WebHooks {
+	hooks := make(WebHooks, len(urls))
+	for i, url := range urls {
+		hooks[i] = WebHook{URL: url}
+	}
+	return hooks
+}
+
+// setRootPath changes the path of the body templates relative to the configuration file
+func (h WebHooks) setRootPath(rootPath string) {
+	for i := range h {
+		h[i].BodyTemplate = fixPath(h[i].BodyTemplate, expandEnv, absolutePrefix(rootPath))
+	}
+}
+
+var (
+	webHookType  = reflect.TypeOf(WebHook{})
+	webHooksType = reflect.TypeOf(WebHooks{})
+)
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebHooks(t *testing.T) {
+	testData := []testTemplate{
+		{"toml", `
+[profile]
+send-before = "https://hc-ping.com/uuid/start"
+send-after = ["https://hc-ping.com/uuid", "https://example.com/done"]
+
+[[profile.send-after-fail]]
+url = "https://chat.example.com/hooks/backup"
+method = "POST"
+headers = { Authorization = "Bearer token" }
+body = '{"text": "backup failed: {{ ` + "`{{ .Error }}`" + ` }}"}'
+timeout = "10s"
+skip-tls-verification = true
+
+[profile.backup]
+send-after = { url = "https://example.com/backup", timeout = 5, body-template = "/etc/resticprofile/backup.tmpl" }
+`},
+		{"json", `
+{
+  "profile": {
+    "send-before": "https://hc-ping.com/uuid/start",
+    "send-after": ["https://hc-ping.com/uuid", "https://example.com/done"],
+    "send-after-fail": [
+      {
+        "url": "https://chat.example.com/hooks/backup",
+        "method": "POST",
+        "headers": { "Authorization": "Bearer token" },
+        "body": "{\"text\": \"backup failed: {{ ` + "`{{ .Error }}`" + ` }}\"}",
+        "timeout": "10s",
+        "skip-tls-verification": true
+      }
+    ],
+    "backup": {
+      "send-after": { "url": "https://example.com/backup", "timeout": 5, "body-template": "/etc/resticprofile/backup.tmpl" }
+    }
+  }
+}`},
+		{"yaml", `---
+profile:
+  send-before: https://hc-ping.com/uuid/start
+  send-after:
+  - https://hc-ping.com/uuid
+  - https://example.com/done
+  send-after-fail:
+  - url: https://chat.example.com/hooks/backup
+    method: POST
+    headers:
+      Authorization: Bearer token
+    body: '{"text": "backup failed: {{ ` + "`{{ .Error }}`" + ` }}"}'
+    timeout: 10s
+    skip-tls-verification: true
+  backup:
+    send-after:
+      url: https://example.com/backup
+      timeout: 5
+      body-template: /etc/resticprofile/backup.tmpl
+`},
+		{"hcl", `
+"profile" = {
+	send-before = "https://hc-ping.com/uuid/start"
+	send-after = ["https://hc-ping.com/uuid", "https://example.com/done"]
+	send-after-fail = [{
+		url = "https://chat.example.com/hooks/backup"
+		method = "POST"
+		headers = {
+			Authorization = "Bearer token"
+		}
+		body = "{\"text\": \"backup failed: {{ ` + "`{{ .Error }}`" + ` }}\"}"
+		timeout = "10s"
+		skip-tls-verification = true
+	}]
+	backup = {
+		send-after = {
+			url = "https://example.com/backup"
+			timeout = 5
+			body-template = "/etc/resticprofile/backup.tmpl"
+		}
+	}
+}
+`},
+	}
+
+	for _, testItem := range testData {
+		format := testItem.format
+		testConfig := testItem.config
+		t.Run(format, func(t *testing.T) {
+			profile, err := getProfile(format, testConfig, "profile")
+			require.NoError(t, err)
+			require.NotNil(t, profile)
+
+			assert.Equal(t, NewWebHooks("https://hc-ping.com/uuid/start"), profile.SendBefore)
+			assert.Equal(t, NewWebHooks("https://hc-ping.com/uuid", "https://example.com/done"), profile.SendAfter)
+			assert.Equal(t, WebHooks{
+				{
+					URL:     "https://chat.example.com/hooks/backup",
+					Method:  "POST",
+					Headers: map[string]string{"Authorization": "Bearer token"},
+					// the runtime fields are escaped from the template of the configuration file
+					Body:                `{"text": "backup failed: {{ .Error }}"}`,
+					Timeout:             10 * time.Second,
+					SkipTLSVerification: true,
+				},
+			}, profile.SendAfterFail)
+			assert.Equal(t, WebHooks{
+				{URL: "https://example.com/backup", Timeout: 5 * time.Second, BodyTemplate: "/etc/resticprofile/backup.tmpl"},
+			}, profile.GetRunShellCommandsSection(constants.CommandBackup).SendAfter)
+		})
+	}
+}
+
+func TestWebHooksInGenericSection(t *testing.T) {
+	testConfig := `
+[profile]
+send-after = { url = "https://example.com/done", body-template = "done.tmpl" }
+
+[profile.snapshots]
+send-after = { url = "https://example.com/snapshots", body-template = "templates/snapshots.tmpl" }
+compact = true
+
+[profile.check]
+send-after-fail = { url = "https://example.com/check", body-template = "/templates/check.tmpl" }
+`
+	profile, err := getProfile("toml", testConfig, "profile")
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	profile.SetRootPath("/etc/resticprofile")
+
+	// the body templates are relative to the configuration file
+	assert.Equal(t, filepath.FromSlash("/etc/resticprofile/done.tmpl"), profile.SendAfter[0].BodyTemplate)
+	assert.Equal(t, filepath.FromSlash("/etc/resticprofile/templates/snapshots.tmpl"), profile.GetRunShellCommandsSection(constants.CommandSnapshots).SendAfter[0].BodyTemplate)
+	assert.Equal(t, "/templates/check.tmpl", profile.GetRunShellCommandsSection(constants.CommandCheck).SendAfterFail[0].BodyTemplate)
+	flags := profile.GetCommandFlags(constants.CommandSnapshots)
+	assert.Contains(t, flags, "compact")
+	assert.NotContains(t, flags, "send-after")
+}
@@ -9,4 +9,5 @@ const (
 	CommandPrune     = "prune"
 	CommandSnapshots = "snapshots"
 	CommandMount     = "mount"
+	CommandCopy      = "copy"
 )
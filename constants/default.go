@@ -1,5 +1,7 @@
 package constants
 
+import "time"
+
 // Configuration defaults
 const (
 	DefaultConfigurationFile  = "profiles"
@@ -13,4 +15,13 @@ const (
 	DefaultVerboseFlag        = false
 	DefaultQuietFlag          = false
 	DefaultMinMemory          = 100
+	DefaultRetryInitialDelay  = 30 * time.Second
+	DefaultRetryBackoffFactor = 2.0
+	DefaultRetryMaxDelay      = time.Hour
+	DefaultStopGracePeriod    = 30 * time.Second
+	DefaultPromPushTimeout    = 30 * time.Second
+	DefaultPromPushJob        = "resticprofile"
+	DefaultSendTimeout        = 30 * time.Second
+	DefaultEmailStartTLSPort  = 587
+	DefaultEmailPlainPort     = 25
 )
@@ -6,6 +6,9 @@ const (
 	SectionConfigurationRetention   = "retention"
 	SectionConfigurationEnvironment = "env"
 	SectionConfigurationGroups      = "groups"
+	SectionConfigurationIncludes    = "includes"
+	SectionConfigurationMixins      = "mixins"
+	SectionConfigurationSchema      = "$schema"
 
 	SectionDefinitionCommon = "common"
 	SectionDefinitionForget = "forget"
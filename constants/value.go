@@ -6,4 +6,14 @@ const (
 	SchedulePermissionSystem   = "system"
 	SchedulePriorityBackground = "background"
 	SchedulePriorityStandard   = "standard"
+	RepositoryFailureStop      = "stop"
+	RepositoryFailureContinue  = "continue"
+	RepositoryFailureIgnore    = "ignore"
+	LockModeFile               = "file"
+	LockModeFlock              = "flock"
+	EmailSecurityStartTLS      = "starttls"
+	EmailSecurityPlain         = "plain"
+	EmailSendOnFailure         = "failure"
+	EmailSendOnSuccess         = "success"
+	EmailSendOnChange          = "change"
 )
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+)
+
+const (
+	defaultEmailSubject = "resticprofile: {{ .Command }} {{ if .Error }}failed{{ else }}succeeded{{ end }} on profile {{ .ProfileName }}"
+	defaultEmailBody    = `Profile: {{ .ProfileName }}
+Command: {{ .Command }}
+{{ if .Error }}Error: {{ .Error }}
+{{ if .ErrorCommandLine }}Command line: {{ .ErrorCommandLine }}
+{{ end }}{{ if .Stderr }}
+{{ .Stderr }}
+{{ end }}{{ else }}Result: success
+{{ end }}{{ if not .Stats.End.IsZero }}Duration: {{ .Stats.Duration }}s
+{{ end }}{{ with .Stats.Summary }}Snapshot: {{ .SnapshotID }}
+Files: {{ .FilesNew }} new, {{ .FilesChanged }} changed, {{ .FilesUnmodified }} unmodified
+Added to the repository: {{ .DataAdded }} bytes
+{{ end }}`
+)
+
+// sendEmail sends the email of the profile when the result matches its 'send-on' option.
+// A failure is only logged: it doesn't change the result of the profile
+func (r *resticWrapper) sendEmail(fail error) {
+	email := r.profile.Email
+	if email == nil {
+		return
+	}
+	if email.SendOnChange() && r.profile.StatusFile == "" {
+		clog.Warningf("profile '%s': the email is sent on a change of state without a status file: only a failure is a change", r.profile.Name)
+	}
+	// without a previous run, only a failure is a change of state
+	previousSuccess := r.prevStatus == nil || r.prevStatus.Success
+	if !email.SendOnResult(fail == nil, previousSuccess) {
+		return
+	}
+	message, err := newEmailMessage(email, r.getWebHookData(fail))
+	if err != nil {
+		clog.Warningf("profile '%s': cannot create the email: %v", r.profile.Name, err)
+		return
+	}
+	if r.dryRun {
+		clog.Infof("dry-run: sending email to %s", strings.Join(email.To, ", "))
+		return
+	}
+	clog.Debugf("sending email to %s", strings.Join(email.To, ", "))
+	err = sendSMTPMessage(email, message)
+	if err != nil {
+		clog.Warningf("profile '%s': sending email via %s: %v", r.profile.Name, email.Host, err)
+	}
+}
+
+// newEmailMessage generates the headers and the body of the email from their templates
+func newEmailMessage(email *config.EmailSection, data webHookData) ([]byte, error) {
+	source := email.Subject
+	if source == "" {
+		source = defaultEmailSubject
+	}
+	subject, err := renderTemplate(source, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	source, err = loadTemplate(email.Body, email.BodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if source == "" {
+		source = defaultEmailBody
+	}
+	body, err := renderTemplate(source, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	// the subject must stay on one line
+	subject = strings.Join(strings.Fields(subject), " ")
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "From: %s\r\n", email.From)
+	fmt.Fprintf(buffer, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(body)
+	return buffer.Bytes(), nil
+}
+
+// sendSMTPMessage sends the message to the SMTP server, after switching to TLS unless the security is plain
+func sendSMTPMessage(email *config.EmailSection, message []byte) error {
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", email.From, err)
+	}
+	recipients := make([]string, len(email.To))
+	for i, to := range email.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+		recipients[i] = address.Address
+	}
+	if email.AuthNeedsEncryption() {
+		return fmt.Errorf("cannot authenticate on %s with the %q security: use the %q security to send the password over an encrypted connection", email.Host, constants.EmailSecurityPlain, constants.EmailSecurityStartTLS)
+	}
+	password, err := getEmailPassword(email)
+	if err != nil {
+		return err
+	}
+
+	startTLS := email.Security != constants.EmailSecurityPlain
+	port := email.Port
+	if port == 0 {
+		port = constants.DefaultEmailStartTLSPort
+		if !startTLS {
+			port = constants.DefaultEmailPlainPort
+		}
+	}
+	timeout := email.Timeout
+	if timeout == 0 {
+		timeout = constants.DefaultSendTimeout
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(email.Host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, email.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if hostname, err := os.Hostname(); err == nil {
+		err = client.Hello(hostname)
+		if err != nil {
+			return err
+		}
+	}
+	if startTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("the server doesn't support STARTTLS: use the plain security to send the email without encryption")
+		}
+		err = client.StartTLS(&tls.Config{ServerName: email.Host, InsecureSkipVerify: email.SkipTLSVerification})
+		if err != nil {
+			return err
+		}
+	}
+	if email.Username != "" {
+		// the password is never sent over an unencrypted connection, unless the server is on localhost
+		err = client.Auth(smtp.PlainAuth("", email.Username, password, email.Host))
+		if err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// getEmailPassword returns the password of the SMTP server, from an environment variable or from a file
+func getEmailPassword(email *config.EmailSection) (string, error) {
+	if email.PasswordEnv != "" {
+		return os.Getenv(email.PasswordEnv), nil
+	}
+	if email.PasswordFile != "" {
+		content, err := ioutil.ReadFile(email.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot load the password: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	return "", nil
+}
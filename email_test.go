@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/creativeprojects/resticprofile/term"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer is a minimal SMTP server recording the messages it receives
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	mutex     sync.Mutex
+	auth      []string
+	from      []string
+	to        []string
+	messages  []string
+}
+
+// newFakeSMTPServer starts a server on localhost. It supports STARTTLS when tlsConfig is not nil
+func newFakeSMTPServer(t *testing.T, tlsConfig *tls.Config) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeSMTPServer{listener: listener, tlsConfig: tlsConfig}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) close() {
+	s.listener.Close()
+}
+
+func (s *fakeSMTPServer) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 localhost fake SMTP server")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i > 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		s.mutex.Lock()
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			_ = text.PrintfLine("250-localhost")
+			if s.tlsConfig != nil {
+				_ = text.PrintfLine("250-STARTTLS")
+			}
+			_ = text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			_ = text.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				s.mutex.Unlock()
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			decoded, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			s.auth = append(s.auth, string(decoded))
+			_ = text.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.from = append(s.from, arg)
+			_ = text.PrintfLine("250 OK")
+		case "RCPT":
+			s.to = append(s.to, arg)
+			_ = text.PrintfLine("250 OK")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			content, err := text.ReadDotBytes()
+			if err != nil {
+				s.mutex.Unlock()
+				return
+			}
+			s.messages = append(s.messages, string(content))
+			_ = text.PrintfLine("250 OK")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			s.mutex.Unlock()
+			return
+		default:
+			_ = text.PrintfLine("502 not implemented")
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// testTLSConfig returns the configuration of a server using the self-signed certificate of httptest
+func testTLSConfig() *tls.Config {
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	defer server.Close()
+	return &tls.Config{Certificates: server.TLS.Certificates}
+}
+
+func TestNewEmailMessage(t *testing.T) {
+	email := &config.EmailSection{
+		From:    "Backup <backup@example.com>",
+		To:      []string{"admin@example.com", "ops@example.com"},
+		Subject: "{{ .ProfileName }}\n{{ .Command }} failed: {{ .Error }}",
+		Body:    "{{ .Stderr }}",
+	}
+	message, err := newEmailMessage(email, webHookData{ProfileName: "home", Command: "backup", Error: "exit status 1", Stderr: "unable to open repository"})
+	require.NoError(t, err)
+
+	headers, body := splitMessage(t, string(message))
+	assert.Equal(t, "Backup <backup@example.com>", headers.Get("From"))
+	assert.Equal(t, "admin@example.com, ops@example.com", headers.Get("To"))
+	// the subject is on one line
+	assert.Equal(t, "home backup failed: exit status 1", headers.Get("Subject"))
+	assert.NotEmpty(t, headers.Get("Date"))
+	assert.Equal(t, "text/plain; charset=utf-8", headers.Get("Content-Type"))
+	assert.Equal(t, "unable to open repository", body)
+
+	// non-ASCII characters are encoded
+	email.Subject = "sauvegarde échouée"
+	message, err = newEmailMessage(email, webHookData{})
+	require.NoError(t, err)
+	headers, _ = splitMessage(t, string(message))
+	assert.Equal(t, "=?utf-8?q?sauvegarde_=C3=A9chou=C3=A9e?=", headers.Get("Subject"))
+
+	email.Subject = "{{ .Unknown }}"
+	_, err = newEmailMessage(email, webHookData{})
+	assert.Error(t, err)
+}
+
+func TestNewEmailMessageWithDefaultTemplates(t *testing.T) {
+	email := &config.EmailSection{From: "backup@example.com", To: []string{"admin@example.com"}}
+	data := webHookData{
+		ProfileName: "home",
+		Command:     "backup",
+		Stats: status.CommandStatus{
+			Success:  true,
+			End:      time.Now(),
+			Duration: 12.5,
+			Summary:  &status.BackupSummary{SnapshotID: "1234abcd", FilesNew: 2, FilesChanged: 1, FilesUnmodified: 5, DataAdded: 1024},
+		},
+	}
+	message, err := newEmailMessage(email, data)
+	require.NoError(t, err)
+	headers, body := splitMessage(t, string(message))
+	assert.Equal(t, "resticprofile: backup succeeded on profile home", headers.Get("Subject"))
+	assert.Equal(t, `Profile: home
+Command: backup
+Result: success
+Duration: 12.5s
+Snapshot: 1234abcd
+Files: 2 new, 1 changed, 5 unmodified
+Added to the repository: 1024 bytes
+`, body)
+
+	message, err = newEmailMessage(email, webHookData{ProfileName: "home", Command: "check", Error: "exit status 1", ErrorCommandLine: `"restic" "check"`, Stderr: "repository is damaged"})
+	require.NoError(t, err)
+	headers, body = splitMessage(t, string(message))
+	assert.Equal(t, "resticprofile: check failed on profile home", headers.Get("Subject"))
+	assert.Equal(t, `Profile: home
+Command: check
+Error: exit status 1
+Command line: "restic" "check"
+
+repository is damaged
+`, body)
+}
+
+func TestSendSMTPMessage(t *testing.T) {
+	server := newFakeSMTPServer(t, testTLSConfig())
+	defer server.close()
+
+	os.Setenv("TEST_SMTP_PASSWORD", "secret")
+	defer os.Unsetenv("TEST_SMTP_PASSWORD")
+
+	email := &config.EmailSection{
+		Host:        "127.0.0.1",
+		Port:        server.port(),
+		Username:    "backup",
+		PasswordEnv: "TEST_SMTP_PASSWORD",
+		From:        "Backup <backup@example.com>",
+		To:          []string{"admin@example.com"},
+		Timeout:     time.Second,
+	}
+	// self-signed certificate
+	err := sendSMTPMessage(email, []byte("Subject: test\r\n\r\nhello\r\n"))
+	assert.Error(t, err)
+	assert.Empty(t, server.received())
+
+	email.SkipTLSVerification = true
+	err = sendSMTPMessage(email, []byte("Subject: test\r\n\r\nhello\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Subject: test\n\nhello\n"}, server.received())
+	assert.Equal(t, []string{"\x00backup\x00secret"}, server.auth)
+	assert.Equal(t, []string{"FROM:<backup@example.com>"}, server.from)
+	assert.Equal(t, []string{"TO:<admin@example.com>"}, server.to)
+}
+
+func TestSendSMTPMessagePlain(t *testing.T) {
+	server := newFakeSMTPServer(t, nil)
+	defer server.close()
+
+	passwordFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.txt", "TestSendSMTPMessagePlain", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(passwordFile)
+	require.NoError(t, ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	email := &config.EmailSection{
+		Host:         "127.0.0.1",
+		Port:         server.port(),
+		Username:     "backup",
+		PasswordFile: passwordFile,
+		From:         "backup@example.com",
+		To:           []string{"admin@example.com"},
+		Timeout:      time.Second,
+	}
+	// STARTTLS is the default
+	err := sendSMTPMessage(email, []byte("hello\r\n"))
+	assert.Error(t, err)
+
+	email.Security = "plain"
+	err = sendSMTPMessage(email, []byte("hello\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello\n"}, server.received())
+	assert.Equal(t, []string{"\x00backup\x00secret"}, server.auth)
+
+	email.To = []string{"not an address"}
+	err = sendSMTPMessage(email, []byte("hello\r\n"))
+	assert.Error(t, err)
+
+	// the password is never sent in clear to another server
+	email.Host = "smtp.example.com"
+	email.To = []string{"admin@example.com"}
+	err = sendSMTPMessage(email, []byte("hello\r\n"))
+	assert.EqualError(t, err, `cannot authenticate on smtp.example.com with the "plain" security: use the "starttls" security to send the password over an encrypted connection`)
+}
+
+func TestSendEmailOnResult(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+
+	server := newFakeSMTPServer(t, nil)
+	defer server.close()
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestSendEmailOnResult", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.StatusFile = statusFile
+	profile.Check = &config.OtherSectionWithSchedule{}
+	profile.Email = &config.EmailSection{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Security: "plain",
+		From:     "backup@example.com",
+		To:       []string{"admin@example.com"},
+		Subject:  "{{ .ProfileName }} {{ .Command }}: {{ if .Error }}{{ .Error }}{{ else }}OK{{ end }}",
+		SendOn:   []string{"change"},
+		Timeout:  time.Second,
+	}
+
+	run := func(resticBinary string) {
+		wrapper := newResticWrapper(resticBinary, false, false, profile, "check", nil, nil)
+		_ = wrapper.runProfile()
+	}
+	// the first success is not a change
+	run("echo")
+	assert.Len(t, server.received(), 0)
+	run("exit 1;")
+	require.Len(t, server.received(), 1)
+	assert.Contains(t, server.received()[0], "Subject: name check: check on profile 'name': exit status 1\n")
+	run("exit 1;")
+	assert.Len(t, server.received(), 1)
+	run("echo")
+	require.Len(t, server.received(), 2)
+	assert.Contains(t, server.received()[1], "Subject: name check: OK\n")
+}
+
+func TestSendEmailDryRun(t *testing.T) {
+	server := newFakeSMTPServer(t, nil)
+	defer server.close()
+
+	profile := config.NewProfile(nil, "name")
+	profile.Email = &config.EmailSection{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Security: "plain",
+		From:     "backup@example.com",
+		To:       []string{"admin@example.com"},
+	}
+	wrapper := newResticWrapper("echo", false, true, profile, "backup", nil, nil)
+	wrapper.sendEmail(fmt.Errorf("failed"))
+	assert.Empty(t, server.received())
+}
+
+// splitMessage returns the headers and the body of the message
+func splitMessage(t *testing.T, message string) (textproto.MIMEHeader, string) {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(message)))
+	headers, err := reader.ReadMIMEHeader()
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(reader.R)
+	require.NoError(t, err)
+	return headers, string(body)
+}
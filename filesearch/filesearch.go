@@ -2,10 +2,12 @@ package filesearch
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/adrg/xdg"
@@ -14,6 +16,9 @@ import (
 var (
 	XDGAppName = "resticprofile"
 
+	// dropInDirectorySuffix is added to the configuration file name (without extension) to make the drop-in directory name
+	dropInDirectorySuffix = ".d"
+
 	// configurationExtensions list the possible extensions for the config file
 	configurationExtensions = []string{
 		"conf",
@@ -95,6 +100,66 @@ func FindConfigurationFile(configFile string) (string, error) {
 	return "", fmt.Errorf("configuration file %s was not found in the current directory nor any of these locations: %s", displayFile, strings.Join(locations, ", "))
 }
 
+// FindConfigurationIncludes returns the configuration fragments to merge into the configuration file.
+// The fragments are searched in a drop-in directory named after the configuration file ("profiles.d"),
+// next to the configuration file and in the XDG configuration directories.
+// Files are returned in lexical order of their file name.
+func FindConfigurationIncludes(configFile string) []string {
+	baseName := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile)) + dropInDirectorySuffix
+	directories := []string{filepath.Join(filepath.Dir(configFile), baseName)}
+	directories = append(directories, filepath.Join(xdg.ConfigHome, XDGAppName, baseName))
+	for _, configDir := range xdg.ConfigDirs {
+		directories = append(directories, filepath.Join(configDir, XDGAppName, baseName))
+	}
+
+	visited := make([]os.FileInfo, 0, len(directories))
+	fragments := make([]string, 0)
+	for _, directory := range directories {
+		info, err := os.Stat(directory)
+		if err != nil || !info.IsDir() || containsSameFile(visited, info) {
+			continue
+		}
+		visited = append(visited, info)
+		fragments = append(fragments, findConfigurationFragments(directory)...)
+	}
+	// stable sort keeps the order of the directories for fragments with the same name
+	sort.SliceStable(fragments, func(i, j int) bool {
+		return filepath.Base(fragments[i]) < filepath.Base(fragments[j])
+	})
+	return fragments
+}
+
+// findConfigurationFragments returns all the configuration files from the directory
+func findConfigurationFragments(directory string) []string {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil
+	}
+	fragments := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		extension := strings.TrimPrefix(filepath.Ext(file.Name()), ".")
+		for _, configurationExtension := range configurationExtensions {
+			if extension == configurationExtension {
+				fragments = append(fragments, filepath.Join(directory, file.Name()))
+				break
+			}
+		}
+	}
+	return fragments
+}
+
+func containsSameFile(files []os.FileInfo, file os.FileInfo) bool {
+	for _, item := range files {
+		if os.SameFile(item, file) {
+			return true
+		}
+	}
+	return false
+}
+
 func findConfigurationFileWithExtension(configFile string) string {
 	// 1. Simple case: current folder (or rooted path)
 	if fileExists(configFile) {
@@ -1,6 +1,7 @@
 package filesearch
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -203,3 +204,26 @@ func TestFindConfigurationFile(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+func TestFindConfigurationIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resticprofile-dropin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dropIn := filepath.Join(dir, "profiles.d")
+	require.NoError(t, os.MkdirAll(filepath.Join(dropIn, "subdir.toml"), 0700))
+	for _, name := range []string{"20-second.yaml", "10-first.toml", "30-third.json", "readme.txt", ".hidden.toml"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dropIn, name), []byte{}, 0600))
+	}
+
+	fragments := FindConfigurationIncludes(filepath.Join(dir, "profiles.toml"))
+	assert.Equal(t, []string{
+		filepath.Join(dropIn, "10-first.toml"),
+		filepath.Join(dropIn, "20-second.yaml"),
+		filepath.Join(dropIn, "30-third.json"),
+	}, fragments)
+
+	// drop-in directory is named after the configuration file
+	fragments = FindConfigurationIncludes(filepath.Join(dir, "other.toml"))
+	assert.Empty(t, fragments)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/lock"
+	"github.com/creativeprojects/resticprofile/term"
+)
+
+// configMutex prevents the profiles of a group running in parallel from loading the configuration at the same time
+var configMutex sync.Mutex
+
+// groupProfileRunner runs one profile of a group, sending the output of the commands to stdout and stderr (nil for the terminal)
+type groupProfileRunner func(profileName string, stdout, stderr io.Writer) error
+
+// groupResult is the result of one profile of a group
+type groupResult struct {
+	profile  string
+	started  bool
+	err      error
+	duration time.Duration
+}
+
+// runGroup runs all the profiles of a group, one after the other or in parallel,
+// and displays a summary of the results at the end
+func runGroup(
+	c *config.Config,
+	global *config.Global,
+	flags commandLineFlags,
+	groupName string,
+	resticBinary string,
+	resticArguments []string,
+	resticCommand string,
+) error {
+	group, err := c.GetGroup(groupName)
+	if err != nil {
+		return fmt.Errorf("cannot load group '%s': %w", groupName, err)
+	}
+	// the options of the group apply to the profiles of the nested groups
+	profiles, err := c.GetProfileGroup(groupName)
+	if err != nil {
+		return fmt.Errorf("cannot load group '%s': %w", groupName, err)
+	}
+	if len(profiles) == 0 {
+		clog.Warningf("group '%s' has no profile", groupName)
+		return nil
+	}
+	expanded := *group
+	expanded.Profiles = profiles
+	return runGroupDefinition(c, global, flags, groupName, &expanded, resticBinary, resticArguments, resticCommand)
+}
+
+// runSelectedProfiles runs the profiles matching the selector (wildcard or label) like the profiles of a group
+func runSelectedProfiles(
+	c *config.Config,
+	global *config.Global,
+	flags commandLineFlags,
+	selector string,
+	resticBinary string,
+	resticArguments []string,
+	resticCommand string,
+) error {
+	profiles, err := c.SelectProfiles(selector)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("no profile matching '%s'", selector)
+	}
+	clog.Infof("profiles matching '%s': %s", selector, strings.Join(profiles, ", "))
+	group := &config.Group{Profiles: profiles}
+	return runGroupDefinition(c, global, flags, selector, group, resticBinary, resticArguments, resticCommand)
+}
+
+// runGroupDefinition runs the profiles of the group (nested groups already expanded) within the lock of the group
+func runGroupDefinition(
+	c *config.Config,
+	global *config.Global,
+	flags commandLineFlags,
+	groupName string,
+	group *config.Group,
+	resticBinary string,
+	resticArguments []string,
+	resticCommand string,
+) error {
+	group.SetRootPath(filepath.Dir(c.GetConfigFile()))
+
+	var groupLock *lock.Lock
+	if group.Lock != "" {
+		groupLock = lock.NewLock(group.Lock)
+	}
+	// Catch CTR-C keypress, or other signal sent by a service manager (systemd) while waiting for the lock
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGABRT)
+	defer signal.Stop(sigChan)
+
+	return lockRun(groupLock, false, group.LockWait, sigChan, func(setPID lock.SetPID) error {
+		results := runGroupProfiles(groupName, group, func(profileName string, stdout, stderr io.Writer) error {
+			return runProfile(c, global, flags, profileName, resticBinary, resticArguments, resticCommand, stdout, stderr)
+		})
+		if !flags.quiet {
+			displayGroupResults(term.GetOutput(), groupName, results)
+		}
+		return getGroupError(groupName, results)
+	})
+}
+
+// runGroupProfiles runs the profiles of the group and returns their results, in the order of the group.
+// The profiles not started because of a previous failure are left with started = false
+func runGroupProfiles(groupName string, group *config.Group, run groupProfileRunner) []groupResult {
+	results := make([]groupResult, len(group.Profiles))
+	for i, profileName := range group.Profiles {
+		results[i].profile = profileName
+	}
+
+	if group.Parallel <= 1 {
+		for i, profileName := range group.Profiles {
+			clog.Debugf("[%d/%d] starting profile '%s' from group '%s'", i+1, len(group.Profiles), profileName, groupName)
+			results[i] = runGroupProfile(profileName, nil, nil, run)
+			if results[i].err != nil && !group.ContinueOnError {
+				break
+			}
+		}
+		return results
+	}
+
+	// a slot is taken from the semaphore by each profile running
+	semaphore := make(chan struct{}, group.Parallel)
+	failed := false
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i, profileName := range group.Profiles {
+		semaphore <- struct{}{}
+		mutex.Lock()
+		stop := failed && !group.ContinueOnError
+		mutex.Unlock()
+		if stop {
+			<-semaphore
+			break
+		}
+		clog.Debugf("[%d/%d] starting profile '%s' from group '%s'", i+1, len(group.Profiles), profileName, groupName)
+		wg.Add(1)
+		go func(i int, profileName string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			// the outputs of the profiles running at the same time are told apart by the profile name
+			prefix := fmt.Sprintf("[%s] ", profileName)
+			stdout := term.NewPrefixWriter(term.GetOutput(), prefix)
+			stderr := term.NewPrefixWriter(term.GetErrorOutput(), prefix)
+			result := runGroupProfile(profileName, stdout, stderr, run)
+			_ = stdout.Flush()
+			_ = stderr.Flush()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[i] = result
+			if result.err != nil {
+				failed = true
+			}
+		}(i, profileName)
+	}
+	wg.Wait()
+	return results
+}
+
+func runGroupProfile(profileName string, stdout, stderr io.Writer, run groupProfileRunner) groupResult {
+	start := time.Now()
+	err := run(profileName, stdout, stderr)
+	if err != nil {
+		clog.Error(err)
+	}
+	return groupResult{
+		profile:  profileName,
+		started:  true,
+		err:      err,
+		duration: time.Since(start),
+	}
+}
+
+// displayGroupResults displays the result and duration of each profile
+func displayGroupResults(output io.Writer, groupName string, results []groupResult) {
+	fmt.Fprintf(output, "\nGroup '%s':\n", groupName)
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\n", "PROFILE", "RESULT", "DURATION")
+	for _, result := range results {
+		switch {
+		case !result.started:
+			_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\n", result.profile, "skipped", "-")
+		case result.err != nil:
+			_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\n", result.profile, "failed", result.duration.Round(time.Second))
+		default:
+			_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\n", result.profile, "success", result.duration.Round(time.Second))
+		}
+	}
+	_ = w.Flush()
+	fmt.Fprintln(output, "")
+}
+
+// getGroupError returns an error when at least one profile of the group failed
+func getGroupError(groupName string, results []groupResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d profile(s) failed in group '%s'", failed, groupName)
+}
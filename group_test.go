@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/lock"
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/creativeprojects/resticprofile/term"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGroupRunner fails the profiles in the list, and records the maximum number of profiles running at the same time
+type fakeGroupRunner struct {
+	failing    map[string]bool
+	delay      time.Duration
+	mutex      sync.Mutex
+	running    int
+	maxRunning int
+	started    []string
+}
+
+func (r *fakeGroupRunner) run(profileName string, stdout, stderr io.Writer) error {
+	r.mutex.Lock()
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+	r.started = append(r.started, profileName)
+	r.mutex.Unlock()
+
+	if stdout != nil {
+		fmt.Fprintf(stdout, "output of %s\n", profileName)
+	}
+	time.Sleep(r.delay)
+
+	r.mutex.Lock()
+	r.running--
+	r.mutex.Unlock()
+	if r.failing[profileName] {
+		return fmt.Errorf("profile '%s' failed", profileName)
+	}
+	return nil
+}
+
+func TestRunGroupProfiles(t *testing.T) {
+	testData := []struct {
+		name       string
+		group      config.Group
+		failing    map[string]bool
+		started    []string
+		maxRunning int
+		err        string
+	}{
+		{
+			name:       "sequential",
+			group:      config.Group{Profiles: []string{"first", "second", "third"}},
+			started:    []string{"first", "second", "third"},
+			maxRunning: 1,
+		},
+		{
+			name:       "stop on error",
+			group:      config.Group{Profiles: []string{"first", "second", "third"}},
+			failing:    map[string]bool{"second": true},
+			started:    []string{"first", "second"},
+			maxRunning: 1,
+			err:        "1 profile(s) failed in group 'group'",
+		},
+		{
+			name:       "continue on error",
+			group:      config.Group{Profiles: []string{"first", "second", "third"}, ContinueOnError: true},
+			failing:    map[string]bool{"first": true, "second": true},
+			started:    []string{"first", "second", "third"},
+			maxRunning: 1,
+			err:        "2 profile(s) failed in group 'group'",
+		},
+		{
+			name:       "parallel",
+			group:      config.Group{Profiles: []string{"first", "second", "third", "fourth"}, Parallel: 2},
+			started:    []string{"first", "second", "third", "fourth"},
+			maxRunning: 2,
+		},
+		{
+			name:       "parallel with error",
+			group:      config.Group{Profiles: []string{"first", "second", "third", "fourth"}, Parallel: 2, ContinueOnError: true},
+			failing:    map[string]bool{"third": true},
+			started:    []string{"first", "second", "third", "fourth"},
+			maxRunning: 2,
+			err:        "1 profile(s) failed in group 'group'",
+		},
+	}
+
+	for _, testItem := range testData {
+		testItem := testItem
+		t.Run(testItem.name, func(t *testing.T) {
+			runner := &fakeGroupRunner{failing: testItem.failing, delay: 50 * time.Millisecond}
+			results := runGroupProfiles("group", &testItem.group, runner.run)
+
+			require.Len(t, results, len(testItem.group.Profiles))
+			assert.ElementsMatch(t, testItem.started, runner.started)
+			assert.Equal(t, testItem.maxRunning, runner.maxRunning)
+			for i, result := range results {
+				assert.Equal(t, testItem.group.Profiles[i], result.profile)
+				assert.Equal(t, testItem.failing[result.profile], result.err != nil)
+			}
+			err := getGroupError("group", results)
+			if testItem.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, testItem.err)
+			}
+		})
+	}
+}
+
+func TestRunGroupProfilesInParallelStopsOnError(t *testing.T) {
+	runner := &fakeGroupRunner{failing: map[string]bool{"first": true}}
+	slow := func(profileName string, stdout, stderr io.Writer) error {
+		if profileName == "second" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return runner.run(profileName, stdout, stderr)
+	}
+	group := &config.Group{Profiles: []string{"first", "second", "third", "fourth"}, Parallel: 2}
+	results := runGroupProfiles("group", group, slow)
+
+	// the second profile was already running when the first one failed
+	assert.ElementsMatch(t, []string{"first", "second"}, runner.started)
+	assert.True(t, results[0].started)
+	assert.Error(t, results[0].err)
+	assert.True(t, results[1].started)
+	assert.NoError(t, results[1].err)
+	assert.False(t, results[2].started)
+	assert.False(t, results[3].started)
+}
+
+func TestRunGroupProfilesInParallelShareStatusFile(t *testing.T) {
+	output := &bytes.Buffer{}
+	term.SetOutput(output)
+	defer term.SetOutput(os.Stdout)
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestRunGroupProfilesInParallelShareStatusFile", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+
+	run := func(profileName string, stdout, stderr io.Writer) error {
+		profile := config.NewProfile(nil, profileName)
+		profile.StatusFile = statusFile
+		profile.Backup = &config.BackupSection{}
+		wrapper := newResticWrapper("echo", false, false, profile, "backup", nil, nil)
+		wrapper.stdout, wrapper.stderr = stdout, stderr
+		return wrapper.runProfile()
+	}
+	profiles := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	group := &config.Group{Profiles: profiles, Parallel: len(profiles)}
+	runGroupProfiles("group", group, run)
+
+	// no profile is missing from the status file
+	profileStatus := status.NewStatus(statusFile).Load()
+	assert.Len(t, profileStatus.Profiles, len(profiles))
+	for _, profileName := range profiles {
+		assert.NotNil(t, profileStatus.Profile(profileName).Backup, profileName)
+	}
+}
+
+func TestRunGroupProfilesOutputPrefix(t *testing.T) {
+	output := &bytes.Buffer{}
+	term.SetOutput(output)
+	defer term.SetOutput(os.Stdout)
+
+	runner := &fakeGroupRunner{}
+	group := &config.Group{Profiles: []string{"first", "second"}, Parallel: 2}
+	runGroupProfiles("group", group, runner.run)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.ElementsMatch(t, []string{"[first] output of first", "[second] output of second"}, lines)
+}
+
+func TestRunNestedGroupWithLock(t *testing.T) {
+	output := &bytes.Buffer{}
+	term.SetOutput(output)
+	defer term.SetOutput(os.Stdout)
+
+	lockfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.lock", "TestRunNestedGroupWithLock", time.Now().UnixNano(), os.Getpid()))
+	testConfig := `
+[groups]
+nested = ["second", "first"]
+
+[groups.all]
+profiles = ["first", "nested"]
+lock = "` + lockfile + `"
+
+[first]
+[second]
+`
+	c, err := config.Load(bytes.NewBufferString(testConfig), "toml")
+	require.NoError(t, err)
+	global, err := c.GetGlobalSection()
+	require.NoError(t, err)
+	flags := commandLineFlags{dryRun: true}
+
+	err = runGroup(c, global, flags, "all", "restic", nil, "snapshots")
+	require.NoError(t, err)
+	assert.Contains(t, output.String(), "Group 'all':")
+	assert.Regexp(t, `(?s)first +success.*second +success`, output.String())
+	assert.NoFileExists(t, lockfile)
+
+	otherLock := lock.NewLock(lockfile)
+	require.True(t, otherLock.TryAcquire())
+	defer otherLock.Release()
+
+	err = runGroup(c, global, flags, "all", "restic", nil, "snapshots")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "another process is already running")
+}
+
+func TestRunSelectedProfiles(t *testing.T) {
+	output := &bytes.Buffer{}
+	term.SetOutput(output)
+	defer term.SetOutput(os.Stdout)
+
+	testConfig := `
+[home-documents]
+labels = ["nightly"]
+[home-photos]
+repository = "/photos"
+[server]
+labels = ["nightly"]
+`
+	c, err := config.Load(bytes.NewBufferString(testConfig), "toml")
+	require.NoError(t, err)
+	global, err := c.GetGlobalSection()
+	require.NoError(t, err)
+	flags := commandLineFlags{dryRun: true}
+
+	err = runSelectedProfiles(c, global, flags, "home-*", "restic", nil, "snapshots")
+	require.NoError(t, err)
+	assert.Contains(t, output.String(), "Group 'home-*':")
+	assert.Regexp(t, `(?s)home-documents +success.*home-photos +success`, output.String())
+	assert.NotContains(t, output.String(), "server")
+
+	output.Reset()
+	err = runSelectedProfiles(c, global, flags, "label:nightly", "restic", nil, "snapshots")
+	require.NoError(t, err)
+	assert.Regexp(t, `(?s)home-documents +success.*server +success`, output.String())
+	assert.NotContains(t, output.String(), "home-photos")
+
+	err = runSelectedProfiles(c, global, flags, "label:weekly", "restic", nil, "snapshots")
+	assert.EqualError(t, err, "no profile matching 'label:weekly'")
+}
+
+func TestDisplayGroupResults(t *testing.T) {
+	output := &bytes.Buffer{}
+	displayGroupResults(output, "full", []groupResult{
+		{profile: "root", started: true, duration: 62 * time.Second},
+		{profile: "src", started: true, err: errors.New("failed"), duration: 3200 * time.Millisecond},
+		{profile: "dev"},
+	})
+	assert.Equal(t, `
+Group 'full':
+  PROFILE  RESULT   DURATION
+  root     success  1m2s
+  src      failed   3s
+  dev      skipped  -
+
+`, output.String())
+}
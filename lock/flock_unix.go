@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package lock
+
+import (
+	"os"
+	"syscall"
+)
+
+// the lockfile can be deleted while it's still open: it's removed before releasing the lock,
+// so no other process can lock a file which is about to disappear
+const removeWhileOpen = true
+
+// lockFile puts an exclusive advisory lock on the file, or returns an error if another process owns the lock
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package lock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// windows cannot delete a file still open
+const removeWhileOpen = false
+
+// lockFile puts an exclusive lock on the file, or returns an error if another process owns the lock.
+// The lock is on a byte far away from the content, so other processes can still read who owns the lock
+func lockFile(file *os.File) error {
+	overlapped := &windows.Overlapped{
+		Offset:     0xFFFFFFFE,
+		OffsetHigh: 0x7FFFFFFF,
+	}
+	return windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+}
@@ -21,6 +21,7 @@ type Lock struct {
 	Lockfile string
 	file     *os.File
 	locked   bool
+	kernel   bool
 }
 
 // NewLock creates a new lock
@@ -31,6 +32,16 @@ func NewLock(filename string) *Lock {
 	}
 }
 
+// NewFlock creates a new lock using an advisory lock from the kernel on the lockfile (flock on unixes, LockFileEx on Windows).
+// The kernel releases the lock when the process dies: a lockfile left behind after a crash doesn't prevent a new lock
+func NewFlock(filename string) *Lock {
+	return &Lock{
+		Lockfile: filename,
+		locked:   false,
+		kernel:   true,
+	}
+}
+
 // TryAcquire returns true if the lock was successfully set. It returns false if a lock already exists
 func (l *Lock) TryAcquire() bool {
 	return l.lock()
@@ -46,6 +57,10 @@ func (l *Lock) ForceAcquire() bool {
 	if l.lock() {
 		return true
 	}
+	if l.kernel {
+		// the kernel lock is released when the process dies: the owner is still running
+		return false
+	}
 	pid, err := l.LastPID()
 	if err != nil {
 		return false
@@ -65,12 +80,38 @@ func (l *Lock) ForceAcquire() bool {
 
 // Release the lockfile
 func (l *Lock) Release() {
+	if l.kernel {
+		l.releaseKernelLock()
+		return
+	}
 	if l.file != nil {
 		_ = l.file.Close()
 	}
 	l.unlock()
 }
 
+// IsKernelLock returns true when the lock is an advisory lock from the kernel (see NewFlock)
+func (l *Lock) IsKernelLock() bool {
+	return l.kernel
+}
+
+// Held returns true when the lockfile is owned by a running process.
+// A kernel lock (see NewFlock) is tested without waiting: the lockfile of a process which died is not held.
+// A simple lockfile is held as long as it exists
+func (l *Lock) Held() bool {
+	if !l.kernel {
+		_, err := os.Stat(l.Lockfile)
+		return err == nil
+	}
+	file, err := os.Open(l.Lockfile)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	// closing the file releases the lock we may have just taken
+	return lockFile(file) != nil
+}
+
 // Who owns the lock?
 func (l *Lock) Who() (string, error) {
 	buffer, err := ioutil.ReadFile(l.Lockfile)
@@ -82,6 +123,35 @@ func (l *Lock) Who() (string, error) {
 	return contents[0], nil
 }
 
+// Info is the content of a lockfile
+type Info struct {
+	Who     string    // user, date and host owning the lock
+	Started time.Time // zero value if the date cannot be read
+	PIDs    []int32   // child processes, in the order they were started
+}
+
+// Info reads the content of the lockfile: who owns the lock, since when, and the PIDs of the child processes
+func (l *Lock) Info() (Info, error) {
+	buffer, err := ioutil.ReadFile(l.Lockfile)
+	if err != nil {
+		return Info{}, err
+	}
+	// first line should be "who" owns the lock, any subsequent line will contain the restic PIDs
+	contents := strings.Split(string(buffer), "\n")
+	info := Info{
+		Who:     contents[0],
+		Started: parseStarted(contents[0]),
+		PIDs:    make([]int32, 0, len(contents)-1),
+	}
+	for _, line := range contents[1:] {
+		pid, err := strconv.ParseInt(line, 10, 32)
+		if err == nil {
+			info.PIDs = append(info.PIDs, int32(pid))
+		}
+	}
+	return info, nil
+}
+
 // SetPID writes down the PID in the lock file.
 // You can run the method as many times as you want when the PID changes
 func (l *Lock) SetPID(pid int) {
@@ -120,9 +190,15 @@ func (l *Lock) LastPID() (int32, error) {
 func (l *Lock) lock() bool {
 	var err error
 
-	l.file, err = os.OpenFile(l.Lockfile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
-	if err != nil {
-		return false
+	if l.kernel {
+		if !l.kernelLock() {
+			return false
+		}
+	} else {
+		l.file, err = os.OpenFile(l.Lockfile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return false
+		}
 	}
 	// Leave the lock file open
 
@@ -151,3 +227,78 @@ func (l *Lock) unlock() {
 	_ = os.Remove(l.Lockfile)
 	l.locked = false
 }
+
+// kernelLock opens the lockfile (creating it if needed) and locks it
+func (l *Lock) kernelLock() bool {
+	// the previous owner can delete the lockfile after we opened it: in that case we try again on the new file
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(l.Lockfile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return false
+		}
+		err = lockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return false
+		}
+		if !isSameFile(file, l.Lockfile) {
+			_ = file.Close()
+			continue
+		}
+		// the lockfile may still contain the information from a process which died
+		err = file.Truncate(0)
+		if err != nil {
+			_ = file.Close()
+			return false
+		}
+		l.file = file
+		return true
+	}
+	return false
+}
+
+// releaseKernelLock deletes the lockfile and releases the lock
+func (l *Lock) releaseKernelLock() {
+	if !l.locked {
+		if l.file != nil {
+			_ = l.file.Close()
+		}
+		return
+	}
+	if removeWhileOpen {
+		_ = os.Remove(l.Lockfile)
+	}
+	// closing the file releases the lock
+	_ = l.file.Close()
+	if !removeWhileOpen {
+		_ = os.Remove(l.Lockfile)
+	}
+	l.locked = false
+}
+
+// parseStarted returns the date from the first line of the lockfile: "user on date from host"
+func parseStarted(who string) time.Time {
+	start := strings.Index(who, " on ")
+	end := strings.LastIndex(who, " from ")
+	if start < 0 || end < start+4 {
+		return time.Time{}
+	}
+	started, err := time.ParseInLocation(time.RFC850, who[start+4:end], time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return started
+}
+
+// isSameFile returns true if the open file is still the file at this path
+func isSameFile(file *os.File, filename string) bool {
+	openInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	pathInfo, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(openInfo, pathInfo)
+}
@@ -110,6 +110,39 @@ func TestSetMorePID(t *testing.T) {
 	assert.Equal(t, int32(13), pid)
 }
 
+func TestInfo(t *testing.T) {
+	tempfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.tmp", "TestInfo", time.Now().UnixNano(), os.Getpid()))
+	t.Log("Using temporary file", tempfile)
+	lock := NewLock(tempfile)
+	defer lock.Release()
+	require.True(t, lock.TryAcquire())
+	lock.SetPID(11)
+	lock.SetPID(12)
+
+	other := NewLock(tempfile)
+	info, err := other.Info()
+	require.NoError(t, err)
+
+	who, err := other.Who()
+	require.NoError(t, err)
+	assert.Equal(t, who, info.Who)
+	assert.Equal(t, []int32{11, 12}, info.PIDs)
+	assert.WithinDuration(t, time.Now(), info.Started, 2*time.Second)
+}
+
+func TestInfoWithoutLockfile(t *testing.T) {
+	tempfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.tmp", "TestInfoWithoutLockfile", time.Now().UnixNano(), os.Getpid()))
+	_, err := NewLock(tempfile).Info()
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseStarted(t *testing.T) {
+	started := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	assert.True(t, started.Equal(parseStarted("user on "+started.Format(time.RFC850)+" from host")))
+	assert.True(t, parseStarted("").IsZero())
+	assert.True(t, parseStarted("user on yesterday from host").IsZero())
+}
+
 // This test is using the shell package. This is just a convenient wrapper around cmd.exe and sh
 func TestProcessFinished(t *testing.T) {
 	childPID := 0
@@ -341,3 +374,79 @@ func TestLockIsRemovedAfterInterruptSignalInsideShell(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "lock acquired\ntask interrupted\nlock released\n", buffer.String())
 }
+
+func TestLockIsHeldWhileTheFileExists(t *testing.T) {
+	tempfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.tmp", "TestLockIsHeldWhileTheFileExists", time.Now().UnixNano(), os.Getpid()))
+	lock := NewLock(tempfile)
+	defer lock.Release()
+	other := NewLock(tempfile)
+
+	assert.False(t, other.Held())
+	assert.True(t, lock.TryAcquire())
+	assert.True(t, other.Held())
+	lock.Release()
+	assert.False(t, other.Held())
+}
+
+func TestFlockIsNotAvailable(t *testing.T) {
+	tempfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.tmp", "TestFlockIsNotAvailable", time.Now().UnixNano(), os.Getpid()))
+	t.Log("Using temporary file", tempfile)
+	lock := NewFlock(tempfile)
+	defer lock.Release()
+
+	assert.True(t, lock.TryAcquire())
+	assert.True(t, lock.HasLocked())
+	lock.SetPID(11)
+
+	other := NewFlock(tempfile)
+	defer other.Release()
+	assert.True(t, other.Held())
+	assert.False(t, other.TryAcquire())
+	assert.False(t, other.ForceAcquire())
+	assert.False(t, other.HasLocked())
+	// testing the lock doesn't release it
+	assert.True(t, other.Held())
+
+	who, err := other.Who()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[\.\-\\\w]+ on \w+, \d+-\w+-\d+ \d+:\d+:\d+ \w* from [\.\-\w]+$`), who)
+	pid, err := other.LastPID()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(11), pid)
+
+	lock.Release()
+	assert.NoFileExists(t, tempfile)
+	assert.False(t, other.Held())
+	assert.True(t, other.TryAcquire())
+	assert.True(t, other.HasLocked())
+}
+
+func TestFlockIsReleasedAfterCrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the helper binary is not compiled in Windows")
+	}
+	lockfile := "TestFlockIsReleasedAfterCrash.lock"
+	// make sure there's no remaining lockfile from a failed test
+	_ = os.Remove(lockfile)
+	defer os.Remove(lockfile)
+
+	buffer := &bytes.Buffer{}
+	cmd := exec.Command(helperBinary, "-flock", "-crash", "-lock", lockfile)
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+
+	err := cmd.Run()
+	require.NoError(t, err)
+	assert.Equal(t, "lock acquired\n", buffer.String())
+	// the lockfile was left behind
+	require.FileExists(t, lockfile)
+
+	lock := NewFlock(lockfile)
+	defer lock.Release()
+	assert.False(t, lock.Held())
+	assert.True(t, lock.TryAcquire())
+
+	// the information from the previous owner is gone
+	_, err = lock.LastPID()
+	assert.Error(t, err)
+}
@@ -18,10 +18,19 @@ func main() {
 	lockfile := ""
 	flag.IntVar(&wait, "wait", 1000, "Wait n milliseconds before unlocking")
 	flag.StringVar(&lockfile, "lock", "test.lock", "Name of the lock file")
+	kernel := flag.Bool("flock", false, "Use a lock from the kernel")
+	crash := flag.Bool("crash", false, "Exit without releasing the lock")
 	flag.Parse()
 
 	l := lock.NewLock(lockfile)
+	if *kernel {
+		l = lock.NewFlock(lockfile)
+	}
 	if l.TryAcquire() {
+		if *crash {
+			fmt.Println("lock acquired")
+			os.Exit(0)
+		}
 		defer func() {
 			l.Release()
 			fmt.Println("lock released")
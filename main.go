@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"os/signal"
@@ -18,6 +19,7 @@ import (
 	"github.com/creativeprojects/resticprofile/filesearch"
 	"github.com/creativeprojects/resticprofile/priority"
 	"github.com/creativeprojects/resticprofile/remote"
+	"github.com/creativeprojects/resticprofile/shell"
 	"github.com/creativeprojects/resticprofile/term"
 	"github.com/mackerelio/go-osstat/memory"
 )
@@ -151,6 +153,14 @@ func main() {
 		return
 	}
 
+	// configuration fragments from the drop-in directories (profiles.d)
+	err = c.AddIncludes(filesearch.FindConfigurationIncludes(configFile))
+	if err != nil {
+		clog.Errorf("cannot load configuration file: %v", err)
+		exitCode = 1
+		return
+	}
+
 	global, err := c.GetGlobalSection()
 	if err != nil {
 		clog.Errorf("cannot load global configuration: %v", err)
@@ -182,14 +192,6 @@ func main() {
 		}
 	}
 
-	resticBinary, err := filesearch.FindResticBinary(global.ResticBinary)
-	if err != nil {
-		clog.Error("cannot find restic: ", err)
-		clog.Warning("you can specify the path of the restic binary in the global section of the configuration file (restic-binary)")
-		exitCode = 1
-		return
-	}
-
 	// The remaining arguments are going to be sent to the restic command line
 	resticArguments := flags.resticArgs
 	resticCommand := global.DefaultCommand
@@ -209,13 +211,22 @@ func main() {
 		return
 	}
 
+	// the restic binary is not needed by the own commands above
+	resticBinary, err := filesearch.FindResticBinary(global.ResticBinary)
+	if err != nil {
+		clog.Error("cannot find restic: ", err)
+		clog.Warning("you can specify the path of the restic binary in the global section of the configuration file (restic-binary)")
+		exitCode = 1
+		return
+	}
+
 	if c.HasProfile(flags.name) {
 		// if running as a systemd timer
 		notifyStart()
 		defer notifyStop()
 
 		// Single profile run
-		err = runProfile(c, global, flags, flags.name, resticBinary, resticArguments, resticCommand)
+		err = runProfile(c, global, flags, flags.name, resticBinary, resticArguments, resticCommand, nil, nil)
 		if err != nil {
 			clog.Error(err)
 			exitCode = 1
@@ -223,25 +234,29 @@ func main() {
 		}
 
 	} else if c.HasProfileGroup(flags.name) {
+		// if running as a systemd timer
+		notifyStart()
+		defer notifyStop()
+
 		// Group run
-		group, err := c.GetProfileGroup(flags.name)
+		err = runGroup(c, global, flags, flags.name, resticBinary, resticArguments, resticCommand)
 		if err != nil {
-			clog.Errorf("cannot load group '%s': %v", flags.name, err)
+			clog.Error(err)
+			exitCode = 1
+			return
 		}
-		if len(group) > 0 {
-			// if running as a systemd timer
-			notifyStart()
-			defer notifyStop()
-
-			for i, profileName := range group {
-				clog.Debugf("[%d/%d] starting profile '%s' from group '%s'", i+1, len(group), profileName, flags.name)
-				err = runProfile(c, global, flags, profileName, resticBinary, resticArguments, resticCommand)
-				if err != nil {
-					clog.Error(err)
-					exitCode = 1
-					return
-				}
-			}
+
+	} else if config.IsProfileSelector(flags.name) {
+		// if running as a systemd timer
+		notifyStart()
+		defer notifyStop()
+
+		// Profiles selected by wildcard or label, run as a group
+		err = runSelectedProfiles(c, global, flags, flags.name, resticBinary, resticArguments, resticCommand)
+		if err != nil {
+			clog.Error(err)
+			exitCode = 1
+			return
 		}
 
 	} else {
@@ -288,10 +303,14 @@ func runProfile(
 	resticBinary string,
 	resticArguments []string,
 	resticCommand string,
+	stdout, stderr io.Writer,
 ) error {
 	var err error
 
+	// the profiles of a group can be loaded at the same time
+	configMutex.Lock()
 	profile, err := c.GetProfile(profileName)
+	configMutex.Unlock()
 	if err != nil {
 		clog.Warning(err)
 	}
@@ -325,6 +344,10 @@ func runProfile(
 		clog.Debugf("files in configuration are relative to '%s'", rootPath)
 	}
 	profile.SetRootPath(rootPath)
+	if global.ResticShell != shell.NoShell {
+		// the paths are interpreted by the shell running restic
+		profile.EscapeShellPaths()
+	}
 
 	// Specific case for the "host" flag where an empty value should be replaced by the hostname
 	hostname := "none"
@@ -349,6 +372,9 @@ func runProfile(
 		resticArguments,
 		sigChan,
 	)
+	wrapper.resticShell = global.ResticShell
+	wrapper.stdout = stdout
+	wrapper.stderr = stderr
 	err = wrapper.runProfile()
 	if err != nil {
 		return err
@@ -0,0 +1,257 @@
+package prom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/spf13/afero"
+)
+
+// Names of the metrics
+const (
+	MetricSuccess         = "resticprofile_backup_success"
+	MetricDuration        = "resticprofile_backup_duration_seconds"
+	MetricExitCode        = "resticprofile_backup_exit_code"
+	MetricLastRun         = "resticprofile_backup_last_run_timestamp"
+	MetricAddedBytes      = "resticprofile_backup_added_bytes"
+	MetricProcessedBytes  = "resticprofile_backup_processed_bytes"
+	MetricFilesNew        = "resticprofile_backup_files_new"
+	MetricFilesChanged    = "resticprofile_backup_files_changed"
+	MetricFilesUnmodified = "resticprofile_backup_files_unmodified"
+)
+
+// metricHelp is the description of each metric, in the order they're written
+var metricHelp = []struct {
+	name string
+	help string
+}{
+	{MetricSuccess, "Whether the last run of the command was successful (1) or not (0)."},
+	{MetricDuration, "Duration of the last run of the command, in seconds."},
+	{MetricExitCode, "Exit code of the last run of the command."},
+	{MetricLastRun, "Time of the end of the last run of the command, in seconds since the epoch."},
+	{MetricAddedBytes, "Data added to the repository by the last backup, in bytes."},
+	{MetricProcessedBytes, "Total size of the files processed by the last backup, in bytes."},
+	{MetricFilesNew, "Number of new files in the last backup."},
+	{MetricFilesChanged, "Number of changed files in the last backup."},
+	{MetricFilesUnmodified, "Number of unmodified files in the last backup."},
+}
+
+// fileMutex prevents the profiles running in parallel from updating the same file at the same time
+var fileMutex sync.Mutex
+
+// sample is the value of a metric for a set of labels
+type sample struct {
+	name   string
+	labels string // labels already formatted like {profile="name",command="backup"}
+	value  float64
+}
+
+// Metrics is a list of gauges in the text format of prometheus
+type Metrics struct {
+	samples []sample
+}
+
+// NewMetrics creates an empty list of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		samples: make([]sample, 0),
+	}
+}
+
+// AddCommandStatus adds the gauges describing the last run of a command of the profile.
+// The statistics of a backup are only added when its summary is available
+func (m *Metrics) AddCommandStatus(profileName, command string, commandStatus *status.CommandStatus) *Metrics {
+	return m.addCommandStatus(formatLabels("profile", profileName, "command", command), commandStatus)
+}
+
+// AddRepositoryCommandStatus adds the gauges describing the last run of a command on one of the repositories of the profile
+func (m *Metrics) AddRepositoryCommandStatus(profileName, repository, command string, commandStatus *status.CommandStatus) *Metrics {
+	return m.addCommandStatus(formatLabels("profile", profileName, "command", command, "repository", repository), commandStatus)
+}
+
+func (m *Metrics) addCommandStatus(labels string, commandStatus *status.CommandStatus) *Metrics {
+	success := 0.0
+	if commandStatus.Success {
+		success = 1.0
+	}
+	m.add(MetricSuccess, labels, success)
+	m.add(MetricDuration, labels, commandStatus.Duration)
+	m.add(MetricExitCode, labels, float64(commandStatus.ExitCode))
+	m.add(MetricLastRun, labels, float64(commandStatus.End.Unix()))
+	if summary := commandStatus.Summary; summary != nil {
+		m.add(MetricAddedBytes, labels, float64(summary.DataAdded))
+		m.add(MetricProcessedBytes, labels, float64(summary.TotalBytesProcessed))
+		m.add(MetricFilesNew, labels, float64(summary.FilesNew))
+		m.add(MetricFilesChanged, labels, float64(summary.FilesChanged))
+		m.add(MetricFilesUnmodified, labels, float64(summary.FilesUnmodified))
+	}
+	return m
+}
+
+// Len returns the number of samples
+func (m *Metrics) Len() int {
+	return len(m.samples)
+}
+
+func (m *Metrics) add(name, labels string, value float64) {
+	m.samples = append(m.samples, sample{name: name, labels: labels, value: value})
+}
+
+// merge adds the samples of the other metrics which are not replaced by a sample with the same labels
+func (m *Metrics) merge(other *Metrics) {
+	replaced := make(map[string]bool, len(m.samples))
+	for _, sample := range m.samples {
+		replaced[sample.labels] = true
+	}
+	for _, sample := range other.samples {
+		if !replaced[sample.labels] {
+			m.samples = append(m.samples, sample)
+		}
+	}
+}
+
+// Write sends the metrics in the text format of prometheus, grouped by metric name
+func (m *Metrics) Write(w io.Writer) error {
+	byName := make(map[string][]sample)
+	names := make([]string, 0)
+	for _, sample := range m.samples {
+		if _, found := byName[sample.name]; !found {
+			names = append(names, sample.name)
+		}
+		byName[sample.name] = append(byName[sample.name], sample)
+	}
+	// known metrics first, then the other metrics by name
+	ordered := make([]string, 0, len(names))
+	for _, metric := range metricHelp {
+		if _, found := byName[metric.name]; found {
+			ordered = append(ordered, metric.name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if getHelp(name) == "" {
+			ordered = append(ordered, name)
+		}
+	}
+
+	buffer := bufio.NewWriter(w)
+	for _, name := range ordered {
+		if help := getHelp(name); help != "" {
+			fmt.Fprintf(buffer, "# HELP %s %s\n", name, help)
+			fmt.Fprintf(buffer, "# TYPE %s gauge\n", name)
+		}
+		samples := byName[name]
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+		for _, sample := range samples {
+			fmt.Fprintf(buffer, "%s%s %s\n", sample.name, sample.labels, strconv.FormatFloat(sample.value, 'f', -1, 64))
+		}
+	}
+	return buffer.Flush()
+}
+
+// SaveTextfile writes the metrics in a file for the textfile collector of node_exporter.
+// The samples already in the file are kept unless they have the same labels as the new ones
+// (the metrics of the other profiles and commands are kept).
+// The file is replaced at once so the collector never reads a file half written
+func (m *Metrics) SaveTextfile(filename string) error {
+	return m.saveTextfile(afero.NewOsFs(), filename)
+}
+
+func (m *Metrics) saveTextfile(fs afero.Fs, filename string) error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	merged := &Metrics{samples: append([]sample{}, m.samples...)}
+	if existing, err := loadTextfile(fs, filename); err == nil {
+		merged.merge(existing)
+	}
+
+	dir := filepath.Dir(filename)
+	err := fs.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	file, err := afero.TempFile(fs, dir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempName := file.Name()
+	err = merged.Write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// the collector needs to read the file
+		err = fs.Chmod(tempName, 0644)
+	}
+	if err == nil {
+		err = fs.Rename(tempName, filename)
+	}
+	if err != nil {
+		_ = fs.Remove(tempName)
+		return err
+	}
+	return nil
+}
+
+// loadTextfile reads the samples of a file in the text format (the comments are ignored)
+func loadTextfile(fs afero.Fs, filename string) (*Metrics, error) {
+	file, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	metrics := NewMetrics()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.LastIndex(line, " ")
+		if separator < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[separator+1:], 64)
+		if err != nil {
+			continue
+		}
+		series := strings.TrimSpace(line[:separator])
+		name, labels := series, ""
+		if start := strings.Index(series, "{"); start > 0 {
+			name, labels = series[:start], series[start:]
+		}
+		metrics.add(name, labels, value)
+	}
+	return metrics, scanner.Err()
+}
+
+func getHelp(name string) string {
+	for _, metric := range metricHelp {
+		if metric.name == name {
+			return metric.help
+		}
+	}
+	return ""
+}
+
+// formatLabels formats the pairs of label names and values like {name="value",other="value"}
+func formatLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", pairs[i], escapeLabelValue(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
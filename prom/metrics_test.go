@@ -0,0 +1,151 @@
+package prom
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEnd = time.Date(2021, 3, 14, 2, 0, 0, 0, time.UTC)
+
+func TestWriteMetrics(t *testing.T) {
+	metrics := NewMetrics().
+		AddCommandStatus("home", "backup", &status.CommandStatus{
+			Success:  true,
+			End:      testEnd,
+			Duration: 83.5,
+			Summary: &status.BackupSummary{
+				FilesNew:            2,
+				FilesChanged:        1,
+				FilesUnmodified:     5,
+				DataAdded:           1572864,
+				TotalBytesProcessed: 3221225472,
+			},
+		}).
+		AddCommandStatus("home", "check", &status.CommandStatus{
+			Success:  false,
+			End:      testEnd,
+			Duration: 2,
+			ExitCode: 1,
+		})
+	assert.Equal(t, 13, metrics.Len())
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, metrics.Write(buffer))
+	assert.Equal(t, `# HELP resticprofile_backup_success Whether the last run of the command was successful (1) or not (0).
+# TYPE resticprofile_backup_success gauge
+resticprofile_backup_success{profile="home",command="backup"} 1
+resticprofile_backup_success{profile="home",command="check"} 0
+# HELP resticprofile_backup_duration_seconds Duration of the last run of the command, in seconds.
+# TYPE resticprofile_backup_duration_seconds gauge
+resticprofile_backup_duration_seconds{profile="home",command="backup"} 83.5
+resticprofile_backup_duration_seconds{profile="home",command="check"} 2
+# HELP resticprofile_backup_exit_code Exit code of the last run of the command.
+# TYPE resticprofile_backup_exit_code gauge
+resticprofile_backup_exit_code{profile="home",command="backup"} 0
+resticprofile_backup_exit_code{profile="home",command="check"} 1
+# HELP resticprofile_backup_last_run_timestamp Time of the end of the last run of the command, in seconds since the epoch.
+# TYPE resticprofile_backup_last_run_timestamp gauge
+resticprofile_backup_last_run_timestamp{profile="home",command="backup"} 1615687200
+resticprofile_backup_last_run_timestamp{profile="home",command="check"} 1615687200
+# HELP resticprofile_backup_added_bytes Data added to the repository by the last backup, in bytes.
+# TYPE resticprofile_backup_added_bytes gauge
+resticprofile_backup_added_bytes{profile="home",command="backup"} 1572864
+# HELP resticprofile_backup_processed_bytes Total size of the files processed by the last backup, in bytes.
+# TYPE resticprofile_backup_processed_bytes gauge
+resticprofile_backup_processed_bytes{profile="home",command="backup"} 3221225472
+# HELP resticprofile_backup_files_new Number of new files in the last backup.
+# TYPE resticprofile_backup_files_new gauge
+resticprofile_backup_files_new{profile="home",command="backup"} 2
+# HELP resticprofile_backup_files_changed Number of changed files in the last backup.
+# TYPE resticprofile_backup_files_changed gauge
+resticprofile_backup_files_changed{profile="home",command="backup"} 1
+# HELP resticprofile_backup_files_unmodified Number of unmodified files in the last backup.
+# TYPE resticprofile_backup_files_unmodified gauge
+resticprofile_backup_files_unmodified{profile="home",command="backup"} 5
+`, buffer.String())
+}
+
+func TestWriteRepositoryMetrics(t *testing.T) {
+	metrics := NewMetrics().
+		AddRepositoryCommandStatus("home", "local", "backup", &status.CommandStatus{
+			Success: true,
+			End:     testEnd,
+			Summary: &status.BackupSummary{DataAdded: 1024},
+		}).
+		AddCommandStatus("home", "backup", &status.CommandStatus{
+			Success: true,
+			End:     testEnd,
+			Summary: &status.BackupSummary{DataAdded: 1024},
+		})
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, metrics.Write(buffer))
+	assert.Contains(t, buffer.String(), `resticprofile_backup_success{profile="home",command="backup",repository="local"} 1`+"\n")
+	assert.Contains(t, buffer.String(), `resticprofile_backup_added_bytes{profile="home",command="backup",repository="local"} 1024`+"\n")
+	assert.Contains(t, buffer.String(), `resticprofile_backup_added_bytes{profile="home",command="backup"} 1024`+"\n")
+}
+
+func TestFormatLabels(t *testing.T) {
+	assert.Equal(t, `{profile="my \"home\"",command="back\\up\n"}`, formatLabels("profile", `my "home"`, "command", "back\\up\n"))
+}
+
+func TestSaveTextfileKeepsOtherSamples(t *testing.T) {
+	filename := "/metrics/resticprofile.prom"
+	fs := afero.NewMemMapFs()
+
+	backup := NewMetrics().AddCommandStatus("home", "backup", &status.CommandStatus{
+		Success: true,
+		End:     testEnd,
+		Summary: &status.BackupSummary{FilesNew: 10},
+	})
+	require.NoError(t, backup.saveTextfile(fs, filename))
+	other := NewMetrics().AddCommandStatus("other", "backup", &status.CommandStatus{Success: true, End: testEnd})
+	require.NoError(t, other.saveTextfile(fs, filename))
+
+	// a failed backup without summary replaces all the samples of the previous backup
+	failed := NewMetrics().AddCommandStatus("home", "backup", &status.CommandStatus{Success: false, End: testEnd, ExitCode: 1})
+	require.NoError(t, failed.saveTextfile(fs, filename))
+	check := NewMetrics().AddCommandStatus("home", "check", &status.CommandStatus{Success: true, End: testEnd})
+	require.NoError(t, check.saveTextfile(fs, filename))
+
+	content, err := afero.ReadFile(fs, filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="home",command="backup"} 0`)
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="home",command="check"} 1`)
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="other",command="backup"} 1`)
+	assert.NotContains(t, string(content), "resticprofile_backup_files_new")
+
+	loaded, err := loadTextfile(fs, filename)
+	require.NoError(t, err)
+	assert.Equal(t, 12, loaded.Len())
+
+	// no temporary file left behind
+	files, err := afero.ReadDir(fs, "/metrics")
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestSaveTextfile(t *testing.T) {
+	filename := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.prom", "TestSaveTextfile", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(filename)
+
+	metrics := NewMetrics().AddCommandStatus("home", "backup", &status.CommandStatus{Success: true, End: testEnd})
+	require.NoError(t, metrics.SaveTextfile(filename))
+
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.False(t, info.IsDir())
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="home",command="backup"} 1`)
+}
@@ -0,0 +1,75 @@
+package prom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Push sends the metrics to a prometheus Pushgateway: they replace all the metrics previously pushed with the same job and grouping labels.
+// The grouping labels are given as pairs of label names and values
+func (m *Metrics) Push(gatewayURL, job string, timeout time.Duration, grouping ...string) error {
+	if len(grouping)%2 != 0 {
+		return fmt.Errorf("missing value for grouping label '%s'", grouping[len(grouping)-1])
+	}
+	pushURL, err := getPushURL(gatewayURL, job, grouping...)
+	if err != nil {
+		return err
+	}
+	buffer := &bytes.Buffer{}
+	err = m.Write(buffer)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPut, pushURL, buffer)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("unexpected status %q from the pushgateway: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// getPushURL returns the URL of the group: /metrics/job/<job>/<label>/<value>...
+func getPushURL(gatewayURL, job string, grouping ...string) (string, error) {
+	parsed, err := url.Parse(gatewayURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid pushgateway URL: %w", err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("invalid pushgateway URL %q: expecting an absolute URL like http://localhost:9091", gatewayURL)
+	}
+	pushURL := strings.TrimSuffix(gatewayURL, "/") + "/metrics/" + encodeGroupingLabel("job", job)
+	for i := 0; i+1 < len(grouping); i += 2 {
+		pushURL += "/" + encodeGroupingLabel(grouping[i], grouping[i+1])
+	}
+	return pushURL, nil
+}
+
+// encodeGroupingLabel returns the label and its value as a path: a value containing a slash (or empty) is encoded in base64
+func encodeGroupingLabel(name, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+		if encoded == "" {
+			encoded = "="
+		}
+		return name + "@base64/" + encoded
+	}
+	return name + "/" + url.PathEscape(value)
+}
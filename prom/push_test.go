@@ -0,0 +1,76 @@
+package prom
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPushURL(t *testing.T) {
+	testData := []struct {
+		gatewayURL string
+		grouping   []string
+		expected   string
+	}{
+		{"http://localhost:9091", nil, "http://localhost:9091/metrics/job/resticprofile"},
+		{"http://localhost:9091/", []string{"profile", "home"}, "http://localhost:9091/metrics/job/resticprofile/profile/home"},
+		{"https://gateway/prefix", []string{"profile", "my home", "host", "laptop"}, "https://gateway/prefix/metrics/job/resticprofile/profile/my%20home/host/laptop"},
+		{"http://localhost:9091", []string{"profile", "a/b"}, "http://localhost:9091/metrics/job/resticprofile/profile@base64/YS9i"},
+		{"http://localhost:9091", []string{"host", ""}, "http://localhost:9091/metrics/job/resticprofile/host@base64/="},
+	}
+	for _, testItem := range testData {
+		pushURL, err := getPushURL(testItem.gatewayURL, "resticprofile", testItem.grouping...)
+		require.NoError(t, err)
+		assert.Equal(t, testItem.expected, pushURL)
+	}
+
+	_, err := getPushURL("localhost:9091", "resticprofile")
+	assert.Error(t, err)
+}
+
+func TestPush(t *testing.T) {
+	var method, path, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type")
+		content, _ := ioutil.ReadAll(r.Body)
+		body = string(content)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics().AddCommandStatus("home", "backup", &status.CommandStatus{Success: true, End: testEnd})
+	err := metrics.Push(server.URL, "resticprofile", time.Second, "profile", "home", "host", "laptop")
+	require.NoError(t, err)
+
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/resticprofile/profile/home/host/laptop", path)
+	assert.Contains(t, contentType, "text/plain")
+	assert.Contains(t, body, `resticprofile_backup_success{profile="home",command="backup"} 1`+"\n")
+}
+
+func TestPushErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow/metrics/job/resticprofile" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("inconsistent labels\n"))
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics().AddCommandStatus("home", "backup", &status.CommandStatus{Success: true, End: testEnd})
+	err := metrics.Push(server.URL, "resticprofile", time.Second)
+	assert.EqualError(t, err, `unexpected status "400 Bad Request" from the pushgateway: inconsistent labels`)
+
+	err = metrics.Push(server.URL+"/slow", "resticprofile", 50*time.Millisecond)
+	assert.Error(t, err)
+
+	err = metrics.Push(server.URL, "resticprofile", time.Second, "profile")
+	assert.EqualError(t, err, "missing value for grouping label 'profile'")
+}
@@ -0,0 +1,307 @@
+package restic
+
+import "sort"
+
+// OptionType is a bit field of the types of value accepted by a restic flag
+type OptionType int
+
+// Types of value accepted by a restic flag
+const (
+	TypeBool OptionType = 1 << iota
+	TypeInteger
+	TypeString
+	TypeList
+)
+
+// Option is a restic command line flag
+type Option struct {
+	Name        string
+	Type        OptionType
+	Description string
+}
+
+// Accept returns true if the option accepts this type of value
+func (o Option) Accept(valueType OptionType) bool {
+	accepted := o.Type
+	// a number can be used as a string, and a single value as a list
+	if accepted&TypeString != 0 {
+		accepted |= TypeInteger
+	}
+	if accepted&TypeList != 0 {
+		accepted |= TypeString | TypeInteger
+	}
+	return accepted&valueType != 0
+}
+
+var (
+	// globalOptions are available to all restic commands
+	globalOptions = []Option{
+		{"cacert", TypeString | TypeList, "file to load root certificates from"},
+		{"cache-dir", TypeString, "set the cache directory"},
+		{"cleanup-cache", TypeBool, "auto remove old cache directories"},
+		{"json", TypeBool, "set output mode to JSON for commands that support it"},
+		{"key-hint", TypeString, "key ID of key to try decrypting first"},
+		{"limit-download", TypeInteger, "limits downloads to a maximum rate in KiB/s"},
+		{"limit-upload", TypeInteger, "limits uploads to a maximum rate in KiB/s"},
+		{"no-cache", TypeBool, "do not use a local cache"},
+		{"no-lock", TypeBool, "do not lock the repository, this allows some operations on read-only repositories"},
+		{"option", TypeString | TypeList, "set extended option (key=value)"},
+		{"password-command", TypeString, "shell command to obtain the repository password from"},
+		{"password-file", TypeString, "file to read the repository password from"},
+		{"quiet", TypeBool, "do not output comprehensive progress report"},
+		{"repo", TypeString, "repository to backup to or restore from"},
+		{"repository-file", TypeString, "file to read the repository location from"},
+		{"tls-client-cert", TypeString, "path to a file containing PEM encoded TLS client certificate and private key"},
+		{"verbose", TypeBool | TypeInteger, "be verbose (specify level)"},
+	}
+
+	// commandOptions are the flags specific to each restic command
+	commandOptions = map[string][]Option{
+		"backup": {
+			{"exclude", TypeString | TypeList, "exclude a pattern"},
+			{"exclude-caches", TypeBool, "excludes cache directories that are marked with a CACHEDIR.TAG file"},
+			{"exclude-file", TypeString | TypeList, "read exclude patterns from a file"},
+			{"exclude-if-present", TypeString | TypeList, "takes filename[:header], exclude contents of directories containing filename"},
+			{"exclude-larger-than", TypeString, "max size of the files to be backed up"},
+			{"files-from", TypeString | TypeList, "read the files to backup from file"},
+			{"force", TypeBool, "force re-reading the target files/directories"},
+			{"host", TypeBool | TypeString, "set the hostname for the snapshot manually"},
+			{"iexclude", TypeString | TypeList, "same as exclude but ignores the casing of filenames"},
+			{"iexclude-file", TypeString | TypeList, "same as exclude-file but ignores casing of filenames in patterns"},
+			{"ignore-ctime", TypeBool, "ignore ctime changes when checking for modified files"},
+			{"ignore-inode", TypeBool, "ignore inode number changes when checking for modified files"},
+			{"one-file-system", TypeBool, "exclude other file systems"},
+			{"parent", TypeString, "use this parent snapshot"},
+			{"stdin", TypeBool, "read backup from stdin"},
+			{"stdin-filename", TypeString, "filename to use when reading from stdin"},
+			{"tag", TypeString | TypeList, "add tags for the new snapshot"},
+			{"time", TypeString, "time of the backup"},
+			{"with-atime", TypeBool, "store the atime for all files and directories"},
+		},
+		"cache": {
+			{"cleanup", TypeBool, "remove old cache directories"},
+			{"max-age", TypeInteger, "max age in days for cache directories to be considered old"},
+			{"no-size", TypeBool, "do not output the size of the cache directories"},
+		},
+		"cat": {},
+		"check": {
+			{"check-unused", TypeBool, "find unused blobs"},
+			{"read-data", TypeBool, "read all data blobs"},
+			{"read-data-subset", TypeString, "read subset n of m data packs (format: n/m)"},
+			{"with-cache", TypeBool, "use the cache"},
+		},
+		"copy": {
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host"},
+			{"key-hint2", TypeString, "key ID of key to try decrypting the destination repository first"},
+			{"password-command2", TypeString, "shell command to obtain the destination repository password from"},
+			{"password-file2", TypeString, "file to read the destination repository password from"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path"},
+			{"repo2", TypeString, "destination repository to copy snapshots to"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist"},
+		},
+		"diff": {
+			{"metadata", TypeBool, "print changes in metadata"},
+		},
+		"dump": {
+			{"archive", TypeString, "set archive format as tar or zip"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host when the snapshot ID is latest"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path when the snapshot ID is latest"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist when the snapshot ID is latest"},
+		},
+		"find": {
+			{"blob", TypeBool, "pattern is a blob-ID"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host"},
+			{"ignore-case", TypeBool, "ignore case for pattern"},
+			{"long", TypeBool, "use a long listing format showing size and mode"},
+			{"newest", TypeString, "newest modification date/time"},
+			{"oldest", TypeString, "oldest modification date/time"},
+			{"pack", TypeBool, "pattern is a pack-ID"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path"},
+			{"show-pack-id", TypeBool, "display the pack-ID the blobs belong to"},
+			{"snapshot", TypeString | TypeList, "snapshot id to search in"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist"},
+			{"tree", TypeBool, "pattern is a tree-ID"},
+		},
+		"forget": {
+			{"compact", TypeBool, "use compact output format"},
+			{"dry-run", TypeBool, "do not delete anything, just print what would be done"},
+			{"group-by", TypeString, "string for grouping snapshots by host,paths,tags"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots with the given host"},
+			{"keep-daily", TypeInteger, "keep the last n daily snapshots"},
+			{"keep-hourly", TypeInteger, "keep the last n hourly snapshots"},
+			{"keep-last", TypeInteger, "keep the last n snapshots"},
+			{"keep-monthly", TypeInteger, "keep the last n monthly snapshots"},
+			{"keep-tag", TypeString | TypeList, "keep snapshots with this taglist"},
+			{"keep-weekly", TypeInteger, "keep the last n weekly snapshots"},
+			{"keep-within", TypeString, "keep snapshots that are newer than duration (eg. 1y5m7d2h) relative to the latest snapshot"},
+			{"keep-yearly", TypeInteger, "keep the last n yearly snapshots"},
+			{"max-repack-size", TypeString, "maximum size to repack (allowed suffixes: k/K, m/M, g/G, t/T)"},
+			{"max-unused", TypeString, "tolerate given limit of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path"},
+			{"prune", TypeBool, "automatically run the 'prune' command if snapshots have been removed"},
+			{"repack-cacheable-only", TypeBool, "only repack packs which are cacheable"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist"},
+		},
+		"init": {
+			{"copy-chunker-params", TypeBool, "copy chunker parameters from the secondary repository (useful with the copy command)"},
+			{"key-hint2", TypeString, "key ID of key to try decrypting the secondary repository first"},
+			{"password-command2", TypeString, "shell command to obtain the secondary repository password from"},
+			{"password-file2", TypeString, "file to read the secondary repository password from"},
+			{"repo2", TypeString, "secondary repository to copy chunker parameters from"},
+		},
+		"key": {
+			{"host", TypeString, "the hostname for new keys"},
+			{"new-password-file", TypeString, "file from which to read the new password"},
+			{"user", TypeString, "the username for new keys"},
+		},
+		"list": {},
+		"ls": {
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host, when no snapshot ID is given"},
+			{"long", TypeBool, "use a long listing format showing size and mode"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path, when no snapshot ID is given"},
+			{"recursive", TypeBool, "include files in subfolders of the listed directories"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist, when no snapshot ID is given"},
+		},
+		"migrate": {
+			{"force", TypeBool, "apply a migration a second time"},
+		},
+		"mount": {
+			{"allow-other", TypeBool, "allow other users to access the data in the mounted directory"},
+			{"allow-root", TypeBool, "allow root user to access the data in the mounted directory"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host"},
+			{"no-default-permissions", TypeBool, "for 'allow-other', ignore Unix permissions and allow users to read all snapshot files"},
+			{"owner-root", TypeBool, "use 'root' as the owner of files and dirs"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path"},
+			{"snapshot-template", TypeString, "set template to use for snapshot dirs"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist"},
+		},
+		"prune": {
+			{"dry-run", TypeBool, "do not modify the repository, just print what would be done"},
+			{"max-repack-size", TypeString, "maximum size to repack (allowed suffixes: k/K, m/M, g/G, t/T)"},
+			{"max-unused", TypeString, "tolerate given limit of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')"},
+			{"repack-cacheable-only", TypeBool, "only repack packs which are cacheable"},
+		},
+		"rebuild-index": {
+			{"read-all-packs", TypeBool, "read all pack files to generate new index from scratch"},
+		},
+		"recover": {},
+		"restore": {
+			{"exclude", TypeString | TypeList, "exclude a pattern"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host when the snapshot ID is latest"},
+			{"iexclude", TypeString | TypeList, "same as exclude but ignores the casing of filenames"},
+			{"iinclude", TypeString | TypeList, "same as include but ignores the casing of filenames"},
+			{"include", TypeString | TypeList, "include a pattern, exclude everything else"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path when the snapshot ID is latest"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist when the snapshot ID is latest"},
+			{"target", TypeString, "directory to extract data to"},
+			{"verify", TypeBool, "verify restored files content"},
+		},
+		"self-update": {
+			{"output", TypeString, "save the downloaded file as filename"},
+		},
+		"snapshots": {
+			{"compact", TypeBool, "use compact output format"},
+			{"group-by", TypeString, "string for grouping snapshots by host,paths,tags"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host"},
+			{"last", TypeBool, "only show the last snapshot for each host and path"},
+			{"path", TypeString | TypeList, "only consider snapshots for this path"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist"},
+		},
+		"stats": {
+			{"host", TypeBool | TypeString | TypeList, "filter latest snapshot by this hostname"},
+			{"mode", TypeString, "counting mode: restore-size, files-by-contents, blobs-per-file or raw-data"},
+			{"path", TypeString | TypeList, "filter latest snapshot by this path"},
+			{"tag", TypeString | TypeList, "filter latest snapshot by this taglist"},
+		},
+		"tag": {
+			{"add", TypeString | TypeList, "tags which will be added to the existing tags"},
+			{"host", TypeBool | TypeString | TypeList, "only consider snapshots for this host"},
+			{"path", TypeString | TypeList, "only consider snapshots which include this path"},
+			{"remove", TypeString | TypeList, "tags which will be removed from the existing tags"},
+			{"set", TypeString | TypeList, "tags which will replace the existing tags"},
+			{"tag", TypeString | TypeList, "only consider snapshots which include this taglist"},
+		},
+		"unlock": {
+			{"remove-all", TypeBool, "remove all locks, even non-stale ones"},
+		},
+		"version": {},
+	}
+)
+
+// GlobalOptions returns the flags available to all restic commands
+func GlobalOptions() []Option {
+	return globalOptions
+}
+
+// CommandNames returns the sorted list of all known restic commands
+func CommandNames() []string {
+	names := make([]string, 0, len(commandOptions))
+	for name := range commandOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsCommand returns true if the name is a known restic command
+func IsCommand(name string) bool {
+	_, found := commandOptions[name]
+	return found
+}
+
+// CommandOptions returns the flags specific to the command (global flags are not included)
+func CommandOptions(command string) ([]Option, bool) {
+	options, found := commandOptions[command]
+	return options, found
+}
+
+// LookupOption searches for the flag in the command specific flags, then in the global flags
+func LookupOption(command, name string) (Option, bool) {
+	for _, option := range commandOptions[command] {
+		if option.Name == name {
+			return option, true
+		}
+	}
+	for _, option := range globalOptions {
+		if option.Name == name {
+			return option, true
+		}
+	}
+	return Option{}, false
+}
+
+// ValueType returns the type of a configuration value, and false if the type is not supported
+func ValueType(value interface{}) (OptionType, bool) {
+	switch v := value.(type) {
+	case bool:
+		return TypeBool, true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TypeInteger, true
+	case float32:
+		return floatType(float64(v)), true
+	case float64:
+		return floatType(v), true
+	case string:
+		return TypeString, true
+	case []string:
+		return TypeList, true
+	case []interface{}:
+		for _, item := range v {
+			itemType, ok := ValueType(item)
+			if !ok || itemType&(TypeString|TypeInteger) == 0 {
+				return 0, false
+			}
+		}
+		return TypeList, true
+	}
+	return 0, false
+}
+
+// floatType returns TypeInteger for numbers without a decimal part (JSON numbers are all float64),
+// and TypeString otherwise
+func floatType(value float64) OptionType {
+	if value == float64(int64(value)) {
+		return TypeInteger
+	}
+	return TypeString
+}
@@ -0,0 +1,82 @@
+package restic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupOption(t *testing.T) {
+	option, found := LookupOption("backup", "exclude")
+	assert.True(t, found)
+	assert.Equal(t, "exclude", option.Name)
+
+	// global flag
+	option, found = LookupOption("backup", "password-file")
+	assert.True(t, found)
+	assert.Equal(t, "password-file", option.Name)
+
+	_, found = LookupOption("backup", "exlude")
+	assert.False(t, found)
+
+	// command specific flag of another command
+	_, found = LookupOption("check", "exclude")
+	assert.False(t, found)
+
+	// unknown command still has the global flags
+	_, found = LookupOption("unknown", "repo")
+	assert.True(t, found)
+}
+
+func TestCommandNames(t *testing.T) {
+	names := CommandNames()
+	assert.Contains(t, names, "backup")
+	assert.Contains(t, names, "rebuild-index")
+	assert.True(t, IsCommand("restore"))
+	assert.False(t, IsCommand("retention"))
+}
+
+func TestValueType(t *testing.T) {
+	testData := []struct {
+		value     interface{}
+		valueType OptionType
+		valid     bool
+	}{
+		{true, TypeBool, true},
+		{int64(10), TypeInteger, true},
+		{float64(10), TypeInteger, true},
+		{float64(1.5), TypeString, true},
+		{"value", TypeString, true},
+		{[]string{"one", "two"}, TypeList, true},
+		{[]interface{}{"one", int64(2)}, TypeList, true},
+		{[]interface{}{"one", true}, 0, false},
+		{map[string]interface{}{}, 0, false},
+	}
+	for _, testItem := range testData {
+		valueType, valid := ValueType(testItem.value)
+		assert.Equal(t, testItem.valid, valid, "%v", testItem.value)
+		assert.Equal(t, testItem.valueType, valueType, "%v", testItem.value)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	testData := []struct {
+		option    Option
+		valueType OptionType
+		accept    bool
+	}{
+		{Option{Type: TypeBool}, TypeBool, true},
+		{Option{Type: TypeBool}, TypeString, false},
+		{Option{Type: TypeInteger}, TypeInteger, true},
+		{Option{Type: TypeInteger}, TypeString, false},
+		{Option{Type: TypeString}, TypeInteger, true},
+		{Option{Type: TypeString}, TypeList, false},
+		{Option{Type: TypeString | TypeList}, TypeString, true},
+		{Option{Type: TypeString | TypeList}, TypeList, true},
+		{Option{Type: TypeString | TypeList}, TypeBool, false},
+		{Option{Type: TypeBool | TypeInteger}, TypeInteger, true},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.accept, testItem.option.Accept(testItem.valueType), "%+v", testItem)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+)
+
+// resticLockExitCode is the exit code of restic when it cannot lock the repository (from restic 0.17)
+const resticLockExitCode = 11
+
+// retryableErrors are the messages of restic showing the command could succeed if we try again later:
+// the repository is locked by another restic, or the backend is not available for a moment
+var retryableErrors = []string{
+	"repository is already locked",
+	"unable to create lock",
+	"connection reset by peer",
+	"connection refused",
+	"i/o timeout",
+	"tls handshake timeout",
+	"temporary failure in name resolution",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// retryError is the error of a command which was still failing after all its attempts
+type retryError struct {
+	err      error
+	attempts int
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.err, e.attempts)
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// Attempts returns the number of times the command was run
+func (e *retryError) Attempts() int {
+	return e.attempts
+}
+
+// runWithRetry runs the restic command, and runs it again while it fails on a locked repository or a transient error,
+// using the retry configuration of the profile. It returns the number of attempts
+func (r *resticWrapper) runWithRetry(command shellCommandDefinition) (int, error) {
+	retry := r.profile.Retry
+	if retry == nil || retry.MaxAttempts <= 1 {
+		return 1, runShellCommand(command)
+	}
+	if command.useStdin {
+		// stdin cannot be read twice
+		clog.Debug("no retry when the command is reading from stdin")
+		return 1, runShellCommand(command)
+	}
+	delay := getRetryInitialDelay(retry)
+	for attempt := 1; ; attempt++ {
+		err := runShellCommand(command)
+		if err == nil {
+			if attempt > 1 {
+				clog.Infof("profile '%s': '%s' succeeded on attempt %d/%d", r.profile.Name, r.command, attempt, retry.MaxAttempts)
+			}
+			return attempt, nil
+		}
+		if !isRetryableError(err) {
+			if attempt > 1 {
+				return attempt, &retryError{err: err, attempts: attempt}
+			}
+			return attempt, err
+		}
+		if attempt >= retry.MaxAttempts {
+			clog.Errorf("profile '%s': attempt %d/%d of '%s' failed: %v", r.profile.Name, attempt, retry.MaxAttempts, r.command, err)
+			return attempt, &retryError{err: err, attempts: attempt}
+		}
+		clog.Warningf("profile '%s': attempt %d/%d of '%s' failed: %v, trying again in %s", r.profile.Name, attempt, retry.MaxAttempts, r.command, err, delay)
+		err = r.waitBeforeRetry(delay)
+		if err != nil {
+			return attempt, &retryError{err: err, attempts: attempt}
+		}
+		delay = getRetryNextDelay(retry, delay)
+	}
+}
+
+// waitBeforeRetry waits for the delay, unless resticprofile receives a signal to stop
+func (r *resticWrapper) waitBeforeRetry(delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case sig := <-r.sigChan:
+		return fmt.Errorf("retry interrupted by signal %s", sig)
+	}
+}
+
+// isRetryableError returns true when the command failed on a locked repository or a transient backend error
+func isRetryableError(err error) bool {
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) && exitError.ExitCode() == resticLockExitCode {
+		return true
+	}
+	var failedOutput *stderrError
+	if !errors.As(err, &failedOutput) {
+		return false
+	}
+	output := strings.ToLower(failedOutput.Stderr())
+	for _, message := range retryableErrors {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+	return false
+}
+
+func getRetryInitialDelay(retry *config.RetrySection) time.Duration {
+	delay := retry.InitialDelay
+	if delay == 0 {
+		delay = constants.DefaultRetryInitialDelay
+	}
+	if maxDelay := getRetryMaxDelay(retry); delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func getRetryNextDelay(retry *config.RetrySection, delay time.Duration) time.Duration {
+	factor := retry.BackoffFactor
+	if factor == 0 {
+		factor = constants.DefaultRetryBackoffFactor
+	}
+	// compare before converting back: a duration too long for time.Duration would overflow to a negative value
+	maxDelay := getRetryMaxDelay(retry)
+	next := float64(delay) * factor
+	if next >= float64(maxDelay) {
+		return maxDelay
+	}
+	return time.Duration(next)
+}
+
+// getRetryMaxDelay returns the longest delay between two attempts
+func getRetryMaxDelay(retry *config.RetrySection) time.Duration {
+	if retry.MaxDelay > 0 {
+		return retry.MaxDelay
+	}
+	return constants.DefaultRetryMaxDelay
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/creativeprojects/resticprofile/term"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelays(t *testing.T) {
+	retry := &config.RetrySection{}
+	delay := getRetryInitialDelay(retry)
+	assert.Equal(t, constants.DefaultRetryInitialDelay, delay)
+	assert.Equal(t, 2*constants.DefaultRetryInitialDelay, getRetryNextDelay(retry, delay))
+
+	retry = &config.RetrySection{
+		InitialDelay:  10 * time.Second,
+		BackoffFactor: 1.5,
+		MaxDelay:      20 * time.Second,
+	}
+	delay = getRetryInitialDelay(retry)
+	assert.Equal(t, 10*time.Second, delay)
+	delay = getRetryNextDelay(retry, delay)
+	assert.Equal(t, 15*time.Second, delay)
+	delay = getRetryNextDelay(retry, delay)
+	assert.Equal(t, 20*time.Second, delay)
+	delay = getRetryNextDelay(retry, delay)
+	assert.Equal(t, 20*time.Second, delay)
+
+	retry = &config.RetrySection{
+		InitialDelay: time.Minute,
+		MaxDelay:     20 * time.Second,
+	}
+	assert.Equal(t, 20*time.Second, getRetryInitialDelay(retry))
+
+	// without max-delay, the delay stops growing after an hour (and never overflows)
+	retry = &config.RetrySection{MaxAttempts: 100}
+	delay = getRetryInitialDelay(retry)
+	for attempt := 2; attempt < retry.MaxAttempts; attempt++ {
+		delay = getRetryNextDelay(retry, delay)
+		assert.True(t, delay > 0)
+	}
+	assert.Equal(t, constants.DefaultRetryMaxDelay, delay)
+
+	retry = &config.RetrySection{InitialDelay: 2 * time.Hour}
+	assert.Equal(t, constants.DefaultRetryMaxDelay, getRetryInitialDelay(retry))
+}
+
+func TestIsRetryableError(t *testing.T) {
+	testData := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("exit status 1"), false},
+		{&stderrError{err: errors.New("exit status 1"), stderr: "Fatal: wrong password or no key found"}, false},
+		{&stderrError{err: errors.New("exit status 1"), stderr: "unable to create lock in backend: repository is already locked by PID 1234"}, true},
+		{fmt.Errorf("wrapped: %w", &stderrError{err: errors.New("exit status 1"), stderr: "Load(<lock/1234>) returned error: Get: dial tcp: i/o timeout"}), true},
+		{&stderrError{err: errors.New("exit status 1"), stderr: "server response unexpected: 503 Service Unavailable"}, true},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.retryable, isRetryableError(testItem.err), testItem.err)
+	}
+}
+
+func TestRunWithRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command is using the unix shell")
+	}
+	term.SetErrorOutput(ioutil.Discard)
+	defer term.SetErrorOutput(os.Stderr)
+
+	// the command fails until the attempt number "succeed"
+	command := "n=$(cat $COUNTER 2>/dev/null || echo 0); n=$((n+1)); echo $n > $COUNTER; " +
+		"if [ $n -lt $SUCCEED ]; then echo $MESSAGE >&2; exit $EXIT_CODE; fi; exit 0;"
+
+	testData := []struct {
+		maxAttempts int
+		succeed     int
+		exitCode    int
+		message     string
+		attempts    int
+		err         string
+	}{
+		{0, 2, 1, "repository is already locked", 1, "backup on profile 'name': exit status 1"},
+		{3, 1, 1, "repository is already locked", 1, ""},
+		{3, 3, 1, "repository is already locked", 3, ""},
+		{3, 3, resticLockExitCode, "", 3, ""},
+		{3, 4, 1, "repository is already locked", 3, "backup on profile 'name': exit status 1 (after 3 attempts)"},
+		{3, 2, 1, "wrong password", 1, "backup on profile 'name': exit status 1"},
+	}
+	for _, testItem := range testData {
+		testItem := testItem
+		t.Run(fmt.Sprintf("%d-%d-%d", testItem.maxAttempts, testItem.succeed, testItem.exitCode), func(t *testing.T) {
+			prefix := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d", "TestRunWithRetry", time.Now().UnixNano(), os.Getpid()))
+			statusFile := prefix + ".json"
+			counterFile := prefix + ".txt"
+			defer os.Remove(statusFile)
+			defer os.Remove(counterFile)
+
+			profile := config.NewProfile(nil, "name")
+			profile.StatusFile = statusFile
+			profile.Backup = &config.BackupSection{}
+			profile.Retry = &config.RetrySection{
+				MaxAttempts:  testItem.maxAttempts,
+				InitialDelay: time.Millisecond,
+			}
+			profile.Environment = map[string]string{
+				"counter":   counterFile,
+				"succeed":   strconv.Itoa(testItem.succeed),
+				"exit_code": strconv.Itoa(testItem.exitCode),
+				"message":   testItem.message,
+			}
+			wrapper := newResticWrapper(command, false, false, profile, constants.CommandBackup, nil, nil)
+			err := wrapper.runProfile()
+			if testItem.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, testItem.err)
+			}
+
+			profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+			assert.Equal(t, testItem.err == "", profileStatus.Backup.Success)
+			if testItem.attempts > 1 {
+				assert.Equal(t, testItem.attempts, profileStatus.Backup.Attempts)
+			} else {
+				assert.Equal(t, 0, profileStatus.Backup.Attempts)
+			}
+		})
+	}
+}
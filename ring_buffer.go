@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// ringBuffer is a writer keeping only the last bytes written (up to its size)
+type ringBuffer struct {
+	mu        sync.Mutex
+	buffer    []byte
+	size      int
+	truncated bool
+}
+
+// newRingBuffer creates a ring buffer keeping the last size bytes
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{
+		buffer: make([]byte, 0, size),
+		size:   size,
+	}
+}
+
+// Write keeps the end of the data in the buffer. It never fails
+func (b *ringBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	written := len(data)
+	if len(data) >= b.size {
+		// only the end of the data fits into the buffer
+		b.truncated = b.truncated || len(data) > b.size || len(b.buffer) > 0
+		b.buffer = append(b.buffer[:0], data[len(data)-b.size:]...)
+		return written, nil
+	}
+	if overflow := len(b.buffer) + len(data) - b.size; overflow > 0 {
+		b.truncated = true
+		b.buffer = append(b.buffer[:0], b.buffer[overflow:]...)
+	}
+	b.buffer = append(b.buffer, data...)
+	return written, nil
+}
+
+// String returns the content of the buffer: when the beginning was dropped, the first (partial) line is removed
+func (b *ringBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	content := string(b.buffer)
+	if b.truncated {
+		if index := strings.IndexByte(content, '\n'); index >= 0 && index < len(content)-1 {
+			content = content[index+1:]
+		}
+	}
+	return strings.TrimSpace(content)
+}
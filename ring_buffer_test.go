@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBuffer(t *testing.T) {
+	testData := []struct {
+		size     int
+		writes   []string
+		expected string
+	}{
+		{10, []string{}, ""},
+		{10, []string{"hello"}, "hello"},
+		{10, []string{"hello", "world"}, "helloworld"},
+		{10, []string{"hello", "world", "!"}, "elloworld!"},
+		{10, []string{"0123456789abc"}, "3456789abc"},
+		{10, []string{"abc", "0123456789"}, "0123456789"},
+		{12, []string{"line 1\n", "line 2\n", "line 3\n"}, "line 3"},
+		{16, []string{"first line\nsecond line\n"}, "second line"},
+		{16, []string{"no newline at all in here"}, "e at all in here"},
+	}
+	for _, testItem := range testData {
+		t.Run(fmt.Sprintf("%d %s", testItem.size, strings.Join(testItem.writes, "+")), func(t *testing.T) {
+			buffer := newRingBuffer(testItem.size)
+			for _, data := range testItem.writes {
+				n, err := buffer.Write([]byte(data))
+				assert.NoError(t, err)
+				assert.Equal(t, len(data), n)
+			}
+			assert.Equal(t, testItem.expected, buffer.String())
+			assert.LessOrEqual(t, len(buffer.buffer), testItem.size)
+		})
+	}
+}
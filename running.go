@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/lock"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// stopPollInterval is the delay between two checks of the processes being stopped
+var stopPollInterval = 500 * time.Millisecond
+
+// runningProfile is a profile with a lockfile on the disk
+type runningProfile struct {
+	name  string
+	info  lock.Info
+	child *process.Process // running child process (restic or a hook), nil if none
+	stale bool             // the lockfile was left behind: no process owns the lock
+}
+
+// getRunningProfiles returns all the profiles with a lockfile on the disk, sorted by name
+func getRunningProfiles(c *config.Config) []runningProfile {
+	rootPath := filepath.Dir(c.GetConfigFile())
+	running := make([]runningProfile, 0)
+	for _, name := range sortedMapKeys(c.GetProfileSections()) {
+		profile, err := c.GetProfile(name)
+		if err != nil {
+			clog.Warningf("cannot load profile '%s': %v", name, err)
+			continue
+		}
+		if profile == nil || profile.Lock == "" {
+			continue
+		}
+		profile.SetRootPath(rootPath)
+		runningProfile, err := getRunningProfile(name, newProfileLock(profile))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				clog.Warningf("cannot read lockfile of profile '%s': %v", name, err)
+			}
+			continue
+		}
+		running = append(running, runningProfile)
+	}
+	return running
+}
+
+// getRunningProfile reads the lockfile of the profile, and checks that a process still owns the lock.
+// A kernel lock is released when the process dies. A simple lockfile has no owner recorded:
+// it's only considered stale when none of its child processes is running
+func getRunningProfile(name string, profileLock *lock.Lock) (runningProfile, error) {
+	info, err := profileLock.Info()
+	if err != nil {
+		return runningProfile{}, err
+	}
+	child := getRunningChild(info)
+	stale := child == nil
+	if profileLock.IsKernelLock() {
+		stale = !profileLock.Held()
+	}
+	if stale {
+		// the PID of a dead child can be reused by another program
+		child = nil
+	}
+	return runningProfile{
+		name:  name,
+		info:  info,
+		child: child,
+		stale: stale,
+	}, nil
+}
+
+// getRunningChild returns the last child process recorded in the lockfile, if it's still running.
+// A process started before the lock is not returned: it means the PID was reused by another program
+func getRunningChild(info lock.Info) *process.Process {
+	if len(info.PIDs) == 0 {
+		return nil
+	}
+	// the child processes are started one after the other: only the last one can still be running
+	child, err := process.NewProcess(info.PIDs[len(info.PIDs)-1])
+	if err != nil {
+		return nil
+	}
+	if !info.Started.IsZero() {
+		created, err := child.CreateTime()
+		// the start date in the lockfile is truncated to the second, and the creation time of a process is not that precise either
+		if err != nil || created < info.Started.Add(-2*time.Second).Unix()*1000 {
+			return nil
+		}
+	}
+	return child
+}
+
+// displayRunningProfiles lists the profiles currently running, from their lockfiles. The lockfiles left behind are listed as stale
+func displayRunningProfiles(output io.Writer, c *config.Config, _ commandLineFlags, _ []string) error {
+	running := getRunningProfiles(c)
+	if len(running) == 0 {
+		fmt.Fprintln(output, "\nThere's no running profile")
+		fmt.Fprintln(output, "")
+		return nil
+	}
+	fmt.Fprintln(output, "\nRunning profiles:")
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\t%s\t%s\n", "PROFILE", "STATUS", "STARTED", "PID", "LOCK")
+	for _, profile := range running {
+		state := "running"
+		if profile.stale {
+			state = "stale"
+		}
+		started := "unknown"
+		if !profile.info.Started.IsZero() {
+			started = profile.info.Started.Format("2006-01-02 15:04:05")
+		}
+		pid := "-"
+		if profile.child != nil {
+			pid = fmt.Sprintf("%d", profile.child.Pid)
+		}
+		_, _ = fmt.Fprintf(w, "\t%s\t%s\t%s\t%s\t%s\n", profile.name, state, started, pid, profile.info.Who)
+	}
+	_ = w.Flush()
+	fmt.Fprintln(output, "")
+	return nil
+}
+
+// stopProfile accepts one argument from the commandline: --grace-period <duration>
+func stopProfile(output io.Writer, c *config.Config, flags commandLineFlags, args []string) error {
+	gracePeriod, err := getGracePeriod(args)
+	if err != nil {
+		return err
+	}
+	profile, err := c.GetProfile(flags.name)
+	if err != nil {
+		return fmt.Errorf("cannot load profile '%s': %w", flags.name, err)
+	}
+	if profile == nil {
+		return fmt.Errorf("profile '%s' not found", flags.name)
+	}
+	if profile.Lock == "" {
+		return fmt.Errorf("profile '%s' has no lockfile: its processes cannot be found", flags.name)
+	}
+	profile.SetRootPath(filepath.Dir(c.GetConfigFile()))
+
+	running, err := getRunningProfile(flags.name, newProfileLock(profile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("profile '%s' is not running", flags.name)
+		}
+		return fmt.Errorf("cannot read lockfile of profile '%s': %w", flags.name, err)
+	}
+	if running.stale {
+		return fmt.Errorf("profile '%s' is not running (stale lockfile '%s')", flags.name, profile.Lock)
+	}
+	if running.child == nil {
+		return fmt.Errorf("profile '%s' has no running process recorded in its lockfile", flags.name)
+	}
+	err = stopProcess(running.child, gracePeriod)
+	if err != nil {
+		return fmt.Errorf("cannot stop profile '%s': %w", flags.name, err)
+	}
+	_, _ = fmt.Fprintf(output, "profile '%s' stopped\n", flags.name)
+	return nil
+}
+
+// getGracePeriod returns the delay given to the processes to terminate before they're killed
+func getGracePeriod(args []string) (time.Duration, error) {
+	value := ""
+	for i, arg := range args {
+		if arg == "--grace-period" && i+1 < len(args) {
+			value = args[i+1]
+		} else if strings.HasPrefix(arg, "--grace-period=") {
+			value = strings.TrimPrefix(arg, "--grace-period=")
+		}
+	}
+	if value == "" {
+		return constants.DefaultStopGracePeriod, nil
+	}
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse the grace period: %w", err)
+	}
+	if gracePeriod < 0 {
+		return 0, fmt.Errorf("invalid grace period: %s", gracePeriod)
+	}
+	return gracePeriod, nil
+}
+
+// stopProcess terminates the process and its children (SIGTERM on unixes), and kills them if they're still running after the grace period.
+// The children are included for the case where the recorded process is the shell starting restic
+func stopProcess(parent *process.Process, gracePeriod time.Duration) error {
+	processes := append([]*process.Process{parent}, getDescendants(parent)...)
+	clog.Infof("terminating process %d", parent.Pid)
+	for _, proc := range processes {
+		err := proc.Terminate()
+		if err != nil && proc == parent && isRunning(proc) {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(gracePeriod)
+	for {
+		running := make([]*process.Process, 0, len(processes))
+		for _, proc := range processes {
+			if isRunning(proc) {
+				running = append(running, proc)
+			}
+		}
+		if len(running) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			processes = running
+			break
+		}
+		time.Sleep(stopPollInterval)
+	}
+
+	clog.Warningf("process %d is still running after %s: killing it", parent.Pid, gracePeriod)
+	for _, proc := range processes {
+		_ = proc.Kill()
+	}
+	time.Sleep(stopPollInterval)
+	for _, proc := range processes {
+		if isRunning(proc) {
+			return errors.New("the processes are still running after being killed")
+		}
+	}
+	return nil
+}
+
+// getDescendants returns the children of the process, and their own children
+func getDescendants(parent *process.Process) []*process.Process {
+	children, err := parent.Children()
+	if err != nil {
+		return nil
+	}
+	descendants := make([]*process.Process, 0, len(children))
+	for _, child := range children {
+		descendants = append(descendants, child)
+		descendants = append(descendants, getDescendants(child)...)
+	}
+	return descendants
+}
+
+// isRunning returns true when the process still exists and is not a zombie
+func isRunning(proc *process.Process) bool {
+	running, err := proc.IsRunning()
+	if err != nil || !running {
+		return false
+	}
+	status, err := proc.Status()
+	if err == nil && len(status) > 0 && status[0] == process.Zombie {
+		return false
+	}
+	return true
+}
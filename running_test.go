@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/lock"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetGracePeriod(t *testing.T) {
+	testData := []struct {
+		args        []string
+		gracePeriod time.Duration
+		err         bool
+	}{
+		{nil, constants.DefaultStopGracePeriod, false},
+		{[]string{"--grace-period", "5s"}, 5 * time.Second, false},
+		{[]string{"--grace-period=1m"}, time.Minute, false},
+		{[]string{"--grace-period", "0s"}, 0, false},
+		{[]string{"--grace-period", "five"}, 0, true},
+		{[]string{"--grace-period=-1s"}, 0, true},
+	}
+	for _, testItem := range testData {
+		gracePeriod, err := getGracePeriod(testItem.args)
+		if testItem.err {
+			assert.Error(t, err, testItem.args)
+			continue
+		}
+		assert.NoError(t, err, testItem.args)
+		assert.Equal(t, testItem.gracePeriod, gracePeriod, testItem.args)
+	}
+}
+
+func TestGetRunningChild(t *testing.T) {
+	pid := int32(os.Getpid())
+	assert.Nil(t, getRunningChild(lock.Info{}))
+
+	// the current process was started before the lock: the PID was reused
+	child := getRunningChild(lock.Info{Started: time.Now().Add(time.Minute), PIDs: []int32{pid}})
+	assert.Nil(t, child)
+
+	child = getRunningChild(lock.Info{Started: time.Now().Add(-time.Hour), PIDs: []int32{1, pid}})
+	require.NotNil(t, child)
+	assert.Equal(t, pid, child.Pid)
+}
+
+// startSleep starts a process sleeping in the background, and returns it with a function to wait for its end
+func startSleep(t *testing.T, script string) (*process.Process, func()) {
+	cmd := exec.Command("sh", "-c", script)
+	require.NoError(t, cmd.Start())
+	done := make(chan error)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	proc, err := process.NewProcess(int32(cmd.Process.Pid))
+	require.NoError(t, err)
+	// give some time to the shell to install its trap
+	time.Sleep(100 * time.Millisecond)
+	return proc, func() {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("the process is still running")
+		}
+	}
+}
+
+func TestStopProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command is using the unix shell")
+	}
+	defer func(interval time.Duration) { stopPollInterval = interval }(stopPollInterval)
+	stopPollInterval = 10 * time.Millisecond
+
+	t.Run("terminate", func(t *testing.T) {
+		proc, wait := startSleep(t, "sleep 60")
+		start := time.Now()
+		assert.NoError(t, stopProcess(proc, 10*time.Second))
+		wait()
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		proc, wait := startSleep(t, "trap '' TERM; while true; do sleep 1; done")
+		start := time.Now()
+		assert.NoError(t, stopProcess(proc, 200*time.Millisecond))
+		wait()
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	})
+}
+
+func TestDisplayRunningProfiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command is using the unix shell")
+	}
+	// a space in the path of the lockfile
+	lockfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s %d%d.lock", "TestDisplayRunningProfiles", time.Now().UnixNano(), os.Getpid()))
+	testConfig := `
+[running]
+lock = "` + lockfile + `"
+[idle]
+lock = "` + lockfile + `.idle"
+[nolock]
+`
+	c, err := config.Load(bytes.NewBufferString(testConfig), "toml")
+	require.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	require.NoError(t, displayRunningProfiles(output, c, commandLineFlags{}, nil))
+	assert.Contains(t, output.String(), "There's no running profile")
+
+	// the lockfile of a profile being run
+	profile, err := c.GetProfile("running")
+	require.NoError(t, err)
+	profile.SetRootPath(filepath.Dir(c.GetConfigFile()))
+	profile.EscapeShellPaths()
+	runLock := lock.NewLock(profile.Lock)
+	require.True(t, runLock.TryAcquire())
+	defer runLock.Release()
+	proc, wait := startSleep(t, "sleep 60")
+	runLock.SetPID(int(proc.Pid))
+
+	output.Reset()
+	require.NoError(t, displayRunningProfiles(output, c, commandLineFlags{}, nil))
+	lines := strings.Split(output.String(), "\n")
+	require.Len(t, lines, 6)
+	assert.Equal(t, "Running profiles:", lines[1])
+	assert.Contains(t, lines[3], "running")
+	assert.Contains(t, lines[3], fmt.Sprintf("%d", proc.Pid))
+
+	output.Reset()
+	err = stopProfile(output, c, commandLineFlags{name: "running"}, []string{"--grace-period", "5s"})
+	assert.NoError(t, err)
+	wait()
+	assert.Equal(t, "profile 'running' stopped\n", output.String())
+
+	err = stopProfile(output, c, commandLineFlags{name: "idle"}, nil)
+	assert.EqualError(t, err, "profile 'idle' is not running")
+	err = stopProfile(output, c, commandLineFlags{name: "nolock"}, nil)
+	assert.EqualError(t, err, "profile 'nolock' has no lockfile: its processes cannot be found")
+}
+
+func TestDisplayStaleProfiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command is using the unix shell")
+	}
+	lockfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.lock", "TestDisplayStaleProfiles", time.Now().UnixNano(), os.Getpid()))
+	testConfig := `
+[file]
+lock = "` + lockfile + `.file"
+[flock]
+lock = "` + lockfile + `.flock"
+lock-mode = "flock"
+[held]
+lock = "` + lockfile + `.held"
+lock-mode = "flock"
+`
+	c, err := config.Load(bytes.NewBufferString(testConfig), "toml")
+	require.NoError(t, err)
+
+	// simple lockfile without any running process
+	fileLock := lock.NewLock(lockfile + ".file")
+	require.True(t, fileLock.TryAcquire())
+	defer fileLock.Release()
+	fileLock.SetPID(int(startStoppedProcess(t)))
+
+	// lockfile left behind by a run which crashed: the kernel lock is free
+	require.NoError(t, ioutil.WriteFile(lockfile+".flock", []byte("user on Thursday, 04-Mar-21 02:00:01 GMT from host\n12345"), 0644))
+	defer os.Remove(lockfile + ".flock")
+
+	// kernel lock still owned
+	heldLock := lock.NewFlock(lockfile + ".held")
+	require.True(t, heldLock.TryAcquire())
+	defer heldLock.Release()
+
+	output := &bytes.Buffer{}
+	require.NoError(t, displayRunningProfiles(output, c, commandLineFlags{}, nil))
+	lines := strings.Split(output.String(), "\n")
+	require.Len(t, lines, 8)
+	assert.Regexp(t, `^\s+file\s+stale\s+\S+ \S+\s+-\s`, lines[3])
+	assert.Regexp(t, `^\s+flock\s+stale\s+2021-03-04 02:00:01\s+-\s`, lines[4])
+	assert.Regexp(t, `^\s+held\s+running\s`, lines[5])
+
+	err = stopProfile(output, c, commandLineFlags{name: "flock"}, nil)
+	assert.EqualError(t, err, fmt.Sprintf("profile 'flock' is not running (stale lockfile '%s.flock')", lockfile))
+	err = stopProfile(output, c, commandLineFlags{name: "file"}, nil)
+	assert.EqualError(t, err, fmt.Sprintf("profile 'file' is not running (stale lockfile '%s.file')", lockfile))
+	err = stopProfile(output, c, commandLineFlags{name: "held"}, nil)
+	assert.EqualError(t, err, "profile 'held' has no running process recorded in its lockfile")
+}
+
+// startStoppedProcess runs a process until the end, and returns its PID
+func startStoppedProcess(t *testing.T) int32 {
+	cmd := exec.Command("sh", "-c", "exit 0")
+	require.NoError(t, cmd.Run())
+	return int32(cmd.Process.Pid)
+}
@@ -9,13 +9,14 @@ import (
 )
 
 var (
-	// ScheduledSections are the command that can be scheduled (backup, retention, check, prune)
+	// ScheduledSections are the command that can be scheduled (backup, retention, check, prune, copy)
 	ScheduledSections = []string{
 		constants.CommandBackup,
 		constants.SectionConfigurationRetention,
 		constants.CommandCheck,
 		constants.CommandForget,
 		constants.CommandPrune,
+		constants.CommandCopy,
 	}
 )
 
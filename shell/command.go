@@ -7,8 +7,13 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// NoShell is the name of the shell used to start the command directly, without using a shell
+const NoShell = "none"
+
 // SetPID is a callback to send the PID of the current child process
 type SetPID func(pid int)
 
@@ -18,6 +23,8 @@ type Command struct {
 	Arguments []string
 	Environ   []string
 	Dir       string
+	Shell     string
+	Timeout   time.Duration
 	Stdin     io.Reader
 	Stdout    io.Writer
 	Stderr    io.Writer
@@ -50,13 +57,14 @@ func NewSignalledCommand(command string, args []string, c chan os.Signal) *Comma
 func (c *Command) Run() error {
 	var err error
 
-	command, args, err := getShellCommand(c.Command, c.Arguments)
+	command, args, err := c.getCommand()
 	if err != nil {
 		return err
 	}
 
 	cmd := exec.Command(command, args...)
 
+	cmd.Dir = c.Dir
 	cmd.Stdout = c.Stdout
 	cmd.Stderr = c.Stderr
 	cmd.Stdin = c.Stdin
@@ -66,6 +74,11 @@ func (c *Command) Run() error {
 		cmd.Env = append(cmd.Env, c.Environ...)
 	}
 
+	if c.Timeout > 0 {
+		// the whole process group is stopped on timeout (and not only the shell)
+		setProcessGroup(cmd)
+	}
+
 	// spawn the child process
 	if err = cmd.Start(); err != nil {
 		return err
@@ -81,9 +94,35 @@ func (c *Command) Run() error {
 		}()
 		go c.propagateSignal(cmd.Process)
 	}
+	if c.Timeout > 0 {
+		var timedOut int32
+		timer := time.AfterFunc(c.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			_ = killProcessGroup(cmd.Process)
+		})
+		defer timer.Stop()
+
+		err = cmd.Wait()
+		if err != nil && atomic.LoadInt32(&timedOut) == 1 {
+			return fmt.Errorf("command timed out after %s: %w", c.Timeout, err)
+		}
+		return err
+	}
 	return cmd.Wait()
 }
 
+// getCommand returns the executable and its arguments, depending on the shell of the command
+func (c *Command) getCommand() (string, []string, error) {
+	switch c.Shell {
+	case "":
+		return getShellCommand(c.Command, c.Arguments)
+	case NoShell:
+		return getDirectCommand(c.Command, c.Arguments)
+	default:
+		return getCustomShellCommand(c.Shell, c.Command, c.Arguments)
+	}
+}
+
 // getShellCommand transforms the command line and arguments to be launched via a shell (sh or cmd.exe)
 func getShellCommand(command string, args []string) (string, []string, error) {
 
@@ -106,6 +145,83 @@ func getShellCommand(command string, args []string) (string, []string, error) {
 	return shell, []string{"-c", strings.Join(flatCommand, " ")}, nil
 }
 
+// getCustomShellCommand transforms the command line and arguments to be launched via the shell in parameter (bash, zsh, etc.)
+func getCustomShellCommand(shellName, command string, args []string) (string, []string, error) {
+	shell, err := exec.LookPath(shellName)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot find shell executable (%s) in path", shellName)
+	}
+	// Flatten all arguments into one string, the shell expects one big string
+	flatCommand := append([]string{command}, args...)
+	return shell, []string{"-c", strings.Join(flatCommand, " ")}, nil
+}
+
+// getDirectCommand starts the executable without a shell: the arguments are passed as they are
+func getDirectCommand(command string, args []string) (string, []string, error) {
+	if strings.TrimSpace(command) == "" {
+		return "", nil, fmt.Errorf("empty command")
+	}
+	return command, args, nil
+}
+
+// SplitArguments splits a command line into arguments, like a unix shell would do (without any expansion):
+// arguments are separated by spaces, and can be quoted with single or double quotes or escaped with a backslash
+func SplitArguments(commandLine string) ([]string, error) {
+	args := make([]string, 0)
+	current := strings.Builder{}
+	inArgument := false
+	var quote rune
+	escaped := false
+	for _, char := range commandLine {
+		switch {
+		case escaped:
+			// in double quotes, the backslash only escapes a double quote or another backslash
+			if quote == '"' && char != '"' && char != '\\' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(char)
+			escaped = false
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+				continue
+			}
+			current.WriteRune(char)
+		case char == '\\':
+			inArgument = true
+			escaped = true
+		case quote == '"':
+			if char == '"' {
+				quote = 0
+				continue
+			}
+			current.WriteRune(char)
+		case char == '\'' || char == '"':
+			inArgument = true
+			quote = char
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArgument {
+				args = append(args, current.String())
+				current.Reset()
+				inArgument = false
+			}
+		default:
+			inArgument = true
+			current.WriteRune(char)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command line: %s", commandLine)
+	}
+	if escaped {
+		return nil, fmt.Errorf("unterminated escape character in command line: %s", commandLine)
+	}
+	if inArgument {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
 // removeQuotes removes single and double quotes when the whole string is quoted
 func removeQuotes(args []string) []string {
 	if args == nil {
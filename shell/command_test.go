@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -93,6 +94,112 @@ func TestShellCommand(t *testing.T) {
 	}
 }
 
+func TestSplitArguments(t *testing.T) {
+	testData := []struct {
+		commandLine string
+		args        []string
+	}{
+		{"", []string{}},
+		{"pg_dump", []string{"pg_dump"}},
+		{"  pg_dump   -f  dump.sql ", []string{"pg_dump", "-f", "dump.sql"}},
+		{`echo "hello world" 'it is' here`, []string{"echo", "hello world", "it is", "here"}},
+		{`echo it\'s "a \"quoted\" \path" 'no \escape'`, []string{"echo", "it's", `a "quoted" \path`, `no \escape`}},
+		{`echo "" ''`, []string{"echo", "", ""}},
+		{`echo some" "thing`, []string{"echo", "some thing"}},
+	}
+	for _, testItem := range testData {
+		args, err := SplitArguments(testItem.commandLine)
+		assert.NoError(t, err)
+		assert.Equal(t, testItem.args, args, testItem.commandLine)
+	}
+
+	for _, commandLine := range []string{`echo "hello`, `echo 'hello`, `echo \`} {
+		_, err := SplitArguments(commandLine)
+		assert.Error(t, err, commandLine)
+	}
+}
+
+func TestDirectCommand(t *testing.T) {
+	command, args, err := getDirectCommand("/path/to/restic binary", []string{"--repo", `"/Volumes/RAMDisk"`, "with space"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/path/to/restic binary", command)
+	assert.Equal(t, []string{"--repo", `"/Volumes/RAMDisk"`, "with space"}, args)
+
+	_, _, err = getDirectCommand("  ", nil)
+	assert.Error(t, err)
+}
+
+func TestRunWithoutShell(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	cmd := NewCommand("echo", []string{"$HOME", "*", "it's"})
+	cmd.Shell = NoShell
+	cmd.Stdout = buffer
+	err := cmd.Run()
+	assert.NoError(t, err)
+	// no variable expansion, globbing or quoting without a shell
+	assert.Equal(t, "$HOME * it's\n", buffer.String())
+}
+
+func TestRunWithCustomShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	cmd := NewCommand("echo $0", nil)
+	cmd.Shell = "sh"
+	cmd.Stdout = buffer
+	err := cmd.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, "sh", filepath.Base(strings.TrimSpace(buffer.String())))
+
+	cmd = NewCommand("echo", nil)
+	cmd.Shell = "unknown-shell"
+	err = cmd.Run()
+	assert.EqualError(t, err, "cannot find shell executable (unknown-shell) in path")
+}
+
+func TestRunInWorkingDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	dir, err := ioutil.TempDir("", "TestRunInWorkingDirectory")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buffer := &bytes.Buffer{}
+	cmd := NewCommand("pwd", nil)
+	cmd.Dir = dir
+	cmd.Stdout = buffer
+	err = cmd.Run()
+	assert.NoError(t, err)
+	expected, err := filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, strings.TrimSpace(buffer.String()))
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	// the sleep command is a child of the shell: it should be stopped too
+	cmd := NewCommand("sleep 3; echo finished", nil)
+	cmd.Timeout = 100 * time.Millisecond
+	cmd.Stdout = buffer
+	start := time.Now()
+	err := cmd.Run()
+	assert.EqualError(t, err, "command timed out after 100ms: signal: killed")
+	assert.WithinDuration(t, time.Now(), start, 1*time.Second)
+	assert.Empty(t, buffer.String())
+
+	cmd = NewCommand("echo finished", nil)
+	cmd.Timeout = 2 * time.Second
+	cmd.Stdout = buffer
+	err = cmd.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, "finished\n", buffer.String())
+}
+
 func TestRunShellEcho(t *testing.T) {
 	buffer := &bytes.Buffer{}
 	cmd := NewCommand("echo", []string{"TestRunShellEcho"})
@@ -4,6 +4,7 @@ package shell
 
 import (
 	"os"
+	"os/exec"
 	"syscall"
 )
 
@@ -11,9 +12,24 @@ func (c *Command) propagateSignal(process *os.Process) {
 	select {
 	case <-c.sigChan:
 		// We resend the signal to the child process
+		if c.Timeout > 0 {
+			// the child process is the leader of its own process group
+			_ = syscall.Kill(-process.Pid, syscall.SIGINT)
+			return
+		}
 		process.Signal(syscall.SIGINT)
 		return
 	case <-c.done:
 		return
 	}
 }
+
+// setProcessGroup starts the command in a new process group
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process and all its children from the same process group
+func killProcessGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
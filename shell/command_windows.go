@@ -2,8 +2,19 @@
 
 package shell
 
-import "os"
+import (
+	"os"
+	"os/exec"
+)
 
 // In Windows, all hierarchy will receive the signal (which is good because we cannot send it anyway)
 // In fact, there's nothing for us to do here
 func (c *Command) propagateSignal(*os.Process) {}
+
+// setProcessGroup does nothing on Windows
+func setProcessGroup(*exec.Cmd) {}
+
+// killProcessGroup kills the process (the children are not stopped on Windows)
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
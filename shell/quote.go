@@ -0,0 +1,29 @@
+package shell
+
+import "strings"
+
+// safeCharacters are the characters that don't need quoting in a unix shell
+const safeCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-"
+
+// Quote returns the argument quoted for a unix shell, so the shell would pass it unchanged to the command.
+// The argument is left alone when it contains no special character.
+func Quote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if strings.Trim(arg, safeCharacters) == "" {
+		return arg
+	}
+	// a single quote cannot be escaped inside single quotes: close the quotes, add an escaped quote and reopen them
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// QuoteCommandLine returns the command line equivalent to starting the command with these arguments, as typed in a unix shell
+func QuoteCommandLine(command string, args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, Quote(command))
+	for _, arg := range args {
+		quoted = append(quoted, Quote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,43 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuote(t *testing.T) {
+	testData := []struct {
+		arg    string
+		quoted string
+	}{
+		{"", "''"},
+		{"simple", "simple"},
+		{"--exclude-file=/path/to/file.txt", "--exclude-file=/path/to/file.txt"},
+		{"with space", "'with space'"},
+		{"$HOME", "'$HOME'"},
+		{"`id`", "'`id`'"},
+		{"*.tmp", "'*.tmp'"},
+		{`"double"`, `'"double"'`},
+		{"it's", `'it'\''s'`},
+		{`back\slash`, `'back\slash'`},
+	}
+
+	for _, testItem := range testData {
+		t.Run(testItem.arg, func(t *testing.T) {
+			quoted := Quote(testItem.arg)
+			assert.Equal(t, testItem.quoted, quoted)
+
+			// the shell would give back the original argument
+			args, err := SplitArguments(quoted)
+			require.NoError(t, err)
+			assert.Equal(t, []string{testItem.arg}, args)
+		})
+	}
+}
+
+func TestQuoteCommandLine(t *testing.T) {
+	commandLine := QuoteCommandLine("/usr/local/bin/restic", []string{"backup", "--password-file", "/path/with space/key", "/home/it's mine"})
+	assert.Equal(t, `/usr/local/bin/restic backup --password-file '/path/with space/key' '/home/it'\''s mine'`, commandLine)
+}
@@ -4,15 +4,22 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/resticprofile/shell"
 )
 
+// stderrBufferSize is the size of the end of the error output kept from a command
+const stderrBufferSize = 4096
+
 type shellCommandDefinition struct {
 	command  string
 	args     []string
 	env      []string
+	shell    string
+	dir      string
+	timeout  time.Duration
 	useStdin bool
 	stdout   io.Writer
 	stderr   io.Writer
@@ -44,14 +51,27 @@ func runShellCommand(command shellCommandDefinition) error {
 	var err error
 
 	if command.dryRun {
+		if command.shell == shell.NoShell {
+			// display the equivalent command line, with the arguments quoted as they would be for a shell
+			clog.Infof("dry-run: %s", shell.QuoteCommandLine(command.command, command.args))
+			return nil
+		}
 		clog.Infof("dry-run: %s %s", command.command, strings.Join(command.args, " "))
 		return nil
 	}
 
 	shellCmd := shell.NewSignalledCommand(command.command, command.args, command.sigChan)
 
+	shellCmd.Shell = command.shell
+	shellCmd.Dir = command.dir
+	shellCmd.Timeout = command.timeout
+	// keep the end of the error output, to explain why the command failed
+	stderr := newRingBuffer(stderrBufferSize)
 	shellCmd.Stdout = command.stdout
-	shellCmd.Stderr = command.stderr
+	shellCmd.Stderr = stderr
+	if command.stderr != nil {
+		shellCmd.Stderr = io.MultiWriter(command.stderr, stderr)
+	}
 
 	if command.useStdin {
 		shellCmd.Stdin = os.Stdin
@@ -69,7 +89,29 @@ func runShellCommand(command shellCommandDefinition) error {
 
 	err = shellCmd.Run()
 	if err != nil {
+		if output := stderr.String(); output != "" {
+			return &stderrError{err: err, stderr: output}
+		}
 		return err
 	}
 	return nil
 }
+
+// stderrError is the error of a command, with the end of its error output
+type stderrError struct {
+	err    error
+	stderr string
+}
+
+func (e *stderrError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stderrError) Unwrap() error {
+	return e.err
+}
+
+// Stderr returns the end of the error output of the command
+func (e *stderrError) Stderr() string {
+	return e.stderr
+}
@@ -1,12 +1,21 @@
 package status
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/constants"
+)
 
 // Profile status
 type Profile struct {
 	Backup    *CommandStatus `json:"backup,omitempty"`
 	Retention *CommandStatus `json:"retention,omitempty"`
 	Check     *CommandStatus `json:"check,omitempty"`
+	Copy      *CommandStatus `json:"copy,omitempty"`
+	Prune     *CommandStatus `json:"prune,omitempty"`
+	// Repositories contains the status of each repository, when the profile has more than one
+	Repositories map[string]*Profile `json:"repositories,omitempty"`
 }
 
 func newProfile() *Profile {
@@ -18,55 +27,221 @@ type CommandStatus struct {
 	Success bool      `json:"success"`
 	Time    time.Time `json:"time"`
 	Error   string    `json:"error"`
+	Stderr  string    `json:"stderr,omitempty"`
+	// Attempts is the number of times the command was run, when it had to be retried
+	Attempts int `json:"attempts,omitempty"`
+	// Start and End of the command: Time is the same as End
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Duration of the command in seconds
+	Duration float64 `json:"duration"`
+	// ExitCode of the command, or -1 when it didn't exit by itself (killed by a signal, or not started at all)
+	ExitCode int `json:"exit_code"`
+	// Summary is only available for a backup
+	Summary *BackupSummary `json:"summary,omitempty"`
+}
+
+// BackupSummary contains the statistics of a backup, from the JSON output of restic
+type BackupSummary struct {
+	SnapshotID          string  `json:"snapshot_id"`
+	FilesNew            int     `json:"files_new"`
+	FilesChanged        int     `json:"files_changed"`
+	FilesUnmodified     int     `json:"files_unmodified"`
+	DirsNew             int     `json:"dirs_new"`
+	DirsChanged         int     `json:"dirs_changed"`
+	DirsUnmodified      int     `json:"dirs_unmodified"`
+	DataAdded           uint64  `json:"data_added"`
+	TotalFilesProcessed int     `json:"total_files_processed"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+}
+
+// AddSummary adds the statistics of the backup of another repository: the snapshot IDs are listed
+// and the numbers are added together
+func (s *BackupSummary) AddSummary(other *BackupSummary) {
+	if other == nil {
+		return
+	}
+	if s.SnapshotID == "" {
+		s.SnapshotID = other.SnapshotID
+	} else if other.SnapshotID != "" {
+		s.SnapshotID += " " + other.SnapshotID
+	}
+	s.FilesNew += other.FilesNew
+	s.FilesChanged += other.FilesChanged
+	s.FilesUnmodified += other.FilesUnmodified
+	s.DirsNew += other.DirsNew
+	s.DirsChanged += other.DirsChanged
+	s.DirsUnmodified += other.DirsUnmodified
+	s.DataAdded += other.DataAdded
+	s.TotalFilesProcessed += other.TotalFilesProcessed
+	s.TotalBytesProcessed += other.TotalBytesProcessed
+	s.TotalDuration += other.TotalDuration
+}
+
+// Run contains the details of a command run, to record in its status
+type Run struct {
+	Start time.Time
+	// Attempts is the number of times the command was run
+	Attempts int
+	Summary  *BackupSummary
+}
+
+// Repository gets the status of a repository from its name (it creates a blank new one if not exists)
+func (p *Profile) Repository(name string) *Profile {
+	if repository, ok := p.Repositories[name]; ok {
+		return repository
+	}
+	if p.Repositories == nil {
+		p.Repositories = make(map[string]*Profile)
+	}
+	repository := newProfile()
+	p.Repositories[name] = repository
+	return repository
+}
+
+// CommandSuccess records the successful run of the command (backup, check, retention, copy or prune).
+// It returns nil when the status of this command is not recorded
+func (p *Profile) CommandSuccess(command string, run Run) *CommandStatus {
+	return p.SetCommandStatus(command, NewCommandSuccess(run))
+}
+
+// CommandError records the failed run of the command (backup, check, retention, copy or prune).
+// It returns nil when the status of this command is not recorded
+func (p *Profile) CommandError(command string, run Run, err error) *CommandStatus {
+	return p.SetCommandStatus(command, NewCommandError(run, err))
+}
+
+// SetCommandStatus records the status of the command (backup, check, retention, copy or prune).
+// It returns nil when the status of this command is not recorded
+func (p *Profile) SetCommandStatus(command string, status *CommandStatus) *CommandStatus {
+	switch command {
+	case constants.CommandBackup:
+		p.Backup = status
+	case constants.CommandCheck:
+		p.Check = status
+	case constants.SectionConfigurationRetention, constants.CommandForget:
+		p.Retention = status
+	case constants.CommandCopy:
+		p.Copy = status
+	case constants.CommandPrune:
+		p.Prune = status
+	default:
+		return nil
+	}
+	return status
+}
+
+// GetCommandStatus returns the last status recorded for the command, or nil when there's none
+func (p *Profile) GetCommandStatus(command string) *CommandStatus {
+	switch command {
+	case constants.CommandBackup:
+		return p.Backup
+	case constants.CommandCheck:
+		return p.Check
+	case constants.SectionConfigurationRetention, constants.CommandForget:
+		return p.Retention
+	case constants.CommandCopy:
+		return p.Copy
+	case constants.CommandPrune:
+		return p.Prune
+	default:
+		return nil
+	}
 }
 
 // BackupSuccess indicates the last backup was successful
 func (p *Profile) BackupSuccess() *Profile {
-	p.Backup = newSuccess()
+	p.Backup = NewCommandSuccess(Run{})
 	return p
 }
 
 // BackupError sets the error of the last backup
 func (p *Profile) BackupError(err error) *Profile {
-	p.Backup = newError(err)
+	p.Backup = NewCommandError(Run{}, err)
 	return p
 }
 
 // RetentionSuccess indicates the last retention was successful
 func (p *Profile) RetentionSuccess() *Profile {
-	p.Retention = newSuccess()
+	p.Retention = NewCommandSuccess(Run{})
 	return p
 }
 
 // RetentionError sets the error of the last retention
 func (p *Profile) RetentionError(err error) *Profile {
-	p.Retention = newError(err)
+	p.Retention = NewCommandError(Run{}, err)
 	return p
 }
 
 // CheckSuccess indicates the last check was successful
 func (p *Profile) CheckSuccess() *Profile {
-	p.Check = newSuccess()
+	p.Check = NewCommandSuccess(Run{})
 	return p
 }
 
 // CheckError sets the error of the last check
 func (p *Profile) CheckError(err error) *Profile {
-	p.Check = newError(err)
+	p.Check = NewCommandError(Run{}, err)
 	return p
 }
 
-func newSuccess() *CommandStatus {
-	return &CommandStatus{
-		Success: true,
-		Time:    time.Now(),
+// CopySuccess indicates the last copy was successful
+func (p *Profile) CopySuccess() *Profile {
+	p.Copy = NewCommandSuccess(Run{})
+	return p
+}
+
+// CopyError sets the error of the last copy
+func (p *Profile) CopyError(err error) *Profile {
+	p.Copy = NewCommandError(Run{}, err)
+	return p
+}
+
+// NewCommandSuccess returns the status of a successful run
+func NewCommandSuccess(run Run) *CommandStatus {
+	status := newCommandStatus(run)
+	status.Success = true
+	return status
+}
+
+// NewCommandError returns the status of a failed run, with the details of the error when available
+func NewCommandError(run Run, err error) *CommandStatus {
+	status := newCommandStatus(run)
+	status.Error = err.Error()
+	status.ExitCode = -1
+	var exitError interface{ ExitCode() int }
+	if errors.As(err, &exitError) {
+		status.ExitCode = exitError.ExitCode()
 	}
+	// keep the error output of the command when available
+	var stderr interface{ Stderr() string }
+	if errors.As(err, &stderr) {
+		status.Stderr = stderr.Stderr()
+	}
+	// and the number of attempts when the command was retried
+	var retried interface{ Attempts() int }
+	if errors.As(err, &retried) {
+		status.Attempts = retried.Attempts()
+	}
+	return status
 }
 
-func newError(err error) *CommandStatus {
-	return &CommandStatus{
-		Success: false,
-		Time:    time.Now(),
-		Error:   err.Error(),
+func newCommandStatus(run Run) *CommandStatus {
+	end := time.Now()
+	start := run.Start
+	if start.IsZero() {
+		start = end
+	}
+	status := &CommandStatus{
+		Time:     end,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start).Seconds(),
+		Summary:  run.Summary,
+	}
+	if run.Attempts > 1 {
+		status.Attempts = run.Attempts
 	}
+	return status
 }
@@ -2,11 +2,15 @@ package status
 
 import (
 	"encoding/json"
-	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/spf13/afero"
 )
 
+// fileMutex prevents the profiles running in parallel from updating the same file at the same time
+var fileMutex sync.Mutex
+
 // Status of last schedule profile
 type Status struct {
 	fs       afero.Fs
@@ -55,16 +59,39 @@ func (s *Status) Profile(name string) *Profile {
 	return profile
 }
 
-// Save current status to the file
+// Update loads the status file, changes it and saves it back: the profiles running in parallel
+// can safely share the same file. The file is not saved when update returns false
+func (s *Status) Update(update func(status *Status) bool) error {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if !update(s.Load()) {
+		return nil
+	}
+	return s.Save()
+}
+
+// Save current status to the file. The file is replaced at once, so it's never read half written
 func (s *Status) Save() error {
-	file, err := s.fs.OpenFile(s.filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	dir := filepath.Dir(s.filename)
+	file, err := afero.TempFile(s.fs, dir, filepath.Base(s.filename)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tempName := file.Name()
 	encoder := json.NewEncoder(file)
 	err = encoder.Encode(s)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.fs.Chmod(tempName, 0644)
+	}
+	if err == nil {
+		err = s.fs.Rename(tempName, s.filename)
+	}
 	if err != nil {
+		_ = s.fs.Remove(tempName)
 		return err
 	}
 	return nil
@@ -2,10 +2,16 @@ package status
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadNoFile(t *testing.T) {
@@ -72,6 +78,154 @@ func TestCheckError(t *testing.T) {
 	assert.Equal(t, errorMessage, status.Profile(profileName).Check.Error)
 }
 
+func TestCopySuccess(t *testing.T) {
+	profileName := "test profile"
+	status := NewStatus("")
+	assert.Nil(t, status.Profile(profileName).Copy)
+	status.Profile(profileName).CopySuccess()
+	assert.True(t, status.Profile(profileName).Copy.Success)
+	assert.Empty(t, status.Profile(profileName).Copy.Error)
+}
+
+func TestCopyError(t *testing.T) {
+	errorMessage := "test test test"
+	profileName := "test profile"
+	status := NewStatus("")
+	assert.Nil(t, status.Profile(profileName).Copy)
+	status.Profile(profileName).CopyError(errors.New(errorMessage))
+	assert.False(t, status.Profile(profileName).Copy.Success)
+	assert.Equal(t, errorMessage, status.Profile(profileName).Copy.Error)
+}
+
+func TestGetCommandStatus(t *testing.T) {
+	profile := NewStatus("").Profile("test profile")
+	for _, command := range []string{"backup", "check", "forget", "copy", "prune"} {
+		assert.Nil(t, profile.GetCommandStatus(command))
+		commandStatus := profile.CommandSuccess(command, Run{})
+		assert.Same(t, commandStatus, profile.GetCommandStatus(command))
+	}
+	// retention and forget share the same status
+	assert.Same(t, profile.Retention, profile.GetCommandStatus("retention"))
+	assert.Nil(t, profile.GetCommandStatus("snapshots"))
+}
+
+func TestRepositoryStatus(t *testing.T) {
+	profileName := "test profile"
+	status := NewStatus("")
+	assert.Empty(t, status.Profile(profileName).Repositories)
+	status.Profile(profileName).Repository("local").BackupSuccess()
+	status.Profile(profileName).Repository("remote").BackupError(errors.New("test"))
+	assert.Nil(t, status.Profile(profileName).Backup)
+	assert.Len(t, status.Profile(profileName).Repositories, 2)
+	assert.True(t, status.Profile(profileName).Repository("local").Backup.Success)
+	assert.False(t, status.Profile(profileName).Repository("remote").Backup.Success)
+}
+
+func TestAddSummary(t *testing.T) {
+	summary := &BackupSummary{}
+	summary.AddSummary(&BackupSummary{SnapshotID: "1234abcd", FilesNew: 2, DataAdded: 1024, TotalDuration: 1.5})
+	summary.AddSummary(nil)
+	summary.AddSummary(&BackupSummary{SnapshotID: "5678efgh", FilesNew: 3, DataAdded: 2048, TotalDuration: 2})
+	assert.Equal(t, &BackupSummary{SnapshotID: "1234abcd 5678efgh", FilesNew: 5, DataAdded: 3072, TotalDuration: 3.5}, summary)
+}
+
+type testStderrError struct{}
+
+func (e testStderrError) Error() string {
+	return "exit status 1"
+}
+
+func (e testStderrError) Stderr() string {
+	return "Fatal: wrong password or no key found"
+}
+
+func TestErrorWithStderr(t *testing.T) {
+	profileName := "test profile"
+	status := NewStatus("")
+	status.Profile(profileName).BackupError(fmt.Errorf("backup failed: %w", testStderrError{}))
+	assert.False(t, status.Profile(profileName).Backup.Success)
+	assert.Equal(t, "backup failed: exit status 1", status.Profile(profileName).Backup.Error)
+	assert.Equal(t, "Fatal: wrong password or no key found", status.Profile(profileName).Backup.Stderr)
+
+	status.Profile(profileName).CheckError(errors.New("exit status 1"))
+	assert.Empty(t, status.Profile(profileName).Check.Stderr)
+}
+
+type testRetryError struct{}
+
+func (e testRetryError) Error() string {
+	return "exit status 1 (after 3 attempts)"
+}
+
+func (e testRetryError) Attempts() int {
+	return 3
+}
+
+func TestErrorWithAttempts(t *testing.T) {
+	profileName := "test profile"
+	status := NewStatus("")
+	status.Profile(profileName).BackupError(fmt.Errorf("backup failed: %w", testRetryError{}))
+	assert.False(t, status.Profile(profileName).Backup.Success)
+	assert.Equal(t, 3, status.Profile(profileName).Backup.Attempts)
+
+	status.Profile(profileName).BackupSuccess()
+	assert.Equal(t, 0, status.Profile(profileName).Backup.Attempts)
+}
+
+func TestCommandSuccess(t *testing.T) {
+	profileName := "test profile"
+	status := NewStatus("")
+	start := time.Now().Add(-2 * time.Second)
+	summary := &BackupSummary{SnapshotID: "abcd", FilesNew: 2, DataAdded: 1024}
+	commandStatus := status.Profile(profileName).CommandSuccess("backup", Run{Start: start, Attempts: 2, Summary: summary})
+	require.NotNil(t, commandStatus)
+	assert.Same(t, commandStatus, status.Profile(profileName).Backup)
+	assert.True(t, commandStatus.Success)
+	assert.Equal(t, start, commandStatus.Start)
+	assert.Equal(t, commandStatus.Time, commandStatus.End)
+	assert.InDelta(t, 2.0, commandStatus.Duration, 1.0)
+	assert.Equal(t, 0, commandStatus.ExitCode)
+	assert.Equal(t, 2, commandStatus.Attempts)
+	assert.Equal(t, summary, commandStatus.Summary)
+
+	for command, field := range map[string]**CommandStatus{
+		"check":     &status.Profile(profileName).Check,
+		"forget":    &status.Profile(profileName).Retention,
+		"retention": &status.Profile(profileName).Retention,
+		"copy":      &status.Profile(profileName).Copy,
+		"prune":     &status.Profile(profileName).Prune,
+	} {
+		commandStatus = status.Profile(profileName).CommandSuccess(command, Run{Start: start})
+		assert.Same(t, commandStatus, *field, command)
+	}
+	assert.Nil(t, status.Profile(profileName).CommandSuccess("snapshots", Run{Start: start}))
+}
+
+type testExitError struct{}
+
+func (e testExitError) Error() string {
+	return "exit status 3"
+}
+
+func (e testExitError) ExitCode() int {
+	return 3
+}
+
+func TestCommandErrorExitCode(t *testing.T) {
+	profileName := "test profile"
+	status := NewStatus("")
+	commandStatus := status.Profile(profileName).CommandError("check", Run{Start: time.Now()}, fmt.Errorf("check failed: %w", testExitError{}))
+	require.NotNil(t, commandStatus)
+	assert.False(t, commandStatus.Success)
+	assert.Equal(t, 3, commandStatus.ExitCode)
+
+	// the command never started
+	commandStatus = status.Profile(profileName).CommandError("check", Run{}, errors.New("executable file not found"))
+	assert.Equal(t, -1, commandStatus.ExitCode)
+	assert.Equal(t, commandStatus.Start, commandStatus.End)
+	assert.Equal(t, 0.0, commandStatus.Duration)
+}
+
 func TestSaveAndLoadEmptyStatus(t *testing.T) {
 	filename := "TestSaveAndLoadEmptyStatus.json"
 
@@ -194,3 +348,47 @@ func TestAddSuccessAfterError(t *testing.T) {
 	assert.True(t, profile.Backup.Success)
 	assert.Empty(t, profile.Backup.Error)
 }
+
+func TestUpdateInParallel(t *testing.T) {
+	filename := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestUpdateInParallel", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(filename)
+
+	profiles := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	wg := sync.WaitGroup{}
+	for _, profileName := range profiles {
+		wg.Add(1)
+		go func(profileName string) {
+			defer wg.Done()
+			err := NewStatus(filename).Update(func(status *Status) bool {
+				status.Profile(profileName).BackupSuccess()
+				return true
+			})
+			assert.NoError(t, err)
+		}(profileName)
+	}
+	wg.Wait()
+
+	status := NewStatus(filename).Load()
+	assert.Len(t, status.Profiles, len(profiles))
+	for _, profileName := range profiles {
+		require.NotNil(t, status.Profile(profileName).Backup)
+		assert.True(t, status.Profile(profileName).Backup.Success)
+	}
+
+	// no temporary file left behind
+	files, err := filepath.Glob(filename + ".*.tmp")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestUpdateWithoutChange(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	status := newAferoStatus(fs, "status.json")
+	err := status.Update(func(status *Status) bool {
+		return false
+	})
+	require.NoError(t, err)
+	exists, err := afero.Exists(fs, "status.json")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,72 @@
+package term
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixMutex prevents the lines of the prefix writers from being mixed up when they write to the same output
+var prefixMutex sync.Mutex
+
+// PrefixWriter adds a prefix to each line written to the output.
+// An incomplete line is kept until the end of the line is written (or the writer is flushed)
+type PrefixWriter struct {
+	output io.Writer
+	prefix []byte
+	buffer []byte
+	lock   sync.Mutex
+}
+
+// NewPrefixWriter creates a writer adding the prefix in front of each line sent to the output
+func NewPrefixWriter(output io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{
+		output: output,
+		prefix: []byte(prefix),
+	}
+}
+
+// Write sends all the complete lines to the output, each one starting with the prefix
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buffer = append(w.buffer, p...)
+	end := bytes.LastIndexByte(w.buffer, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	err := w.writeLines(w.buffer[:end+1])
+	w.buffer = append(w.buffer[:0], w.buffer[end+1:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush sends the incomplete line left in the buffer, if any
+func (w *PrefixWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	err := w.writeLines(append(w.buffer, '\n'))
+	w.buffer = w.buffer[:0]
+	return err
+}
+
+func (w *PrefixWriter) writeLines(lines []byte) error {
+	output := make([]byte, 0, len(lines)+len(w.prefix)*(bytes.Count(lines, []byte{'\n'})))
+	for len(lines) > 0 {
+		end := bytes.IndexByte(lines, '\n') + 1
+		output = append(output, w.prefix...)
+		output = append(output, lines[:end]...)
+		lines = lines[end:]
+	}
+	prefixMutex.Lock()
+	defer prefixMutex.Unlock()
+	_, err := w.output.Write(output)
+	return err
+}
@@ -0,0 +1,55 @@
+package term
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	output := &bytes.Buffer{}
+	writer := NewPrefixWriter(output, "[name] ")
+
+	_, err := writer.Write([]byte("first line\nsecond"))
+	require.NoError(t, err)
+	assert.Equal(t, "[name] first line\n", output.String())
+
+	_, err = writer.Write([]byte(" line\n\nfourth"))
+	require.NoError(t, err)
+	assert.Equal(t, "[name] first line\n[name] second line\n[name] \n", output.String())
+
+	require.NoError(t, writer.Flush())
+	assert.Equal(t, "[name] first line\n[name] second line\n[name] \n[name] fourth\n", output.String())
+
+	require.NoError(t, writer.Flush())
+	assert.Equal(t, "[name] first line\n[name] second line\n[name] \n[name] fourth\n", output.String())
+}
+
+func TestPrefixWritersOnSameOutput(t *testing.T) {
+	output := &bytes.Buffer{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(prefix string) {
+			defer wg.Done()
+			writer := NewPrefixWriter(output, prefix)
+			for j := 0; j < 100; j++ {
+				// write each line in two parts
+				fmt.Fprint(writer, "line ")
+				fmt.Fprintf(writer, "%d\n", j)
+			}
+		}(fmt.Sprintf("[%d] ", i))
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	assert.Len(t, lines, 400)
+	for _, line := range lines {
+		assert.Regexp(t, `^\[\d\] line \d+$`, line)
+	}
+}
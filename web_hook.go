@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/status"
+)
+
+// webHookData is the data available in the body template of a web hook, and in the subject and body of an email
+type webHookData struct {
+	ProfileName      string
+	Command          string
+	Error            string
+	ErrorCommandLine string
+	Stderr           string
+	Stats            status.CommandStatus // last run of the command (empty before the command has run)
+}
+
+// sendWebHooks sends the HTTP requests of the configuration (send-before, send-after, send-after-fail).
+// A failure is only logged: it doesn't change the result of the profile
+func (r *resticWrapper) sendWebHooks(name string, hooks config.WebHooks, fail error) {
+	if len(hooks) == 0 {
+		return
+	}
+	data := r.getWebHookData(fail)
+	for i, hook := range hooks {
+		clog.Debugf("sending '%s' web hook %d/%d", name, i+1, len(hooks))
+		err := r.sendWebHook(hook, data)
+		if err != nil {
+			clog.Warningf("profile '%s': %s to %s: %v", r.profile.Name, name, hook.URL, err)
+		}
+	}
+}
+
+// sendWebHook sends one HTTP request. Without a method, the request is a GET, or a POST when it has a body
+func (r *resticWrapper) sendWebHook(hook config.WebHook, data webHookData) error {
+	body, err := getWebHookBody(hook, data)
+	if err != nil {
+		return err
+	}
+	method := strings.ToUpper(hook.Method)
+	if method == "" {
+		method = http.MethodGet
+		if body != "" {
+			method = http.MethodPost
+		}
+	}
+	if r.dryRun {
+		clog.Infof("dry-run: %s %s", method, hook.URL)
+		return nil
+	}
+
+	request, err := http.NewRequest(method, hook.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", "resticprofile/"+version)
+	for key, value := range hook.Headers {
+		request.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: hook.Timeout}
+	if client.Timeout == 0 {
+		client.Timeout = constants.DefaultSendTimeout
+	}
+	if hook.SkipTLSVerification {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = transport
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		content, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("unexpected status %q: %s", response.Status, strings.TrimSpace(string(content)))
+	}
+	return nil
+}
+
+// getWebHookData returns the details of the profile and of the last run of the command
+func (r *resticWrapper) getWebHookData(fail error) webHookData {
+	data := webHookData{
+		ProfileName: r.profile.Name,
+		Command:     r.command,
+	}
+	if r.lastStatus != nil {
+		data.Stats = *r.lastStatus
+	}
+	if fail == nil {
+		return data
+	}
+	data.Error = fail.Error()
+	var failedCommand *commandError
+	if errors.As(fail, &failedCommand) {
+		data.ErrorCommandLine = failedCommand.Commandline()
+	}
+	var failedOutput *stderrError
+	if errors.As(fail, &failedOutput) {
+		data.Stderr = failedOutput.Stderr()
+	}
+	return data
+}
+
+// getWebHookBody generates the body of the request from its template (body or body-template)
+func getWebHookBody(hook config.WebHook, data webHookData) (string, error) {
+	source, err := loadTemplate(hook.Body, hook.BodyTemplate)
+	if err != nil {
+		return "", err
+	}
+	body, err := renderTemplate(source, data)
+	if err != nil {
+		return "", fmt.Errorf("invalid body template: %w", err)
+	}
+	return body, nil
+}
+
+// loadTemplate returns the content of the template file when set, or the inline template otherwise
+func loadTemplate(inline, filename string) (string, error) {
+	if filename == "" {
+		return inline, nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("cannot load body template: %w", err)
+	}
+	return string(content), nil
+}
+
+// renderTemplate executes the template with the details of the profile and of the last run of the command
+func renderTemplate(source string, data webHookData) (string, error) {
+	if source == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	buffer := &bytes.Buffer{}
+	err = tmpl.Execute(buffer, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetWebHookBody(t *testing.T) {
+	data := webHookData{
+		ProfileName: "home",
+		Command:     "backup",
+		Stats: status.CommandStatus{
+			Success:  true,
+			Duration: 12.5,
+			Summary:  &status.BackupSummary{FilesNew: 2, DataAdded: 1024},
+		},
+	}
+	body, err := getWebHookBody(config.WebHook{Body: "{{ .ProfileName }}/{{ .Command }}: {{ .Stats.Duration }}s{{ with .Stats.Summary }}, {{ .FilesNew }} new files{{ end }}"}, data)
+	require.NoError(t, err)
+	assert.Equal(t, "home/backup: 12.5s, 2 new files", body)
+
+	body, err = getWebHookBody(config.WebHook{}, data)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	_, err = getWebHookBody(config.WebHook{Body: "{{ .Unknown }}"}, data)
+	assert.Error(t, err)
+
+	_, err = getWebHookBody(config.WebHook{BodyTemplate: filepath.Join(os.TempDir(), "does-not-exist.tmpl")}, data)
+	assert.Error(t, err)
+}
+
+func TestGetWebHookBodyFromFile(t *testing.T) {
+	filename := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.tmpl", "TestGetWebHookBodyFromFile", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(filename)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"profile": "{{ .ProfileName }}", "error": "{{ .Error }}"}`), 0600))
+
+	// the file takes precedence over the body
+	body, err := getWebHookBody(config.WebHook{Body: "ignored", BodyTemplate: filename}, webHookData{ProfileName: "home", Error: "failed"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"profile": "home", "error": "failed"}`, body)
+}
+
+func TestSendWebHookHeadersAndTLS(t *testing.T) {
+	var authorization, userAgent string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, userAgent = r.Header.Get("Authorization"), r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	wrapper := newResticWrapper("echo", false, false, config.NewProfile(nil, "name"), "backup", nil, nil)
+	hook := config.WebHook{URL: server.URL, Headers: map[string]string{"authorization": "Bearer token"}, Timeout: time.Second}
+
+	// self-signed certificate
+	err := wrapper.sendWebHook(hook, webHookData{})
+	assert.Error(t, err)
+
+	hook.SkipTLSVerification = true
+	err = wrapper.sendWebHook(hook, webHookData{})
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", authorization)
+	assert.Equal(t, "resticprofile/"+version, userAgent)
+}
@@ -1,20 +1,32 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/resticprofile/config"
 	"github.com/creativeprojects/resticprofile/constants"
 	"github.com/creativeprojects/resticprofile/lock"
+	"github.com/creativeprojects/resticprofile/prom"
+	"github.com/creativeprojects/resticprofile/shell"
 	"github.com/creativeprojects/resticprofile/status"
 	"github.com/creativeprojects/resticprofile/term"
 )
 
+var (
+	// lockPollInterval is the delay between two attempts to acquire a lock already taken
+	lockPollInterval = time.Second
+	// lockWaitLogInterval is the delay between two messages when waiting for a lock
+	lockWaitLogInterval = time.Minute
+)
+
 type commandError struct {
 	scd shellCommandDefinition
 	err error
@@ -28,6 +40,10 @@ func (c *commandError) Error() string {
 	return c.err.Error()
 }
 
+func (c *commandError) Unwrap() error {
+	return c.err
+}
+
 func (c *commandError) Commandline() string {
 	args := ""
 	if c.scd.args != nil && len(c.scd.args) > 0 {
@@ -38,13 +54,20 @@ func (c *commandError) Commandline() string {
 
 type resticWrapper struct {
 	resticBinary string
+	resticShell  string
 	initialize   bool
 	dryRun       bool
 	profile      *config.Profile
+	repository   string
 	command      string
 	moreArgs     []string
 	sigChan      chan os.Signal
 	setPID       func(pid int)
+	stdout       io.Writer                // output of the commands, instead of the terminal
+	stderr       io.Writer                // error output of the commands, instead of the terminal
+	metrics      map[string]*prom.Metrics // metrics of each command, pushed at the end of the profile
+	lastStatus   *status.CommandStatus    // last run of the command of the profile, for the web hooks
+	prevStatus   *status.CommandStatus    // run of the command before this one (from the status file), for the email
 }
 
 func newResticWrapper(
@@ -64,98 +87,189 @@ func newResticWrapper(
 		command:      command,
 		moreArgs:     moreArgs,
 		sigChan:      c,
+		metrics:      make(map[string]*prom.Metrics),
 	}
 }
 
 func (r *resticWrapper) runProfile() error {
-	err := lockRun(r.profile.Lock, r.profile.ForceLock, func(setPID lock.SetPID) error {
+	err := lockRun(r.newLock(), r.profile.ForceLock, r.profile.LockWait, r.sigChan, func(setPID lock.SetPID) error {
 		r.setPID = setPID
-		return runOnFailure(
+		err := runOnFailure(
 			func() error {
 				var err error
 
-				// pre-profile commands
+				// pre-profile web hooks and commands
+				r.sendWebHooks("send-before", r.profile.SendBefore, nil)
 				err = r.runProfilePreCommand()
 				if err != nil {
 					return err
 				}
 
-				// breaking change from 0.7.0 and 0.7.1:
-				// run the initialization after the pre-profile commands
-				if r.initialize && r.command != constants.CommandInit {
-					_ = r.runInitialize()
-					// it's ok for the initialize to error out when the repository exists
-				}
-
-				// pre-commands (for backup)
-				if r.command == constants.CommandBackup {
-					// Shell commands
-					err = r.runPreCommand(r.command)
-					if err != nil {
-						return err
-					}
-					// Check
-					if r.profile.Backup != nil && r.profile.Backup.CheckBefore {
-						err = r.runCheck()
-						if err != nil {
-							return err
-						}
-					}
-					// Retention
-					if r.profile.Retention != nil && r.profile.Retention.BeforeBackup {
-						err = r.runRetention()
-						if err != nil {
-							return err
-						}
-					}
+				// pre-commands from the command section
+				r.sendWebHooks("send-before "+r.command, r.profile.GetRunShellCommandsSection(r.command).SendBefore, nil)
+				err = r.runPreCommand(r.command)
+				if err != nil {
+					return err
 				}
 
-				// Main command
-				err = r.runCommand(r.command)
+				// restic commands, on each repository of the profile
+				err = r.runRepositories()
 				if err != nil {
 					return err
 				}
 
-				// post-commands (for backup)
-				if r.command == constants.CommandBackup {
-					// Retention
-					if r.profile.Retention != nil && r.profile.Retention.AfterBackup {
-						err = r.runRetention()
-						if err != nil {
-							return err
-						}
-					}
-					// Check
-					if r.profile.Backup != nil && r.profile.Backup.CheckAfter {
-						err = r.runCheck()
-						if err != nil {
-							return err
-						}
-					}
-					// Shell commands
-					err = r.runPostCommand(r.command)
-					if err != nil {
-						return err
-					}
+				// post-commands from the command section
+				err = r.runPostCommand(r.command)
+				if err != nil {
+					return err
 				}
+				r.sendWebHooks("send-after "+r.command, r.profile.GetRunShellCommandsSection(r.command).SendAfter, nil)
 
 				// post-profile commands
 				err = r.runProfilePostCommand()
 				if err != nil {
 					return err
 				}
+				r.sendWebHooks("send-after", r.profile.SendAfter, nil)
 
 				return nil
 			},
 			// on failure
 			func(err error) {
+				_ = r.runPostFailCommand(r.command, err)
+				r.sendWebHooks("send-after-fail "+r.command, r.profile.GetRunShellCommandsSection(r.command).SendAfterFail, err)
 				_ = r.runProfilePostFailCommand(err)
+				r.sendWebHooks("send-after-fail", r.profile.SendAfterFail, err)
 			},
 		)
+		// cleanup commands, running after a success or a failure
+		r.runFinalCommand(err)
+		r.sendEmail(err)
+		return err
 	})
+	r.pushMetrics()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// runRepositories runs the restic commands on each repository of the profile,
+// following the failure policy of the profile when one of them fails
+func (r *resticWrapper) runRepositories() error {
+	repositories := r.profile.GetRepositories()
+	if len(repositories) == 0 {
+		return r.runResticCommands()
+	}
+	run := status.Run{Start: time.Now()}
+	failed := make([]string, 0, len(repositories))
+	var lastErr error
+	for i, name := range repositories {
+		clog.Infof("profile '%s': using repository '%s' (%d/%d)", r.profile.Name, name, i+1, len(repositories))
+		repository := r.forRepository(name)
+		err := repository.runResticCommands()
+		if err == nil {
+			// the statistics of the profile are the sum of the statistics of each repository
+			if repository.lastStatus != nil && repository.lastStatus.Summary != nil {
+				if run.Summary == nil {
+					run.Summary = &status.BackupSummary{}
+				}
+				run.Summary.AddSummary(repository.lastStatus.Summary)
+			}
+			continue
+		}
+		failed = append(failed, name)
+		lastErr = err
+		if r.profile.RepoFailure != constants.RepositoryFailureContinue && r.profile.RepoFailure != constants.RepositoryFailureIgnore {
+			// stop at the first failure
+			break
+		}
+		clog.Error(err)
+	}
+	if len(failed) == 0 {
+		r.statusSuccess(r.command, run)
+		return nil
+	}
+	if r.profile.RepoFailure == constants.RepositoryFailureIgnore && len(failed) < len(repositories) {
+		clog.Warningf("profile '%s': ignoring the failure on repositories %s", r.profile.Name, strings.Join(failed, ", "))
+		r.statusSuccess(r.command, run)
+		return nil
+	}
+	err := lastErr
+	if len(failed) > 1 {
+		err = fmt.Errorf("%s on profile '%s' failed on repositories %s: %w", r.command, r.profile.Name, strings.Join(failed, ", "), lastErr)
+	}
+	r.statusError(r.command, run, err)
+	return err
+}
+
+// forRepository returns a copy of the wrapper running the restic commands on this repository
+func (r *resticWrapper) forRepository(name string) *resticWrapper {
+	wrapper := *r
+	wrapper.profile = r.profile.WithRepository(name)
+	wrapper.repository = name
+	return &wrapper
+}
+
+// runResticCommands runs the restic command, with the initialization, check, retention and copy when configured
+func (r *resticWrapper) runResticCommands() error {
+	var err error
+
+	// breaking change from 0.7.0 and 0.7.1:
+	// run the initialization after the pre-profile commands
+	if r.initialize && r.command != constants.CommandInit {
+		_ = r.runInitialize()
+		// it's ok for the initialize to error out when the repository exists
+	}
+
+	// pre-commands (for backup)
+	if r.command == constants.CommandBackup {
+		// Check
+		if r.profile.Backup != nil && r.profile.Backup.CheckBefore {
+			err = r.runCheck()
+			if err != nil {
+				return err
+			}
+		}
+		// Retention
+		if r.profile.Retention != nil && r.profile.Retention.BeforeBackup {
+			err = r.runRetention()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Main command
+	err = r.runCommand(r.command)
 	if err != nil {
 		return err
 	}
+
+	// post-commands (for backup)
+	if r.command == constants.CommandBackup {
+		// Retention
+		if r.profile.Retention != nil && r.profile.Retention.AfterBackup {
+			err = r.runRetention()
+			if err != nil {
+				return err
+			}
+		}
+		// Copy
+		if r.profile.Copy != nil && r.profile.Copy.AfterBackup {
+			err = r.runCopy()
+			if err != nil {
+				return err
+			}
+		}
+		// Check
+		if r.profile.Backup != nil && r.profile.Backup.CheckAfter {
+			err = r.runCheck()
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -169,16 +283,21 @@ func (r *resticWrapper) prepareCommand(command string, args []string) shellComma
 
 	// Special case for backup command
 	if command == constants.CommandBackup {
-		arguments = append(arguments, r.profile.GetBackupSource()...)
+		source := r.profile.GetBackupSource()
+		if r.resticShell == shell.NoShell {
+			// there's no shell to expand the source for us
+			source = expandGlobs(source)
+		}
+		arguments = append(arguments, source...)
 	}
 
 	env := append(os.Environ(), r.getEnvironment()...)
 
 	clog.Debugf("starting command: %s %s", r.resticBinary, strings.Join(arguments, " "))
 	rCommand := newShellCommand(r.resticBinary, arguments, env, r.dryRun, r.sigChan, r.setPID)
-	// stdout are stderr are coming from the default terminal (in case they're redirected)
-	rCommand.stdout = term.GetOutput()
-	rCommand.stderr = term.GetErrorOutput()
+	rCommand.shell = r.resticShell
+	rCommand.stdout = r.getStdout()
+	rCommand.stderr = r.getStderr()
 
 	if command == constants.CommandBackup && r.profile.Backup != nil && r.profile.Backup.UseStdin {
 		clog.Debug("redirecting stdin to the backup")
@@ -189,7 +308,7 @@ func (r *resticWrapper) prepareCommand(command string, args []string) shellComma
 
 func (r *resticWrapper) runInitialize() error {
 	clog.Infof("profile '%s': initializing repository (if not existing)", r.profile.Name)
-	args := convertIntoArgs(r.profile.GetCommandFlags(constants.CommandInit))
+	args := r.commandArgs(r.profile.GetCommandFlags(constants.CommandInit))
 	rCommand := r.prepareCommand(constants.CommandInit, args)
 	// don't display any error
 	rCommand.stderr = nil
@@ -202,89 +321,129 @@ func (r *resticWrapper) runInitialize() error {
 
 func (r *resticWrapper) runCheck() error {
 	clog.Infof("profile '%s': checking repository consistency", r.profile.Name)
-	args := convertIntoArgs(r.profile.GetCommandFlags(constants.CommandCheck))
+	args := r.commandArgs(r.profile.GetCommandFlags(constants.CommandCheck))
 	rCommand := r.prepareCommand(constants.CommandCheck, args)
+	run := status.Run{Start: time.Now()}
 	err := runShellCommand(rCommand)
 	if err != nil {
-		r.statusError(constants.CommandCheck, err)
+		r.statusError(constants.CommandCheck, run, err)
 		return newCommandError(rCommand, fmt.Errorf("backup check on profile '%s': %w", r.profile.Name, err))
 	}
-	r.statusSuccess(constants.CommandCheck)
+	r.statusSuccess(constants.CommandCheck, run)
 	return nil
 }
 
 func (r *resticWrapper) runRetention() error {
 	clog.Infof("profile '%s': cleaning up repository using retention information", r.profile.Name)
-	args := convertIntoArgs(r.profile.GetRetentionFlags())
+	args := r.commandArgs(r.profile.GetRetentionFlags())
 	rCommand := r.prepareCommand(constants.CommandForget, args)
+	run := status.Run{Start: time.Now()}
 	err := runShellCommand(rCommand)
 	if err != nil {
-		r.statusError(constants.SectionConfigurationRetention, err)
+		r.statusError(constants.SectionConfigurationRetention, run, err)
 		return newCommandError(rCommand, fmt.Errorf("backup retention on profile '%s': %w", r.profile.Name, err))
 	}
-	r.statusSuccess(constants.SectionConfigurationRetention)
+	r.statusSuccess(constants.SectionConfigurationRetention, run)
+	return nil
+}
+
+func (r *resticWrapper) runCopy() error {
+	clog.Infof("profile '%s': copying snapshots to the secondary repository", r.profile.Name)
+	args := r.commandArgs(r.profile.GetCommandFlags(constants.CommandCopy))
+	rCommand := r.prepareCommand(constants.CommandCopy, args)
+	run := status.Run{Start: time.Now()}
+	err := runShellCommand(rCommand)
+	if err != nil {
+		r.statusError(constants.CommandCopy, run, err)
+		return newCommandError(rCommand, fmt.Errorf("backup copy on profile '%s': %w", r.profile.Name, err))
+	}
+	r.statusSuccess(constants.CommandCopy, run)
 	return nil
 }
 
 func (r *resticWrapper) runCommand(command string) error {
 	clog.Infof("profile '%s': starting '%s'", r.profile.Name, command)
-	args := convertIntoArgs(r.profile.GetCommandFlags(command))
+	flags := r.profile.GetCommandFlags(command)
+	var output *backupOutput
+	if command == constants.CommandBackup && r.needsBackupSummary() {
+		// the summary of the backup is only available from the JSON output of restic
+		_, raw := flags[constants.ParameterJSON]
+		if !raw {
+			flags[constants.ParameterJSON] = []string{}
+		}
+		output = newBackupOutput(r.getStdout(), raw)
+	}
+	args := r.commandArgs(flags)
 	rCommand := r.prepareCommand(command, args)
-	err := runShellCommand(rCommand)
+	if output != nil {
+		rCommand.stdout = output
+	}
+	run := status.Run{Start: time.Now()}
+	attempts, err := r.runWithRetry(rCommand)
+	run.Attempts = attempts
+	if output != nil {
+		_ = output.Flush()
+		run.Summary = output.Summary()
+	}
 	if err != nil {
-		r.statusError(r.command, err)
+		r.statusError(r.command, run, err)
 		return newCommandError(rCommand, fmt.Errorf("%s on profile '%s': %w", r.command, r.profile.Name, err))
 	}
-	r.statusSuccess(r.command)
+	r.statusSuccess(r.command, run)
 	clog.Infof("profile '%s': finished '%s'", r.profile.Name, command)
 	return nil
 }
 
 func (r *resticWrapper) runPreCommand(command string) error {
-	// Pre/Post commands are only supported for backup
-	if command != constants.CommandBackup {
-		return nil
-	}
-	if r.profile.Backup == nil || r.profile.Backup.RunBefore == nil || len(r.profile.Backup.RunBefore) == 0 {
+	preCommands := r.profile.GetRunShellCommandsSection(command).RunBefore
+	if len(preCommands) == 0 {
 		return nil
 	}
 	env := append(os.Environ(), r.getEnvironment()...)
 	env = append(env, r.getProfileEnvironment()...)
 
-	for i, preCommand := range r.profile.Backup.RunBefore {
-		clog.Debugf("starting pre-backup command %d/%d", i+1, len(r.profile.Backup.RunBefore))
-		rCommand := newShellCommand(preCommand, nil, env, r.dryRun, r.sigChan, r.setPID)
-		// stdout are stderr are coming from the default terminal (in case they're redirected)
-		rCommand.stdout = term.GetOutput()
-		rCommand.stderr = term.GetErrorOutput()
-		err := runShellCommand(rCommand)
+	for i, preCommand := range preCommands {
+		clog.Debugf("starting pre-%s command %d/%d", command, i+1, len(preCommands))
+		rCommand, err := r.runConfiguredCommand(preCommand, env)
 		if err != nil {
-			return newCommandError(rCommand, fmt.Errorf("run-before backup on profile '%s': %w", r.profile.Name, err))
+			return newCommandError(rCommand, fmt.Errorf("run-before %s on profile '%s': %w", command, r.profile.Name, err))
 		}
 	}
 	return nil
 }
 
 func (r *resticWrapper) runPostCommand(command string) error {
-	// Pre/Post commands are only supported for backup
-	if command != constants.CommandBackup {
+	postCommands := r.profile.GetRunShellCommandsSection(command).RunAfter
+	if len(postCommands) == 0 {
 		return nil
 	}
-	if r.profile.Backup == nil || r.profile.Backup.RunAfter == nil || len(r.profile.Backup.RunAfter) == 0 {
+	env := append(os.Environ(), r.getEnvironment()...)
+	env = append(env, r.getProfileEnvironment()...)
+
+	for i, postCommand := range postCommands {
+		clog.Debugf("starting post-%s command %d/%d", command, i+1, len(postCommands))
+		rCommand, err := r.runConfiguredCommand(postCommand, env)
+		if err != nil {
+			return newCommandError(rCommand, fmt.Errorf("run-after %s on profile '%s': %w", command, r.profile.Name, err))
+		}
+	}
+	return nil
+}
+
+func (r *resticWrapper) runPostFailCommand(command string, fail error) error {
+	postFailCommands := r.profile.GetRunShellCommandsSection(command).RunAfterFail
+	if len(postFailCommands) == 0 {
 		return nil
 	}
 	env := append(os.Environ(), r.getEnvironment()...)
 	env = append(env, r.getProfileEnvironment()...)
+	env = append(env, r.getFailEnvironment(fail)...)
 
-	for i, postCommand := range r.profile.Backup.RunAfter {
-		clog.Debugf("starting post-backup command %d/%d", i+1, len(r.profile.Backup.RunAfter))
-		rCommand := newShellCommand(postCommand, nil, env, r.dryRun, r.sigChan, r.setPID)
-		// stdout are stderr are coming from the default terminal (in case they're redirected)
-		rCommand.stdout = term.GetOutput()
-		rCommand.stderr = term.GetErrorOutput()
-		err := runShellCommand(rCommand)
+	for i, postFailCommand := range postFailCommands {
+		clog.Debugf("starting post-fail-%s command %d/%d", command, i+1, len(postFailCommands))
+		rCommand, err := r.runConfiguredCommand(postFailCommand, env)
 		if err != nil {
-			return newCommandError(rCommand, fmt.Errorf("run-after backup on profile '%s': %w", r.profile.Name, err))
+			return newCommandError(rCommand, err)
 		}
 	}
 	return nil
@@ -299,11 +458,7 @@ func (r *resticWrapper) runProfilePreCommand() error {
 
 	for i, preCommand := range r.profile.RunBefore {
 		clog.Debugf("starting 'run-before' profile command %d/%d", i+1, len(r.profile.RunBefore))
-		rCommand := newShellCommand(preCommand, nil, env, r.dryRun, r.sigChan, r.setPID)
-		// stdout are stderr are coming from the default terminal (in case they're redirected)
-		rCommand.stdout = term.GetOutput()
-		rCommand.stderr = term.GetErrorOutput()
-		err := runShellCommand(rCommand)
+		rCommand, err := r.runConfiguredCommand(preCommand, env)
 		if err != nil {
 			return newCommandError(rCommand, fmt.Errorf("run-before on profile '%s': %w", r.profile.Name, err))
 		}
@@ -320,11 +475,7 @@ func (r *resticWrapper) runProfilePostCommand() error {
 
 	for i, postCommand := range r.profile.RunAfter {
 		clog.Debugf("starting 'run-after' profile command %d/%d", i+1, len(r.profile.RunAfter))
-		rCommand := newShellCommand(postCommand, nil, env, r.dryRun, r.sigChan, r.setPID)
-		// stdout are stderr are coming from the default terminal (in case they're redirected)
-		rCommand.stdout = term.GetOutput()
-		rCommand.stderr = term.GetErrorOutput()
-		err := runShellCommand(rCommand)
+		rCommand, err := r.runConfiguredCommand(postCommand, env)
 		if err != nil {
 			return newCommandError(rCommand, fmt.Errorf("run-after on profile '%s': %w", r.profile.Name, err))
 		}
@@ -338,19 +489,11 @@ func (r *resticWrapper) runProfilePostFailCommand(fail error) error {
 	}
 	env := append(os.Environ(), r.getEnvironment()...)
 	env = append(env, r.getProfileEnvironment()...)
-	env = append(env, fmt.Sprintf("ERROR=%s", fail.Error()))
-
-	if fail, ok := fail.(*commandError); ok {
-		env = append(env, fmt.Sprintf("ERROR_COMMANDLINE=%s", fail.Commandline()))
-	}
+	env = append(env, r.getFailEnvironment(fail)...)
 
 	for i, postCommand := range r.profile.RunAfterFail {
 		clog.Debugf("starting 'run-after-fail' profile command %d/%d", i+1, len(r.profile.RunAfterFail))
-		rCommand := newShellCommand(postCommand, nil, env, r.dryRun, r.sigChan, r.setPID)
-		// stdout are stderr are coming from the default terminal (in case they're redirected)
-		rCommand.stdout = term.GetOutput()
-		rCommand.stderr = term.GetErrorOutput()
-		err := runShellCommand(rCommand)
+		rCommand, err := r.runConfiguredCommand(postCommand, env)
 		if err != nil {
 			return newCommandError(rCommand, err)
 		}
@@ -358,6 +501,71 @@ func (r *resticWrapper) runProfilePostFailCommand(fail error) error {
 	return nil
 }
 
+// runFinalCommand runs the 'run-finally' commands of the profile, after a success or a failure.
+// An error in one of these commands is only logged: it doesn't change the result of the profile
+func (r *resticWrapper) runFinalCommand(fail error) {
+	if len(r.profile.RunFinally) == 0 {
+		return
+	}
+	env := append(os.Environ(), r.getEnvironment()...)
+	env = append(env, r.getProfileEnvironment()...)
+	if fail != nil {
+		env = append(env, r.getFailEnvironment(fail)...)
+	}
+
+	for i, finalCommand := range r.profile.RunFinally {
+		clog.Debugf("starting 'run-finally' profile command %d/%d", i+1, len(r.profile.RunFinally))
+		_, err := r.runConfiguredCommand(finalCommand, env)
+		if err != nil {
+			clog.Errorf("run-finally on profile '%s': %v", r.profile.Name, err)
+		}
+	}
+}
+
+// runConfiguredCommand runs a shell command from the configuration (run-before, run-after, etc.).
+// The error is only logged when the command is set to ignore errors
+func (r *resticWrapper) runConfiguredCommand(command config.ShellCommand, env []string) (shellCommandDefinition, error) {
+	commandLine, args := command.Command, []string(nil)
+	if command.Shell == shell.NoShell {
+		// without a shell, the command line is split into the executable and its arguments
+		parts, err := shell.SplitArguments(command.Command)
+		if err != nil {
+			return newShellCommand(command.Command, nil, env, r.dryRun, r.sigChan, r.setPID), err
+		}
+		if len(parts) > 0 {
+			commandLine, args = parts[0], parts[1:]
+		}
+	}
+	rCommand := newShellCommand(commandLine, args, append(env, getShellCommandEnvironment(command)...), r.dryRun, r.sigChan, r.setPID)
+	rCommand.shell = command.Shell
+	rCommand.dir = command.WorkingDir
+	rCommand.timeout = command.Timeout
+	rCommand.stdout = r.getStdout()
+	rCommand.stderr = r.getStderr()
+	err := runShellCommand(rCommand)
+	if err != nil && command.IgnoreError {
+		clog.Warningf("profile '%s': ignoring error from command '%s': %v", r.profile.Name, command.Command, err)
+		return rCommand, nil
+	}
+	return rCommand, err
+}
+
+// getFailEnvironment returns the environment variables describing the error for the failure commands
+func (r *resticWrapper) getFailEnvironment(fail error) []string {
+	env := []string{fmt.Sprintf("ERROR=%s", fail.Error())}
+
+	// the command error can be wrapped when running on multiple repositories
+	var failedCommand *commandError
+	if errors.As(fail, &failedCommand) {
+		env = append(env, fmt.Sprintf("ERROR_COMMANDLINE=%s", failedCommand.Commandline()))
+	}
+	var failedOutput *stderrError
+	if errors.As(fail, &failedOutput) {
+		env = append(env, fmt.Sprintf("ERROR_STDERR=%s", failedOutput.Stderr()))
+	}
+	return env
+}
+
 // getEnvironment returns the environment variables defined in the profile configuration
 func (r *resticWrapper) getEnvironment() []string {
 	if r.profile.Environment == nil || len(r.profile.Environment) == 0 {
@@ -375,6 +583,16 @@ func (r *resticWrapper) getEnvironment() []string {
 	return env
 }
 
+// getShellCommandEnvironment returns the environment variables defined for a shell command
+func getShellCommandEnvironment(command config.ShellCommand) []string {
+	env := make([]string, 0, len(command.Environment))
+	for key, value := range command.Environment {
+		// env variables are always uppercase
+		env = append(env, fmt.Sprintf("%s=%s", strings.ToUpper(key), value))
+	}
+	return env
+}
+
 // getProfileEnvironment returns some environment variables about the current profile
 // (name and command for now)
 func (r *resticWrapper) getProfileEnvironment() []string {
@@ -384,57 +602,128 @@ func (r *resticWrapper) getProfileEnvironment() []string {
 	}
 }
 
-func (r *resticWrapper) statusSuccess(command string) {
-	if r.profile.StatusFile == "" {
-		return
+// statusSuccess saves the successful run of the command in the status file and the metrics
+func (r *resticWrapper) statusSuccess(command string, run status.Run) {
+	r.saveStatus(command, status.NewCommandSuccess(run))
+}
+
+// statusError saves the failed run of the command in the status file and the metrics
+func (r *resticWrapper) statusError(command string, run status.Run, fail error) {
+	r.saveStatus(command, status.NewCommandError(run, fail))
+}
+
+func (r *resticWrapper) saveStatus(command string, commandStatus *status.CommandStatus) {
+	if r.profile.StatusFile != "" {
+		err := status.NewStatus(r.profile.StatusFile).Update(func(statusFile *status.Status) bool {
+			statusProfile := r.getStatusProfile(statusFile)
+			if r.repository == "" && command == r.command {
+				r.prevStatus = statusProfile.GetCommandStatus(command)
+			}
+			return statusProfile.SetCommandStatus(command, commandStatus) != nil
+		})
+		if err != nil {
+			// not important enough to throw an error here
+			clog.Warningf("saving status file '%s': %v", r.profile.StatusFile, err)
+		}
 	}
-	var err error
-	switch command {
-	case constants.CommandBackup:
-		status := status.NewStatus(r.profile.StatusFile).Load()
-		status.Profile(r.profile.Name).BackupSuccess()
-		err = status.Save()
-	case constants.CommandCheck:
-		status := status.NewStatus(r.profile.StatusFile).Load()
-		status.Profile(r.profile.Name).CheckSuccess()
-		err = status.Save()
-	case constants.SectionConfigurationRetention, constants.CommandForget:
-		status := status.NewStatus(r.profile.StatusFile).Load()
-		status.Profile(r.profile.Name).RetentionSuccess()
-		err = status.Save()
+	if command == r.command {
+		r.lastStatus = commandStatus
 	}
-	if err != nil {
-		// not important enough to throw an error here
-		clog.Warningf("saving status file '%s': %v", r.profile.StatusFile, err)
+	if r.profile.PromFile != "" && r.dryRun {
+		clog.Infof("dry-run: saving the metrics of %s to prometheus file '%s'", command, r.profile.PromFile)
+	} else if r.profile.PromFile != "" {
+		err := r.addMetrics(prom.NewMetrics(), command, commandStatus).SaveTextfile(r.profile.PromFile)
+		if err != nil {
+			clog.Warningf("saving prometheus file '%s': %v", r.profile.PromFile, err)
+		}
+	}
+	if r.profile.PromPush != "" && r.metrics != nil {
+		if r.metrics[command] == nil {
+			r.metrics[command] = prom.NewMetrics()
+		}
+		r.addMetrics(r.metrics[command], command, commandStatus)
 	}
 }
 
-func (r *resticWrapper) statusError(command string, fail error) {
-	if r.profile.StatusFile == "" {
+// addMetrics adds the gauges of the command to the metrics: the runs on each repository of the profile
+// have a repository label, next to the overall result of the profile
+func (r *resticWrapper) addMetrics(metrics *prom.Metrics, command string, commandStatus *status.CommandStatus) *prom.Metrics {
+	if r.repository != "" {
+		return metrics.AddRepositoryCommandStatus(r.profile.Name, r.repository, command, commandStatus)
+	}
+	return metrics.AddCommandStatus(r.profile.Name, command, commandStatus)
+}
+
+// pushMetrics sends the metrics of the commands run by the profile to the prometheus Pushgateway.
+// Each command has its own group, so a command run on its own doesn't remove the metrics of the other commands.
+// A failure is only logged: it doesn't change the result of the profile
+func (r *resticWrapper) pushMetrics() {
+	if r.profile.PromPush == "" || len(r.metrics) == 0 {
 		return
 	}
-	var err error
-	switch command {
-	case constants.CommandBackup:
-		status := status.NewStatus(r.profile.StatusFile).Load()
-		status.Profile(r.profile.Name).BackupError(fail)
-		err = status.Save()
-	case constants.CommandCheck:
-		status := status.NewStatus(r.profile.StatusFile).Load()
-		status.Profile(r.profile.Name).CheckError(fail)
-		err = status.Save()
-	case constants.SectionConfigurationRetention, constants.CommandForget:
-		status := status.NewStatus(r.profile.StatusFile).Load()
-		status.Profile(r.profile.Name).RetentionError(fail)
-		err = status.Save()
+	commands := make([]string, 0, len(r.metrics))
+	for command := range r.metrics {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	if r.dryRun {
+		clog.Infof("dry-run: pushing the metrics of %s on profile '%s' to %s", strings.Join(commands, ", "), r.profile.Name, r.profile.PromPush)
+		return
 	}
+	hostname, err := os.Hostname()
 	if err != nil {
-		// not important enough to throw an error here
-		clog.Warningf("saving status file '%s': %v", r.profile.StatusFile, err)
+		clog.Warningf("cannot get the hostname for the prometheus metrics: %v", err)
+	}
+	timeout := r.profile.PromTimeout
+	if timeout == 0 {
+		timeout = constants.DefaultPromPushTimeout
+	}
+	for _, command := range commands {
+		clog.Debugf("pushing the metrics of %s on profile '%s' to %s", command, r.profile.Name, r.profile.PromPush)
+		err = r.metrics[command].Push(r.profile.PromPush, constants.DefaultPromPushJob, timeout, "profile", r.profile.Name, "command", command, "host", hostname)
+		if err != nil {
+			clog.Warningf("profile '%s': pushing the metrics of %s to %s: %v", r.profile.Name, command, r.profile.PromPush, err)
+		}
 	}
 }
 
+// needsBackupSummary returns true when the statistics of the backup are recorded somewhere, or sent by a web hook
+func (r *resticWrapper) needsBackupSummary() bool {
+	if r.dryRun {
+		return false
+	}
+	if r.profile.StatusFile != "" || r.profile.PromFile != "" || r.profile.PromPush != "" {
+		return true
+	}
+	if r.command != constants.CommandBackup {
+		return false
+	}
+	section := r.profile.GetRunShellCommandsSection(r.command)
+	return len(r.profile.SendAfter) > 0 || len(r.profile.SendAfterFail) > 0 || len(section.SendAfter) > 0 || len(section.SendAfterFail) > 0
+}
+
+// getStatusProfile returns the status of the profile, or the status of the repository currently in use
+func (r *resticWrapper) getStatusProfile(s *status.Status) *status.Profile {
+	profile := s.Profile(r.profile.Name)
+	if r.repository != "" {
+		return profile.Repository(r.repository)
+	}
+	return profile
+}
+
+// commandArgs converts the flags into restic arguments. The values are only quoted when restic is started via a shell
+func (r *resticWrapper) commandArgs(flags map[string][]string) []string {
+	if r.resticShell == shell.NoShell {
+		return flagsIntoArgs(flags, false)
+	}
+	return convertIntoArgs(flags)
+}
+
 func convertIntoArgs(flags map[string][]string) []string {
+	return flagsIntoArgs(flags, true)
+}
+
+func flagsIntoArgs(flags map[string][]string, quote bool) []string {
 	args := make([]string, 0)
 
 	if len(flags) == 0 {
@@ -462,7 +751,7 @@ func convertIntoArgs(flags map[string][]string) []string {
 		for _, value := range values {
 			args = append(args, fmt.Sprintf("--%s", key))
 			if value != "" {
-				if strings.Contains(value, " ") {
+				if quote && strings.Contains(value, " ") {
 					// quote the string containing spaces
 					value = fmt.Sprintf(`"%s"`, value)
 				}
@@ -473,14 +762,71 @@ func convertIntoArgs(flags map[string][]string) []string {
 	return args
 }
 
-// lockRun is making sure the function is only run once by putting a lockfile on the disk
-func lockRun(filename string, force bool, run func(setPID lock.SetPID) error) error {
-	if filename == "" {
+// expandGlobs expands the home directory and the glob patterns like a unix shell would do.
+// A pattern matching no file is kept as it is.
+func expandGlobs(paths []string) []string {
+	if len(paths) == 0 {
+		return paths
+	}
+	expanded := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path == "~" || strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = home + path[1:]
+			}
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil || len(matches) == 0 {
+			expanded = append(expanded, path)
+			continue
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded
+}
+
+// getStdout returns the output of the commands: the default terminal unless the wrapper has its own output
+func (r *resticWrapper) getStdout() io.Writer {
+	if r.stdout != nil {
+		return r.stdout
+	}
+	// coming from the default terminal (in case it's redirected)
+	return term.GetOutput()
+}
+
+// getStderr returns the error output of the commands: the default terminal unless the wrapper has its own output
+func (r *resticWrapper) getStderr() io.Writer {
+	if r.stderr != nil {
+		return r.stderr
+	}
+	return term.GetErrorOutput()
+}
+
+// newLock returns the lock of the profile, or nil when the profile has no lockfile
+func (r *resticWrapper) newLock() *lock.Lock {
+	return newProfileLock(r.profile)
+}
+
+// newProfileLock returns the lock of the profile (simple lockfile or kernel lock), or nil when the profile has no lockfile
+func newProfileLock(profile *config.Profile) *lock.Lock {
+	if profile.Lock == "" {
+		return nil
+	}
+	if profile.LockMode == constants.LockModeFlock {
+		return lock.NewFlock(profile.Lock)
+	}
+	return lock.NewLock(profile.Lock)
+}
+
+// lockRun is making sure the function is only run once by putting a lockfile on the disk.
+// When the lockfile is already taken, it waits up to lockWait for the lock to be released
+func lockRun(runLock *lock.Lock, force bool, lockWait time.Duration, sigChan <-chan os.Signal, run func(setPID lock.SetPID) error) error {
+	if runLock == nil {
 		// No lock
 		return run(nil)
 	}
 	// Make sure the path to the lock exists
-	dir := filepath.Dir(filename)
+	dir := filepath.Dir(runLock.Lockfile)
 	if dir != "" {
 		err := os.MkdirAll(dir, 0755)
 		if err != nil {
@@ -488,7 +834,6 @@ func lockRun(filename string, force bool, run func(setPID lock.SetPID) error) er
 			return run(nil)
 		}
 	}
-	runLock := lock.NewLock(filename)
 	success := runLock.TryAcquire()
 	if !success {
 		who, err := runLock.Who()
@@ -500,6 +845,13 @@ func lockRun(filename string, force bool, run func(setPID lock.SetPID) error) er
 			clog.Warningf("previous run of the profile started by %s hasn't finished properly", who)
 			success = runLock.ForceAcquire()
 		}
+		if !success && lockWait > 0 {
+			err = waitForLock(runLock, who, force, lockWait, sigChan)
+			if err != nil {
+				return err
+			}
+			success = true
+		}
 		if !success {
 			return fmt.Errorf("another process is already running this profile: %s", who)
 		}
@@ -508,6 +860,43 @@ func lockRun(filename string, force bool, run func(setPID lock.SetPID) error) er
 	return run(runLock.SetPID)
 }
 
+// waitForLock tries to acquire the lock until it's released by the other process, or the lockWait delay expires
+func waitForLock(runLock *lock.Lock, who string, force bool, lockWait time.Duration, sigChan <-chan os.Signal) error {
+	clog.Infof("another process is already running this profile: %s; waiting up to %s for the lock to be released", who, lockWait)
+	start := time.Now()
+	deadline := time.NewTimer(lockWait)
+	defer deadline.Stop()
+	poll := time.NewTicker(lockPollInterval)
+	defer poll.Stop()
+	lastLog := start
+	for {
+		select {
+		case <-poll.C:
+			var acquired bool
+			if force {
+				acquired = runLock.ForceAcquire()
+			} else {
+				acquired = runLock.TryAcquire()
+			}
+			if acquired {
+				clog.Infof("lock acquired after waiting %s", time.Since(start).Round(time.Second))
+				return nil
+			}
+			if time.Since(lastLog) >= lockWaitLogInterval {
+				lastLog = time.Now()
+				if current, err := runLock.Who(); err == nil {
+					who = current
+				}
+				clog.Infof("still waiting for the lock held by %s (%s remaining)", who, (lockWait - time.Since(start)).Round(time.Second))
+			}
+		case <-deadline.C:
+			return fmt.Errorf("another process is still running this profile after waiting %s: %s", lockWait, who)
+		case sig := <-sigChan:
+			return fmt.Errorf("waiting for the lock interrupted by signal %s", sig)
+		}
+	}
+}
+
 // runOnFailure will run the onFailure function if an error occurred in the run function
 func runOnFailure(run func() error, onFailure func(error)) error {
 	err := run()
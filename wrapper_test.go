@@ -2,7 +2,12 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -11,8 +16,13 @@ import (
 	"time"
 
 	"github.com/creativeprojects/resticprofile/config"
+	"github.com/creativeprojects/resticprofile/constants"
+	"github.com/creativeprojects/resticprofile/lock"
+	"github.com/creativeprojects/resticprofile/shell"
+	"github.com/creativeprojects/resticprofile/status"
 	"github.com/creativeprojects/resticprofile/term"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetEmptyEnvironment(t *testing.T) {
@@ -74,9 +84,59 @@ func TestConversionToArgs(t *testing.T) {
 	assert.Contains(t, args, "test3")
 }
 
+func TestConversionToRawArgs(t *testing.T) {
+	flags := map[string][]string{
+		"string1": {"with space"},
+		"string2": {`"quoted"`},
+	}
+	wrapper := newResticWrapper("restic", false, false, config.NewProfile(nil, "name"), "test", nil, nil)
+	wrapper.resticShell = shell.NoShell
+	args := wrapper.commandArgs(flags)
+	assert.Equal(t, []string{"--string1", "with space", "--string2", `"quoted"`}, args)
+}
+
+func TestExpandGlobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExpandGlobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.txt", "b.txt", "c.log"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0600))
+	}
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	paths := expandGlobs([]string{filepath.Join(dir, "*.txt"), filepath.Join(dir, "*.none"), filepath.Join(dir, "c.log"), "~"})
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a.txt"),
+		filepath.Join(dir, "b.txt"),
+		filepath.Join(dir, "*.none"),
+		filepath.Join(dir, "c.log"),
+		home,
+	}, paths)
+}
+
+func TestRunResticWithoutShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	profile.Backup = &config.BackupSection{
+		ExcludeFile: []string{"/path/with space/excludes"},
+		Source:      []string{"$HOME", "it's `mine`"},
+	}
+	wrapper := newResticWrapper("echo", false, false, profile, constants.CommandBackup, nil, nil)
+	wrapper.resticShell = shell.NoShell
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "backup --exclude-file /path/with space/excludes $HOME it's `mine`\n", buffer.String())
+}
+
 func TestPreProfileScriptFail(t *testing.T) {
 	profile := config.NewProfile(nil, "name")
-	profile.RunBefore = []string{"exit 1"} // this should both work on unix shell and windows batch
+	profile.RunBefore = config.NewShellCommands("exit 1") // this should both work on unix shell and windows batch
 	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
 	err := wrapper.runProfile()
 	assert.EqualError(t, err, "run-before on profile 'name': exit status 1")
@@ -84,7 +144,7 @@ func TestPreProfileScriptFail(t *testing.T) {
 
 func TestPostProfileScriptFail(t *testing.T) {
 	profile := config.NewProfile(nil, "name")
-	profile.RunAfter = []string{"exit 1"} // this should both work on unix shell and windows batch
+	profile.RunAfter = config.NewShellCommands("exit 1") // this should both work on unix shell and windows batch
 	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
 	err := wrapper.runProfile()
 	assert.EqualError(t, err, "run-after on profile 'name': exit status 1")
@@ -101,7 +161,7 @@ func TestPostProfileAfterFail(t *testing.T) {
 	testFile := "TestPostProfileAfterFail.txt"
 	_ = os.Remove(testFile)
 	profile := config.NewProfile(nil, "name")
-	profile.RunAfter = []string{"echo failed > " + testFile}
+	profile.RunAfter = config.NewShellCommands("echo failed > " + testFile)
 	wrapper := newResticWrapper("exit", false, false, profile, "1", nil, nil)
 	err := wrapper.runProfile()
 	assert.EqualError(t, err, "1 on profile 'name': exit status 1")
@@ -113,7 +173,7 @@ func TestPostFailProfile(t *testing.T) {
 	testFile := "TestPostFailProfile.txt"
 	_ = os.Remove(testFile)
 	profile := config.NewProfile(nil, "name")
-	profile.RunAfterFail = []string{"echo failed > " + testFile}
+	profile.RunAfterFail = config.NewShellCommands("echo failed > " + testFile)
 	wrapper := newResticWrapper("exit", false, false, profile, "1", nil, nil)
 	err := wrapper.runProfile()
 	assert.EqualError(t, err, "1 on profile 'name': exit status 1")
@@ -154,9 +214,9 @@ func TestEnvProfileName(t *testing.T) {
 	term.SetOutput(buffer)
 	profile := config.NewProfile(nil, "TestEnvProfileName")
 	if runtime.GOOS == "windows" {
-		profile.RunBefore = []string{"echo profile name = %PROFILE_NAME%"}
+		profile.RunBefore = config.NewShellCommands("echo profile name = %PROFILE_NAME%")
 	} else {
-		profile.RunBefore = []string{"echo profile name = $PROFILE_NAME"}
+		profile.RunBefore = config.NewShellCommands("echo profile name = $PROFILE_NAME")
 	}
 	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
 	err := wrapper.runProfile()
@@ -169,9 +229,9 @@ func TestEnvProfileCommand(t *testing.T) {
 	term.SetOutput(buffer)
 	profile := config.NewProfile(nil, "name")
 	if runtime.GOOS == "windows" {
-		profile.RunBefore = []string{"echo profile command = %PROFILE_COMMAND%"}
+		profile.RunBefore = config.NewShellCommands("echo profile command = %PROFILE_COMMAND%")
 	} else {
-		profile.RunBefore = []string{"echo profile command = $PROFILE_COMMAND"}
+		profile.RunBefore = config.NewShellCommands("echo profile command = $PROFILE_COMMAND")
 	}
 	wrapper := newResticWrapper("echo", false, false, profile, "test-command", nil, nil)
 	err := wrapper.runProfile()
@@ -184,9 +244,9 @@ func TestEnvError(t *testing.T) {
 	term.SetOutput(buffer)
 	profile := config.NewProfile(nil, "name")
 	if runtime.GOOS == "windows" {
-		profile.RunAfterFail = []string{"echo error: %ERROR%"}
+		profile.RunAfterFail = config.NewShellCommands("echo error: %ERROR%")
 	} else {
-		profile.RunAfterFail = []string{"echo error: $ERROR"}
+		profile.RunAfterFail = config.NewShellCommands("echo error: $ERROR")
 	}
 	wrapper := newResticWrapper("exit", false, false, profile, "1", nil, nil)
 	err := wrapper.runProfile()
@@ -199,9 +259,9 @@ func TestEnvErrorCommandLine(t *testing.T) {
 	term.SetOutput(buffer)
 	profile := config.NewProfile(nil, "name")
 	if runtime.GOOS == "windows" {
-		profile.RunAfterFail = []string{"echo cmd: %ERROR_COMMANDLINE%"}
+		profile.RunAfterFail = config.NewShellCommands("echo cmd: %ERROR_COMMANDLINE%")
 	} else {
-		profile.RunAfterFail = []string{"echo cmd: $ERROR_COMMANDLINE"}
+		profile.RunAfterFail = config.NewShellCommands("echo cmd: $ERROR_COMMANDLINE")
 	}
 	wrapper := newResticWrapper("exit", false, false, profile, "1", nil, nil)
 	err := wrapper.runProfile()
@@ -209,6 +269,507 @@ func TestEnvErrorCommandLine(t *testing.T) {
 	assert.Equal(t, "cmd: \"exit\" \"1\"\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
 }
 
+func TestRunShellCommandsOnCheck(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	profile.Check = &config.OtherSectionWithSchedule{
+		RunShellCommandsSection: config.RunShellCommandsSection{
+			RunBefore: config.NewShellCommands("echo before check"),
+			RunAfter:  config.NewShellCommands("echo after check"),
+		},
+	}
+	wrapper := newResticWrapper("echo", false, false, profile, "check", nil, nil)
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "before check\ncheck\nafter check\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+}
+
+func TestRunShellCommandsOnOtherSection(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	profile.OtherSections = map[string]map[string]interface{}{
+		"restore": {
+			"run-before": "echo before restore",
+			"run-after":  []interface{}{"echo after restore"},
+			"target":     "/tmp",
+		},
+	}
+	wrapper := newResticWrapper("echo", false, false, profile, "restore", nil, nil)
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "before restore\nrestore --target /tmp\nafter restore\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+}
+
+func TestRunAfterFailOnCommandSection(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	profile.RunAfterFail = config.NewShellCommands("echo profile failed")
+	profile.RunFinally = config.NewShellCommands("echo finally")
+	profile.OtherSections = map[string]map[string]interface{}{
+		"1": {
+			"run-after":      "echo after",
+			"run-after-fail": "echo command failed",
+		},
+	}
+	wrapper := newResticWrapper("exit", false, false, profile, "1", nil, nil)
+	err := wrapper.runProfile()
+	assert.EqualError(t, err, "1 on profile 'name': exit status 1")
+	assert.Equal(t, "command failed\nprofile failed\nfinally\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+}
+
+func TestRunFinallyAfterSuccess(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	profile.RunAfter = config.NewShellCommands("echo after")
+	profile.RunFinally = config.NewShellCommands("echo finally", "exit 1", "echo finally again")
+	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
+	err := wrapper.runProfile()
+	// an error in a run-finally command doesn't fail the profile
+	assert.NoError(t, err)
+	assert.Equal(t, "test\nafter\nfinally\nfinally again\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+}
+
+func TestEnvErrorInRunFinally(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	if runtime.GOOS == "windows" {
+		profile.RunFinally = config.NewShellCommands("echo error: %ERROR%")
+	} else {
+		profile.RunFinally = config.NewShellCommands("echo error: $ERROR")
+	}
+	wrapper := newResticWrapper("exit", false, false, profile, "1", nil, nil)
+	err := wrapper.runProfile()
+	assert.Error(t, err)
+	assert.Equal(t, "error: 1 on profile 'name': exit status 1\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+}
+
+func TestRunShellCommandWithOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	dir, err := ioutil.TempDir("", "TestRunShellCommandWithOptions")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+
+	profile := config.NewProfile(nil, "name")
+	profile.RunBefore = config.ShellCommands{
+		{Command: "pwd", WorkingDir: dir},
+		{Command: "echo $MESSAGE", Environment: map[string]string{"message": "from env"}},
+		{Command: `echo "$HOME"`, Shell: "none"},
+		{Command: "exit 1", IgnoreError: true},
+	}
+	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
+	err = wrapper.runProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, dir+"\nfrom env\n$HOME\ntest\n", buffer.String())
+}
+
+func TestRunShellCommandWithTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	profile := config.NewProfile(nil, "name")
+	profile.RunBefore = config.ShellCommands{
+		{Command: "sleep 3", Timeout: 100 * time.Millisecond},
+	}
+	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
+	start := time.Now()
+	err := wrapper.runProfile()
+	assert.EqualError(t, err, "run-before on profile 'name': command timed out after 100ms: signal: killed")
+	assert.WithinDuration(t, time.Now(), start, 1*time.Second)
+	assert.Empty(t, buffer.String())
+}
+
+func TestEnvErrorStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	errorBuffer := &bytes.Buffer{}
+	term.SetErrorOutput(errorBuffer)
+	defer term.SetErrorOutput(os.Stderr)
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestEnvErrorStderr", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.StatusFile = statusFile
+	profile.Backup = &config.BackupSection{}
+	profile.RunAfterFail = config.NewShellCommands("echo stderr: $ERROR_STDERR")
+	wrapper := newResticWrapper("echo progress; echo unable to open repository >&2; exit 1;", false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	assert.EqualError(t, err, "backup on profile 'name': exit status 1")
+	// the error output is still displayed
+	assert.Equal(t, "unable to open repository\n", errorBuffer.String())
+	assert.Equal(t, "progress\nstderr: unable to open repository\n", buffer.String())
+
+	profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+	assert.False(t, profileStatus.Backup.Success)
+	assert.Equal(t, "unable to open repository", profileStatus.Backup.Stderr)
+}
+
+func TestInitializeExistingRepository(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	errorBuffer := &bytes.Buffer{}
+	term.SetErrorOutput(errorBuffer)
+	defer term.SetErrorOutput(os.Stderr)
+
+	profile := config.NewProfile(nil, "name")
+	wrapper := newResticWrapper("echo config file already exists >&2; exit 1;", true, false, profile, "backup", nil, nil)
+	err := wrapper.runInitialize()
+	assert.Error(t, err)
+
+	// the error output is kept for the error, but not displayed
+	var failedOutput *stderrError
+	if assert.True(t, errors.As(err, &failedOutput)) {
+		assert.Equal(t, "config file already exists", failedOutput.Stderr())
+	}
+	assert.Empty(t, errorBuffer.String())
+}
+
+func TestBackupSummaryInStatusFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestBackupSummaryInStatusFile", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.StatusFile = statusFile
+	profile.Backup = &config.BackupSection{}
+	wrapper := newResticWrapper(`echo '{"message_type":"summary","files_new":3,"data_added":2048,"total_bytes_processed":4096,"snapshot_id":"1234abcd"}'; exit 0;`, false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "snapshot 1234abcd saved\n")
+
+	profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+	require.NotNil(t, profileStatus.Backup)
+	assert.True(t, profileStatus.Backup.Success)
+	assert.Equal(t, 0, profileStatus.Backup.ExitCode)
+	assert.False(t, profileStatus.Backup.Start.IsZero())
+	assert.False(t, profileStatus.Backup.End.Before(profileStatus.Backup.Start))
+	require.NotNil(t, profileStatus.Backup.Summary)
+	assert.Equal(t, "1234abcd", profileStatus.Backup.Summary.SnapshotID)
+	assert.Equal(t, 3, profileStatus.Backup.Summary.FilesNew)
+	assert.Equal(t, uint64(2048), profileStatus.Backup.Summary.DataAdded)
+	assert.Equal(t, uint64(4096), profileStatus.Backup.Summary.TotalBytesProcessed)
+
+	wrapper = newResticWrapper("exit 3;", false, false, profile, "check", nil, nil)
+	err = wrapper.runProfile()
+	require.Error(t, err)
+
+	profileStatus = status.NewStatus(statusFile).Load().Profile("name")
+	require.NotNil(t, profileStatus.Check)
+	assert.False(t, profileStatus.Check.Success)
+	assert.Equal(t, 3, profileStatus.Check.ExitCode)
+	assert.Nil(t, profileStatus.Check.Summary)
+}
+
+func TestPrometheusSaveToFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+	promFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.prom", "TestPrometheusSaveToFile", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(promFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.PromFile = promFile
+	profile.Backup = &config.BackupSection{}
+	wrapper := newResticWrapper(`echo '{"message_type":"summary","files_new":3,"data_added":2048,"snapshot_id":"1234abcd"}'; exit 0;`, false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	require.NoError(t, err)
+
+	wrapper = newResticWrapper("exit 3;", false, false, profile, "check", nil, nil)
+	err = wrapper.runProfile()
+	require.Error(t, err)
+
+	content, err := ioutil.ReadFile(promFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="name",command="backup"} 1`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_added_bytes{profile="name",command="backup"} 2048`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_files_new{profile="name",command="backup"} 3`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="name",command="check"} 0`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_exit_code{profile="name",command="check"} 3`+"\n")
+}
+
+func TestPrometheusSaveToFileDryRun(t *testing.T) {
+	promFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.prom", "TestPrometheusSaveToFileDryRun", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(promFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.PromFile = promFile
+	profile.Backup = &config.BackupSection{}
+	wrapper := newResticWrapper("echo", false, true, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	require.NoError(t, err)
+
+	_, err = os.Stat(promFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPrometheusPush(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+
+	bodies := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		bodies[r.URL.EscapedPath()] = string(content)
+	}))
+	defer server.Close()
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	profile := config.NewProfile(nil, "name")
+	profile.PromPush = server.URL
+	profile.Backup = &config.BackupSection{CheckAfter: true}
+	wrapper := newResticWrapper(`echo '{"message_type":"summary","files_new":3,"data_added":2048,"snapshot_id":"1234abcd"}'; exit 0;`, false, false, profile, "backup", nil, nil)
+	err = wrapper.runProfile()
+	require.NoError(t, err)
+
+	// the backup and the check are pushed at the end of the profile, each command in its own group
+	backupPath := "/metrics/job/resticprofile/profile/name/command/backup/host/" + url.PathEscape(hostname)
+	checkPath := "/metrics/job/resticprofile/profile/name/command/check/host/" + url.PathEscape(hostname)
+	assert.Len(t, bodies, 2)
+	require.Contains(t, bodies, backupPath)
+	assert.Contains(t, bodies[backupPath], `resticprofile_backup_success{profile="name",command="backup"} 1`+"\n")
+	assert.Contains(t, bodies[backupPath], `resticprofile_backup_files_new{profile="name",command="backup"} 3`+"\n")
+	assert.NotContains(t, bodies[backupPath], `command="check"`)
+	require.Contains(t, bodies, checkPath)
+	assert.Contains(t, bodies[checkPath], `resticprofile_backup_success{profile="name",command="check"} 1`+"\n")
+}
+
+func TestPrometheusPushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	profile := config.NewProfile(nil, "name")
+	profile.PromPush = server.URL
+	profile.PromTimeout = 100 * time.Millisecond
+	profile.Backup = &config.BackupSection{}
+	wrapper := newResticWrapper("echo", false, false, profile, "backup", nil, nil)
+	start := time.Now()
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < constants.DefaultPromPushTimeout/2)
+}
+
+func TestPrometheusPushDryRun(t *testing.T) {
+	pushed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed++
+	}))
+	defer server.Close()
+
+	profile := config.NewProfile(nil, "name")
+	profile.PromPush = server.URL
+	profile.Backup = &config.BackupSection{}
+	wrapper := newResticWrapper("echo", false, true, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	require.NoError(t, err)
+	assert.Equal(t, 0, pushed)
+}
+
+func TestPrometheusPushFailure(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	profile := config.NewProfile(nil, "name")
+	profile.PromPush = server.URL
+	profile.Backup = &config.BackupSection{}
+	wrapper := newResticWrapper("echo", false, false, profile, "backup", nil, nil)
+	// the backup is still successful
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+
+	// and so without any pushgateway
+	server.Close()
+	wrapper = newResticWrapper("echo", false, false, profile, "backup", nil, nil)
+	err = wrapper.runProfile()
+	assert.NoError(t, err)
+}
+
+func TestRunCopyAfterBackup(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestRunCopyAfterBackup", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.StatusFile = statusFile
+	profile.Backup = &config.BackupSection{}
+	profile.Copy = &config.CopySection{AfterBackup: true, Repository: "/copy"}
+	wrapper := newResticWrapper("echo", false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+	// the backup runs with --json to record its summary in the status file
+	assert.Equal(t, "backup --json\ncopy --repo2 /copy\n", strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+
+	profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+	assert.NotNil(t, profileStatus.Backup)
+	assert.NotNil(t, profileStatus.Copy)
+	assert.True(t, profileStatus.Copy.Success)
+}
+
+func TestRunBackupOnRepositories(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestRunBackupOnRepositories", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.StatusFile = statusFile
+	profile.Repository = "/main"
+	profile.Backup = &config.BackupSection{}
+	profile.Retention = &config.RetentionSection{AfterBackup: true}
+	profile.Repositories = config.RepositoriesSection{
+		"local":  {Repository: "/local"},
+		"remote": {Repository: "/remote"},
+	}
+	wrapper := newResticWrapper("echo", false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"backup --json --repo /local\nforget --repo /local\nbackup --json --repo /remote\nforget --repo /remote\n",
+		strings.ReplaceAll(buffer.String(), "\r\n", "\n"))
+
+	profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+	assert.True(t, profileStatus.Backup.Success)
+	assert.Nil(t, profileStatus.Retention)
+	assert.Len(t, profileStatus.Repositories, 2)
+	for _, name := range []string{"local", "remote"} {
+		assert.True(t, profileStatus.Repository(name).Backup.Success)
+		assert.True(t, profileStatus.Repository(name).Retention.Success)
+	}
+}
+
+func TestBackupSummaryOnRepositories(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+	statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestBackupSummaryOnRepositories", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(statusFile)
+	promFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.prom", "TestBackupSummaryOnRepositories", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(promFile)
+
+	profile := config.NewProfile(nil, "name")
+	profile.StatusFile = statusFile
+	profile.PromFile = promFile
+	profile.Backup = &config.BackupSection{CheckAfter: true}
+	profile.Repositories = config.RepositoriesSection{
+		"local":  {Environment: map[string]string{"added": "1024"}},
+		"remote": {Environment: map[string]string{"added": "2048"}},
+	}
+	// "sh -c" ignores the restic arguments after the script
+	wrapper := newResticWrapper(`sh -c 'echo "{\"message_type\":\"summary\",\"files_new\":1,\"data_added\":$ADDED}"'`, false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	require.NoError(t, err)
+
+	// the statistics of the profile add up the statistics of each repository
+	profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+	require.NotNil(t, profileStatus.Backup.Summary)
+	assert.Equal(t, uint64(3072), profileStatus.Backup.Summary.DataAdded)
+	assert.Equal(t, 2, profileStatus.Backup.Summary.FilesNew)
+	assert.Equal(t, uint64(1024), profileStatus.Repository("local").Backup.Summary.DataAdded)
+	require.NotNil(t, wrapper.lastStatus)
+	assert.Equal(t, profileStatus.Backup.Summary, wrapper.lastStatus.Summary)
+
+	content, err := ioutil.ReadFile(promFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `resticprofile_backup_added_bytes{profile="name",command="backup"} 3072`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_added_bytes{profile="name",command="backup",repository="local"} 1024`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_added_bytes{profile="name",command="backup",repository="remote"} 2048`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="name",command="check",repository="local"} 1`+"\n")
+	assert.Contains(t, string(content), `resticprofile_backup_success{profile="name",command="check",repository="remote"} 1`+"\n")
+}
+
+func TestRepositoryFailurePolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exit code is set from an environment variable using the unix shell")
+	}
+	testData := []struct {
+		policy     string
+		exitCodes  []string
+		runs       int
+		err        string
+		successful bool
+	}{
+		{"", []string{"1", "0", "0"}, 1, "backup on profile 'name': exit status 1", false},
+		{constants.RepositoryFailureStop, []string{"0", "1", "0"}, 2, "backup on profile 'name': exit status 1", false},
+		{constants.RepositoryFailureContinue, []string{"0", "1", "0"}, 3, "backup on profile 'name': exit status 1", false},
+		{constants.RepositoryFailureContinue, []string{"1", "1", "0"}, 3, "backup on profile 'name' failed on repositories first, second: backup on profile 'name': exit status 1", false},
+		{constants.RepositoryFailureIgnore, []string{"1", "1", "0"}, 3, "", true},
+		{constants.RepositoryFailureIgnore, []string{"1", "1", "1"}, 3, "backup on profile 'name' failed on repositories first, second, third: backup on profile 'name': exit status 1", false},
+	}
+
+	for _, testItem := range testData {
+		testItem := testItem
+		t.Run(testItem.policy+strings.Join(testItem.exitCodes, ""), func(t *testing.T) {
+			statusFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.json", "TestRepositoryFailurePolicy", time.Now().UnixNano(), os.Getpid()))
+			defer os.Remove(statusFile)
+
+			profile := config.NewProfile(nil, "name")
+			profile.StatusFile = statusFile
+			profile.RepoFailure = testItem.policy
+			profile.Backup = &config.BackupSection{}
+			profile.Repositories = config.RepositoriesSection{}
+			for i, name := range []string{"first", "second", "third"} {
+				profile.Repositories[name] = &config.RepositorySection{
+					Environment: map[string]string{"exit_code": testItem.exitCodes[i]},
+				}
+			}
+			// "sh -c" ignores the restic arguments after the script
+			wrapper := newResticWrapper("sh -c 'exit $EXIT_CODE'", false, false, profile, "backup", nil, nil)
+			err := wrapper.runProfile()
+			if testItem.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, testItem.err)
+			}
+
+			profileStatus := status.NewStatus(statusFile).Load().Profile("name")
+			assert.Equal(t, testItem.successful, profileStatus.Backup.Success)
+			assert.Len(t, profileStatus.Repositories, testItem.runs)
+		})
+	}
+}
+
 func TestRunProfileWithSetPIDCallback(t *testing.T) {
 	profile := config.NewProfile(nil, "name")
 	profile.Lock = filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.tmp", "TestRunProfileWithSetPIDCallback", time.Now().UnixNano(), os.Getpid()))
@@ -217,3 +778,147 @@ func TestRunProfileWithSetPIDCallback(t *testing.T) {
 	err := wrapper.runProfile()
 	assert.NoError(t, err)
 }
+
+func TestNewLock(t *testing.T) {
+	lockFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.lock", "TestNewLock", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(lockFile)
+
+	profile := config.NewProfile(nil, "name")
+	wrapper := newResticWrapper("echo", false, false, profile, "test", nil, nil)
+	assert.Nil(t, wrapper.newLock())
+
+	profile.Lock = lockFile
+	profile.LockMode = constants.LockModeFlock
+	kernelLock := wrapper.newLock()
+	require.NotNil(t, kernelLock)
+	require.True(t, kernelLock.TryAcquire())
+	defer kernelLock.Release()
+
+	// both types of locks are seeing the lock
+	assert.False(t, lock.NewFlock(lockFile).TryAcquire())
+	assert.False(t, lock.NewLock(lockFile).TryAcquire())
+}
+
+func TestLockWait(t *testing.T) {
+	defer func(interval time.Duration) { lockPollInterval = interval }(lockPollInterval)
+	lockPollInterval = 10 * time.Millisecond
+
+	lockFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d%d.lock", "TestLockWait", time.Now().UnixNano(), os.Getpid()))
+	defer os.Remove(lockFile)
+
+	otherLock := lock.NewLock(lockFile)
+	require.True(t, otherLock.TryAcquire())
+
+	// no wait
+	err := lockRun(lock.NewLock(lockFile), false, 0, nil, func(setPID lock.SetPID) error {
+		t.Error("the profile should not run")
+		return nil
+	})
+	assert.Error(t, err)
+
+	// waiting for too long
+	err = lockRun(lock.NewLock(lockFile), false, 50*time.Millisecond, nil, func(setPID lock.SetPID) error {
+		t.Error("the profile should not run")
+		return nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "another process is still running this profile after waiting 50ms")
+
+	// interrupted
+	sigChan := make(chan os.Signal, 1)
+	sigChan <- os.Interrupt
+	err = lockRun(lock.NewLock(lockFile), false, time.Minute, sigChan, func(setPID lock.SetPID) error {
+		t.Error("the profile should not run")
+		return nil
+	})
+	assert.EqualError(t, err, "waiting for the lock interrupted by signal interrupt")
+
+	// lock released while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		otherLock.Release()
+	}()
+	run := false
+	err = lockRun(lock.NewLock(lockFile), false, time.Minute, nil, func(setPID lock.SetPID) error {
+		run = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, run)
+	assert.NoFileExists(t, lockFile)
+}
+
+func TestSendWebHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test not running on this platform")
+	}
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+
+	requests := make([]string, 0, 4)
+	bodies := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		content, _ := ioutil.ReadAll(r.Body)
+		bodies[r.URL.Path] = string(content)
+	}))
+	defer server.Close()
+
+	profile := config.NewProfile(nil, "name")
+	profile.SendBefore = config.NewWebHooks(server.URL + "/profile/start")
+	profile.SendAfter = config.WebHooks{{URL: server.URL + "/profile/done", Body: "{{ .ProfileName }} {{ .Command }}"}}
+	profile.SendAfterFail = config.NewWebHooks(server.URL + "/profile/fail")
+	profile.Backup = &config.BackupSection{
+		RunShellCommandsSection: config.RunShellCommandsSection{
+			SendBefore: config.NewWebHooks(server.URL + "/backup/start"),
+			SendAfter:  config.WebHooks{{URL: server.URL + "/backup/done", Method: "put", Body: "{{ .Stats.Success }} {{ .Stats.Summary.FilesNew }}"}},
+		},
+	}
+	wrapper := newResticWrapper(`echo '{"message_type":"summary","files_new":3}'; exit 0;`, false, false, profile, "backup", nil, nil)
+	err := wrapper.runProfile()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"GET /profile/start", "GET /backup/start", "PUT /backup/done", "POST /profile/done"}, requests)
+	assert.Equal(t, "true 3", bodies["/backup/done"])
+	assert.Equal(t, "name backup", bodies["/profile/done"])
+}
+
+func TestSendWebHooksAfterFail(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	term.SetOutput(buffer)
+	defer term.SetOutput(os.Stdout)
+
+	requests := make([]string, 0, 2)
+	body := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		content, _ := ioutil.ReadAll(r.Body)
+		body = string(content)
+		// a failure of the web hook doesn't change the result of the profile
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	profile := config.NewProfile(nil, "name")
+	profile.SendAfter = config.NewWebHooks(server.URL + "/profile/done")
+	profile.SendAfterFail = config.WebHooks{{URL: server.URL + "/profile/fail", Body: "{{ .Command }}: {{ .Error }} ({{ .Stats.ExitCode }}) {{ .Stderr }}"}}
+	profile.Check = &config.OtherSectionWithSchedule{}
+	wrapper := newResticWrapper("echo broken repository >&2; exit 2;", false, false, profile, "check", nil, nil)
+	err := wrapper.runProfile()
+	require.Error(t, err)
+
+	assert.Equal(t, []string{"POST /profile/fail"}, requests)
+	assert.Equal(t, "check: check on profile 'name': exit status 2 (2) broken repository", body)
+}
+
+func TestSendWebHooksDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request expected in dry-run")
+	}))
+	defer server.Close()
+
+	profile := config.NewProfile(nil, "name")
+	wrapper := newResticWrapper("echo", false, true, profile, "backup", nil, nil)
+	wrapper.sendWebHooks("send-after", config.NewWebHooks(server.URL), nil)
+}